@@ -0,0 +1,129 @@
+// Package budget tracks API calls and estimated spend across every provider
+// request made in a single gogo invocation.
+package budget
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrExceeded is returned when a new request would exceed the budget.
+var ErrExceeded = errors.New("budget exceeded")
+
+// Budget is safe for concurrent use. A nil *Budget imposes no limits.
+type Budget struct {
+	// MaxCalls caps the number of provider requests; 0 means unlimited.
+	MaxCalls int
+	// MaxUSD caps estimated spend in US dollars; 0 means unlimited.
+	MaxUSD float64
+
+	mu    sync.Mutex
+	calls int
+	usd   float64
+}
+
+// New returns a Budget, or nil when neither limit is set.
+func New(maxCalls int, maxUSD float64) *Budget {
+	if maxCalls <= 0 && maxUSD <= 0 {
+		return nil
+	}
+	return &Budget{MaxCalls: maxCalls, MaxUSD: maxUSD}
+}
+
+// BeginCall reserves one request, failing with ErrExceeded if the call or
+// spend limit has already been reached.
+func (b *Budget) BeginCall() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.MaxCalls > 0 && b.calls >= b.MaxCalls {
+		return fmt.Errorf("%w: reached %d API calls", ErrExceeded, b.MaxCalls)
+	}
+	if b.MaxUSD > 0 && b.usd >= b.MaxUSD {
+		return fmt.Errorf("%w: estimated spend $%.4f reached limit $%.4f", ErrExceeded, b.usd, b.MaxUSD)
+	}
+	b.calls++
+	return nil
+}
+
+// AddUsage records the token usage of a completed request.
+func (b *Budget) AddUsage(model string, inputTokens, outputTokens int) {
+	if b == nil {
+		return
+	}
+	cost := EstimateUSD(model, inputTokens, outputTokens)
+	b.mu.Lock()
+	b.usd += cost
+	b.mu.Unlock()
+}
+
+// Spent returns the calls made and estimated spend so far.
+func (b *Budget) Spent() (calls int, usd float64) {
+	if b == nil {
+		return 0, 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.calls, b.usd
+}
+
+// price is USD per million tokens.
+type price struct {
+	input, output float64
+}
+
+// prices maps model id prefixes to list prices. Longest prefix wins.
+var prices = map[string]price{
+	"gpt-4o-mini":       {0.15, 0.60},
+	"gpt-4o":            {2.50, 10.00},
+	"gpt-4.1-nano":      {0.10, 0.40},
+	"gpt-4.1-mini":      {0.40, 1.60},
+	"gpt-4.1":           {2.00, 8.00},
+	"o1-mini":           {1.10, 4.40},
+	"o1":                {15.00, 60.00},
+	"o3-mini":           {1.10, 4.40},
+	"o3":                {2.00, 8.00},
+	"o4-mini":           {1.10, 4.40},
+	"claude-3-haiku":    {0.25, 1.25},
+	"claude-3-5-haiku":  {0.80, 4.00},
+	"claude-3-5-sonnet": {3.00, 15.00},
+	"claude-3-7-sonnet": {3.00, 15.00},
+	"claude-sonnet-4":   {3.00, 15.00},
+	"claude-3-opus":     {15.00, 75.00},
+	"claude-opus-4":     {15.00, 75.00},
+	"gemini-1.5-flash":  {0.075, 0.30},
+	"gemini-1.5-pro":    {1.25, 5.00},
+	"gemini-2.0-flash":  {0.10, 0.40},
+	"gemini-2.5-flash":  {0.30, 2.50},
+	"gemini-2.5-pro":    {1.25, 10.00},
+}
+
+// unknownPrice is used for models missing from the table. It is deliberately
+// high so an unrecognized model can't silently bypass a spend limit.
+var unknownPrice = price{15.00, 75.00}
+
+var pricePrefixes = func() []string {
+	keys := make([]string, 0, len(prices))
+	for k := range prices {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	return keys
+}()
+
+// EstimateUSD estimates the cost of a request from its token usage.
+func EstimateUSD(model string, inputTokens, outputTokens int) float64 {
+	p := unknownPrice
+	for _, prefix := range pricePrefixes {
+		if strings.HasPrefix(model, prefix) {
+			p = prices[prefix]
+			break
+		}
+	}
+	return (float64(inputTokens)*p.input + float64(outputTokens)*p.output) / 1e6
+}
@@ -0,0 +1,75 @@
+package budget
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestNilBudget(t *testing.T) {
+	var b *Budget
+	if New(0, 0) != nil {
+		t.Fatal("expected nil budget without limits")
+	}
+	for i := 0; i < 100; i++ {
+		if err := b.BeginCall(); err != nil {
+			t.Fatalf("nil budget should not limit: %v", err)
+		}
+	}
+	b.AddUsage("gpt-4o", 1000, 1000)
+}
+
+func TestCallBudget(t *testing.T) {
+	b := New(2, 0)
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- b.BeginCall()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	exceeded := 0
+	for err := range errs {
+		if errors.Is(err, ErrExceeded) {
+			exceeded++
+		}
+	}
+	if exceeded != 3 {
+		t.Fatalf("expected 3 calls over budget, got %d", exceeded)
+	}
+	if calls, _ := b.Spent(); calls != 2 {
+		t.Fatalf("expected 2 calls recorded, got %d", calls)
+	}
+}
+
+func TestUSDBudget(t *testing.T) {
+	b := New(0, 0.01)
+	if err := b.BeginCall(); err != nil {
+		t.Fatal(err)
+	}
+	// gpt-4o: 10k input * $2.50/M + 1k output * $10/M = $0.035
+	b.AddUsage("gpt-4o-2024-08-06", 10000, 1000)
+	if _, usd := b.Spent(); math.Abs(usd-0.035) > 1e-9 {
+		t.Fatalf("unexpected spend: %v", usd)
+	}
+	if err := b.BeginCall(); !errors.Is(err, ErrExceeded) {
+		t.Fatalf("expected ErrExceeded, got %v", err)
+	}
+}
+
+func TestEstimateUSDPrefix(t *testing.T) {
+	mini := EstimateUSD("gpt-4o-mini", 1e6, 0)
+	full := EstimateUSD("gpt-4o", 1e6, 0)
+	if mini != 0.15 || full != 2.50 {
+		t.Fatalf("longest prefix not chosen: mini=%v full=%v", mini, full)
+	}
+	if got := EstimateUSD("mystery-model", 1e6, 0); got != unknownPrice.input {
+		t.Fatalf("unknown model should use fallback price, got %v", got)
+	}
+}
@@ -0,0 +1,127 @@
+// Package cache stores full model responses on disk so repeated
+// deterministic calls can be replayed without an API request.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultTTL is how long a cached response stays valid.
+const DefaultTTL = 24 * time.Hour
+
+// Key identifies a request. Everything that changes the model's answer
+// must be part of it.
+type Key struct {
+	Provider    string  `json:"provider"`
+	Model       string  `json:"model"`
+	System      string  `json:"system"`
+	Prompt      string  `json:"prompt"`
+	Temperature float64 `json:"temperature"`
+	Tools       string  `json:"tools"`
+
+	// AppendSystem is omitted when empty so keys from before it existed
+	// still match.
+	AppendSystem string `json:"append_system,omitempty"`
+
+	// Params holds the remaining settings that shape the request, such as
+	// sampling parameters, limits, endpoint, headers and tool schemas, as
+	// JSON.
+	Params string `json:"params,omitempty"`
+}
+
+// Hash returns the hex SHA-256 of the key.
+func (k Key) Hash() string {
+	b, _ := json.Marshal(k)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Dir returns the cache directory, ~/.cache/gogo.
+func Dir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".cache", "gogo"), nil
+}
+
+// Store is a directory of cached responses, one file per key.
+type Store struct {
+	Dir string
+	TTL time.Duration
+}
+
+func (s *Store) path(k Key) string {
+	return filepath.Join(s.Dir, k.Hash()+".txt")
+}
+
+// Get returns the cached response for k if present and not expired.
+func (s *Store) Get(k Key) (string, bool) {
+	p := s.path(k)
+	info, err := os.Stat(p)
+	if err != nil {
+		return "", false
+	}
+	if s.TTL > 0 && time.Since(info.ModTime()) > s.TTL {
+		return "", false
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// Put stores text as the response for k. The file is written to a temp
+// name and renamed so a concurrent reader never sees a partial response.
+func (s *Store) Put(k Key, text string) error {
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.Dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(text); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path(k))
+}
+
+// Streamer is the subset of provider.Client the cache wraps.
+type Streamer interface {
+	Stream(ctx context.Context, prompt string, out io.Writer) error
+}
+
+// Stream replays a cached response for k to out, or calls client.Stream
+// and stores the response once it completes successfully. A failed or
+// cancelled stream is never cached. The bool reports a cache hit.
+func (s *Store) Stream(ctx context.Context, k Key, client Streamer, out io.Writer) (bool, error) {
+	if text, ok := s.Get(k); ok {
+		_, err := io.WriteString(out, text)
+		return true, err
+	}
+	var buf bytes.Buffer
+	if err := client.Stream(ctx, k.Prompt, io.MultiWriter(out, &buf)); err != nil {
+		return false, err
+	}
+	if err := s.Put(k, buf.String()); err != nil {
+		return false, fmt.Errorf("cache write: %w", err)
+	}
+	return false, nil
+}
@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"gogo/internal/plugin"
+)
+
+type fakeClient struct {
+	calls int
+	text  string
+	err   error
+}
+
+func (f *fakeClient) Stream(_ context.Context, _ string, out io.Writer) error {
+	f.calls++
+	io.WriteString(out, f.text)
+	return f.err
+}
+
+func TestStreamMissThenHit(t *testing.T) {
+	store := &Store{Dir: t.TempDir(), TTL: time.Hour}
+	client := &fakeClient{text: "hello"}
+	key := Key{Provider: "openai", Model: "gpt-4o-mini", Prompt: "hi"}
+
+	var out bytes.Buffer
+	hit, err := store.Stream(context.Background(), key, client, &out)
+	if err != nil || hit {
+		t.Fatalf("first call: hit=%v err=%v", hit, err)
+	}
+	if out.String() != "hello" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	out.Reset()
+	hit, err = store.Stream(context.Background(), key, client, &out)
+	if err != nil || !hit {
+		t.Fatalf("second call: hit=%v err=%v", hit, err)
+	}
+	if out.String() != "hello" || client.calls != 1 {
+		t.Fatalf("expected replay without a call, got %q after %d calls", out.String(), client.calls)
+	}
+}
+
+func TestStreamKeyDiffers(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+	client := &fakeClient{text: "x"}
+	base := Key{Provider: "openai", Model: "m", Prompt: "p"}
+
+	for _, k := range []Key{base, {Provider: "openai", Model: "m2", Prompt: "p"}, {Provider: "openai", Model: "m", Prompt: "p", Tools: "fs"}, {Provider: "openai", Model: "m", Prompt: "p", Params: `{"max_tokens":10}`}} {
+		if _, err := store.Stream(context.Background(), k, client, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client.calls != 4 {
+		t.Fatalf("expected a call per distinct key, got %d", client.calls)
+	}
+}
+
+func TestKeyStableToolOrder(t *testing.T) {
+	names := []string{"fs", "fetch", "shell", "weather", "search", "calc", "time", "notes"}
+	build := func(order []string) Key {
+		r := plugin.NewRegistry()
+		for _, n := range order {
+			if err := r.Register(&plugin.Tool{Name: n, Description: "tool " + n, Type: "http", URL: "http://example.com"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return Key{Provider: "openai", Model: "m", Prompt: "p", Tools: r.GenerateInstruction()}
+	}
+	reversed := make([]string, len(names))
+	for i, n := range names {
+		reversed[len(names)-1-i] = n
+	}
+	want := build(names).Hash()
+	for i := 0; i < 5; i++ {
+		if got := build(reversed).Hash(); got != want {
+			t.Fatalf("same tools hashed differently: %s vs %s", got, want)
+		}
+	}
+}
+
+func TestStreamErrorNotCached(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+	key := Key{Prompt: "p"}
+	failing := &fakeClient{text: "partial", err: errors.New("boom")}
+	if _, err := store.Stream(context.Background(), key, failing, io.Discard); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, ok := store.Get(key); ok {
+		t.Fatal("failed response was cached")
+	}
+}
+
+func TestGetExpired(t *testing.T) {
+	store := &Store{Dir: t.TempDir(), TTL: time.Minute}
+	key := Key{Prompt: "p"}
+	if err := store.Put(key, "old"); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(store.path(key), past, past); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Get(key); ok {
+		t.Fatal("expired entry returned")
+	}
+}
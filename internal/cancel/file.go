@@ -0,0 +1,54 @@
+// Package cancel provides non-signal cancellation for embedded and daemonized
+// use, where gogo runs without a controlling terminal.
+package cancel
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// PollInterval is how often the control file is checked.
+const PollInterval = 100 * time.Millisecond
+
+// WatchFile returns a context that is cancelled when the file at path appears
+// or, if it already exists, when its modification time changes. The watcher
+// stops when the returned context is done.
+func WatchFile(parent context.Context, path string, interval time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	if interval <= 0 {
+		interval = PollInterval
+	}
+
+	initial, existed := modTime(path)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				mt, ok := modTime(path)
+				if !ok {
+					continue
+				}
+				if !existed || !mt.Equal(initial) {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	return ctx, cancel
+}
+
+func modTime(path string) (time.Time, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
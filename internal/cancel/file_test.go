@@ -0,0 +1,74 @@
+package cancel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchFileAppears(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cancel")
+
+	ctx, cancel := WatchFile(context.Background(), path, 10*time.Millisecond)
+	defer cancel()
+
+	// Simulate an in-flight stream that runs until cancelled.
+	done := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		done <- ctx.Err()
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("context cancelled before file was created")
+	default:
+	}
+
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("context was not cancelled after file appeared")
+	}
+}
+
+func TestWatchFileTouched(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cancel")
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := WatchFile(context.Background(), path, 10*time.Millisecond)
+	defer cancel()
+
+	time.Sleep(30 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("context cancelled before file was touched")
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("context was not cancelled after file was touched")
+	}
+}
@@ -0,0 +1,125 @@
+// Package compare sends one prompt to several providers at once and lays
+// their answers out side by side for A/B comparisons.
+package compare
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"gogo/internal/config"
+	"gogo/internal/history"
+	"gogo/internal/plugin"
+	"gogo/internal/provider"
+)
+
+// Target is one side of a comparison: a provider and, optionally, a model.
+type Target struct {
+	Provider string
+	Model    string
+}
+
+// ParseTargets parses "provider[:model]" entries, e.g. "openai" or
+// "anthropic:claude-3-5-sonnet-latest".
+func ParseTargets(list []string) ([]Target, error) {
+	if len(list) < 2 {
+		return nil, fmt.Errorf("--compare needs at least two providers, got %d", len(list))
+	}
+	targets := make([]Target, 0, len(list))
+	for _, s := range list {
+		p, m, _ := strings.Cut(s, ":")
+		if p == "" {
+			return nil, fmt.Errorf("invalid --compare entry %q: want provider or provider:model", s)
+		}
+		targets = append(targets, Target{Provider: p, Model: m})
+	}
+	return targets, nil
+}
+
+// Result is the outcome of one target's run.
+type Result struct {
+	Provider string
+	Model    string
+	Text     string
+	Usage    provider.Usage
+	Elapsed  time.Duration
+	Err      error
+}
+
+// Run streams prompt to every target concurrently, each with its own copy
+// of cfg, and returns the results in target order. Responses are buffered
+// so they can be written as whole sections.
+func Run(ctx context.Context, cfg config.Config, targets []Target, hist []history.Message, prompt string, stderr io.Writer, tools *plugin.Registry) []Result {
+	results := make([]Result, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		tcfg := cfg.ForProvider(t.Provider, t.Model)
+		results[i] = Result{Provider: tcfg.Provider, Model: tcfg.Model}
+		wg.Add(1)
+		go func(r *Result, tcfg config.Config) {
+			defer wg.Done()
+			client := provider.NewClient(tcfg, stderr, tools)
+			client.SetHistory(hist)
+			var buf bytes.Buffer
+			start := time.Now()
+			r.Err = client.Stream(ctx, prompt, &buf)
+			r.Elapsed = time.Since(start)
+			r.Text = buf.String()
+			r.Usage = client.Usage()
+		}(&results[i], tcfg)
+	}
+	wg.Wait()
+	return results
+}
+
+// label names a result in section headers and the summary.
+func (r Result) label() string {
+	return r.Provider + " (" + r.Model + ")"
+}
+
+// Write writes each result as a section headed "=== provider (model) ===",
+// with a blank line between sections. A failed run keeps whatever text
+// arrived before the error.
+func Write(w io.Writer, results []Result) error {
+	for i, r := range results {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+		text := r.Text
+		if text != "" && !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		if _, err := fmt.Fprintf(w, "=== %s ===\n%s", r.label(), text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Summary writes one line per result with its elapsed time and token
+// usage, or its error.
+func Summary(w io.Writer, results []Result) {
+	for _, r := range results {
+		if r.Err != nil {
+			fmt.Fprintf(w, "%s: %.2fs, error: %v\n", r.label(), r.Elapsed.Seconds(), r.Err)
+			continue
+		}
+		fmt.Fprintf(w, "%s: %.2fs, %d input / %d output tokens\n", r.label(), r.Elapsed.Seconds(), r.Usage.InputTokens, r.Usage.OutputTokens)
+	}
+}
+
+// Err returns the first failure among results, or nil.
+func Err(results []Result) error {
+	for _, r := range results {
+		if r.Err != nil {
+			return r.Err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package compare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gogo/internal/config"
+	"gogo/internal/plugin"
+	"gogo/internal/provider"
+)
+
+func TestParseTargets(t *testing.T) {
+	got, err := ParseTargets([]string{"openai", "anthropic:claude-3-5-sonnet-latest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Target{{Provider: "openai"}, {Provider: "anthropic", Model: "claude-3-5-sonnet-latest"}}
+	if len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for _, bad := range [][]string{{"openai"}, {"openai", ":m"}} {
+		if _, err := ParseTargets(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	// Replies with the requested model name after a delay, so the runs
+	// must overlap to finish in time.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model == "fail" {
+			http.Error(w, `{"error":{"message":"bad model"}}`, http.StatusBadRequest)
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":\"from %s\"}}]}\n\n", req.Model)
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":2}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+	t.Setenv(provider.DefaultCompatKeyEnv, "test")
+
+	cfg := config.Config{Provider: "openai", Model: "gpt-4o-mini", BaseURL: srv.URL}
+	targets := []Target{{Provider: "openai-compatible", Model: "a"}, {Provider: "openai-compatible", Model: "b"}, {Provider: "openai-compatible", Model: "fail"}}
+	start := time.Now()
+	results := Run(context.Background(), cfg, targets, nil, "hi", io.Discard, plugin.NewRegistry())
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("runs did not overlap: took %v", elapsed)
+	}
+
+	for i, model := range []string{"a", "b"} {
+		r := results[i]
+		if r.Err != nil || r.Text != "from "+model || r.Model != model {
+			t.Errorf("result %d = %+v", i, r)
+		}
+		if r.Usage.InputTokens != 5 || r.Usage.OutputTokens != 2 {
+			t.Errorf("result %d usage = %+v", i, r.Usage)
+		}
+	}
+	var pe *provider.ProviderError
+	if !errors.As(Err(results), &pe) || pe.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected the failed run's error, got %v", Err(results))
+	}
+
+	var out, summary bytes.Buffer
+	if err := Write(&out, results[:2]); err != nil {
+		t.Fatal(err)
+	}
+	want := "=== openai-compatible (a) ===\nfrom a\n\n=== openai-compatible (b) ===\nfrom b\n"
+	if out.String() != want {
+		t.Errorf("sections:\n got %q\nwant %q", out.String(), want)
+	}
+	Summary(&summary, results)
+	lines := strings.Split(strings.TrimSpace(summary.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], "5 input / 2 output tokens") || !strings.Contains(lines[2], "error:") {
+		t.Errorf("unexpected summary:\n%s", summary.String())
+	}
+}
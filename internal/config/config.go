@@ -3,68 +3,390 @@ package config
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
+
+	"gogo/internal/budget"
+	"gogo/internal/dotenv"
 )
 
 type Flags struct {
-	Prompt      string
-	Provider    string
-	Model       string
-	MaxTokens   int
-	Temperature float64
-	ConfigPath  string
-	Timeout     time.Duration
-	Version     bool
-	Update      bool
-	Debug       bool
+	Prompt         string
+	PromptFile     string
+	StdinFirst     bool
+	AllowBinary    bool
+	Files          []string
+	Provider       string
+	Model          string
+	BaseURL        string
+	MaxTokens      int
+	Temperature    float64
+	TopP           float64
+	TopK           int
+	Stop           []string
+	Headers        []string
+	MaxToolRounds  int
+	ConfigPath     string
+	Timeout        time.Duration
+	RequestTimeout time.Duration
+	IdleTimeout    time.Duration
+	CancelFile     string
+	Summarize      string
+	Redact         []string
+	RedactSecrets  bool
+	System         string
+	SystemFile     string
+	AppendSystem   string
+	CacheSystem    bool
+	Docs           []string
+	StripANSI      bool
+	Render         bool
+	ConfirmShell   bool
+	ConfirmFS      bool
+	ShowDiff       bool
+	BackupDir      string
+	Yes            bool
+	Format         string
+	Tools          []string
+	History        string
+	HistoryAppend  bool
+	LoadTranscript string
+	SaveTranscript string
+	DumpMessages   bool
+	CountTokens    bool
+	NoStream       bool
+	Raw            bool
+	Compare        []string
+	Continue       string
+	ThinkingBudget *int
+	Reasoning      string
+	ListTools      bool
+	EnvFile        string
+	StrictTools    bool
+	Init           bool
+	Force          bool
+	BudgetCalls    int
+	BudgetUSD      float64
+	Cache          bool
+	NoCache        bool
+	CacheTTL       time.Duration
+	Version        bool
+	Update         bool
+	SetKey         string
+	Debug          bool
+	Quiet          bool
+	LogFormat      string
 }
 
+// DefaultAnthropicMaxTokens is used when no max tokens are configured for
+// anthropic, whose API rejects requests without max_tokens.
+const DefaultAnthropicMaxTokens = 4096
+
+// DefaultMaxToolRounds is how many rounds of tool calls a provider loop runs
+// before it stops and returns what it has.
+const DefaultMaxToolRounds = 1
+
+// DefaultIdleTimeout is how long a stream may go without an event.
+const DefaultIdleTimeout = 60 * time.Second
+
 type Config struct {
 	Provider    string
 	Model       string
 	MaxTokens   int
 	Temperature float64
+	TopP        float64
+	TopK        int
+	Stop        []string
 	Timeout     time.Duration
+	IdleTimeout time.Duration
 	Debug       bool
+
+	// RequestTimeout bounds each provider HTTP call, where Timeout bounds
+	// the whole run including every tool round.
+	RequestTimeout time.Duration
+	Quiet          bool
+
+	// MaxToolRounds caps the rounds of tool calls per run. Zero means
+	// DefaultMaxToolRounds.
+	MaxToolRounds int
+
+	// FinalTemperature, when set, replaces Temperature for the request
+	// sent after the last permitted tool round. Zero is a valid setting.
+	FinalTemperature *float64
+
+	// ExplicitTemperature sends Temperature even when it is zero, which
+	// otherwise leaves the provider default. It is set on the final-round
+	// config when FinalTemperature is 0.
+	ExplicitTemperature bool
+
+	// LogFormat is "text" or "json" for tool logs written under Debug.
+	LogFormat string
+
+	// BaseURL, APIKeyEnv and CompatTools configure the openai-compatible
+	// provider: the API root (ending before /chat/completions), the
+	// environment variable holding the key, and whether to send tools.
+	BaseURL     string
+	APIKeyEnv   string
+	CompatTools bool
+
+	// System is an extra system prompt placed ahead of the generated tool
+	// instruction.
+	System string
+
+	// AppendSystem is an extra system prompt placed after the generated
+	// tool instruction.
+	AppendSystem string
+
+	// APIKeys are keys from the config file by provider name. Environment
+	// variables take precedence.
+	APIKeys map[string]string
+
+	// Warnings are problems found while loading that do not stop the run.
+	Warnings []string
+
+	// Builtins names the built-in tools to register. Nil means the plugin
+	// package default; an empty list registers none.
+	Builtins []string
+
+	// NoStream requests one complete response instead of a stream. Tools
+	// are not offered in this mode.
+	NoStream bool
+
+	// Raw sends the prompt alone: no system prompt, no tool instructions
+	// and no tools.
+	Raw bool
+
+	// ThinkingBudget is the Gemini thinkingBudget in tokens: 0 turns
+	// thinking off where the model allows it and -1 lets the model decide.
+	// Nil leaves the model's default.
+	ThinkingBudget *int
+
+	// ReasoningEffort is the OpenAI reasoning.effort: low, medium or high.
+	ReasoningEffort string
+
+	// ContinueID is an OpenAI response ID the first request continues
+	// from, via previous_response_id.
+	ContinueID string
+
+	// StrictTools aborts the run when a tool returns an error result
+	// instead of passing the error back to the model.
+	StrictTools bool
+
+	// Headers are extra HTTP headers sent with every provider request.
+	Headers map[string]string
+
+	// CacheSystem marks the system prompt for provider-side prompt caching
+	// (anthropic only).
+	CacheSystem bool
+
+	// ParamMap renames top-level request body keys for the active provider
+	// (e.g. max_tokens -> max_completion_tokens) before sending.
+	ParamMap map[string]string
+
+	// Docs are PDF or text documents attached to the prompt.
+	Docs []string
+
+	// DumpMessages prints each request's message array to stderr.
+	DumpMessages bool
+
+	// Budget is shared call and spend accounting for every provider request
+	// in the run. Nil means unlimited.
+	Budget *budget.Budget
+
+	// paramMaps, aliases and defaultMaxTokens keep what ForProvider needs
+	// to redo the provider-specific parts of Load.
+	paramMaps        map[string]map[string]string
+	aliases          map[string]string
+	defaultMaxTokens bool
+}
+
+// ForProvider returns a copy of c that targets provider, with model or,
+// when model is empty, the provider's default. Everything else carries
+// over, except the param_map entry and the default max tokens, which
+// belong to the provider.
+func (c Config) ForProvider(provider, model string) Config {
+	if c.defaultMaxTokens {
+		c.MaxTokens = 0
+		c.defaultMaxTokens = false
+	}
+	c.Provider = provider
+	c.Model = model
+	applyDefaults(&c)
+	if alias, ok := c.aliases[c.Model]; ok {
+		c.Model = alias
+	}
+	c.ParamMap = c.paramMaps[provider]
+	return c
 }
 
 type fileConfig struct {
-	Provider    string  `json:"provider"`
-	Model       string  `json:"model"`
-	MaxTokens   int     `json:"max_tokens"`
-	Temperature float64 `json:"temperature"`
-	TimeoutMS   int     `json:"timeout_ms"`
+	Provider    string    `json:"provider"`
+	Model       string    `json:"model"`
+	MaxTokens   int       `json:"max_tokens"`
+	Temperature float64   `json:"temperature"`
+	TopP        float64   `json:"top_p"`
+	TopK        int       `json:"top_k"`
+	Stop        []string  `json:"stop"`
+	TimeoutMS   int       `json:"timeout_ms"`
+	RequestMS   int       `json:"request_timeout_ms"`
+	System      string    `json:"system_prompt"`
+	IdleMS      int       `json:"idle_timeout_ms"`
+	BaseURL     string    `json:"base_url"`
+	APIKeyEnv   string    `json:"api_key_env"`
+	CompatTools bool      `json:"supports_tools"`
+	MaxRounds   int       `json:"max_tool_rounds"`
+	FinalTemp   *float64  `json:"final_temperature"`
+	Builtins    *[]string `json:"builtins"`
+	Dotenv      bool      `json:"dotenv"`
+
+	// APIKeys maps provider names to API keys, used when the provider's
+	// environment variable is unset.
+	APIKeys map[string]string `json:"api_keys"`
+
+	ParamMap     map[string]map[string]string `json:"param_map"`
+	ModelAliases map[string]string            `json:"model_aliases"`
 }
 
 func Load(flags Flags) (Config, error) {
 	cfg := Config{}
 
+	if flags.AppendSystem != "" && (flags.System != "" || flags.SystemFile != "") {
+		return cfg, errors.New("--system and --append-system cannot be used together")
+	}
+
+	if flags.Raw && (flags.System != "" || flags.SystemFile != "" || flags.AppendSystem != "") {
+		return cfg, errors.New("--raw cannot be used with --system or --append-system")
+	}
+	if b := flags.ThinkingBudget; b != nil && *b < -1 {
+		return cfg, fmt.Errorf("invalid thinking budget %d: must be -1 (dynamic), 0 (off), or a token count", *b)
+	}
+	switch flags.Reasoning {
+	case "", "low", "medium", "high":
+	default:
+		return cfg, fmt.Errorf("invalid reasoning effort %q: must be low, medium, or high", flags.Reasoning)
+	}
+	if flags.Raw && len(flags.Tools) > 0 {
+		return cfg, errors.New("--raw cannot be used with --tools")
+	}
+	if flags.Raw && flags.Summarize != "" {
+		return cfg, errors.New("--raw cannot be used with --summarize")
+	}
+
+	// An inline --system wins over --system-file, but the file is still
+	// read so a bad path fails before any request is made.
+	if flags.SystemFile != "" {
+		b, err := os.ReadFile(flags.SystemFile)
+		if err != nil {
+			return cfg, fmt.Errorf("read system file: %w", err)
+		}
+		if flags.System == "" {
+			flags.System = strings.TrimSpace(string(b))
+		}
+	}
+
 	fcfg, _ := readFileConfig(flags.ConfigPath)
 	applyFile(&cfg, fcfg)
+	// The .env file is loaded before the environment is read, so it can
+	// hold GOGO_* settings as well as API keys.
+	envFile := flags.EnvFile
+	if envFile == "" && fcfg.Dotenv {
+		if _, err := os.Stat(".env"); err == nil {
+			envFile = ".env"
+		}
+	}
+	if envFile != "" {
+		if err := dotenv.Load(envFile); err != nil {
+			return cfg, fmt.Errorf("env file: %w", err)
+		}
+	}
+	// Headers are expanded after the .env file is loaded, so $TOKEN can
+	// come from it.
+	headers, err := parseHeaders(flags.Headers)
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Headers = headers
+	if len(fcfg.APIKeys) > 0 {
+		if path, err := filePath(flags.ConfigPath); err == nil {
+			if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0004 != 0 {
+				cfg.Warnings = append(cfg.Warnings, fmt.Sprintf("%s stores api_keys but is world-readable; run chmod 600 %s", path, path))
+			}
+		}
+	}
 	applyEnv(&cfg)
 	applyFlags(&cfg, flags)
 	applyDefaults(&cfg)
+	if alias, ok := fcfg.ModelAliases[cfg.Model]; ok {
+		cfg.Model = alias
+	}
+	cfg.ParamMap = fcfg.ParamMap[cfg.Provider]
+	cfg.paramMaps = fcfg.ParamMap
+	cfg.aliases = fcfg.ModelAliases
+	// A system_prompt from the config file would be injected text too.
+	if cfg.Raw {
+		cfg.System = ""
+	}
 
-	if cfg.Provider == "" {
-		return cfg, errors.New("provider is required")
+	// --list-tools makes no request, so it works without a provider.
+	if !flags.ListTools {
+		if cfg.Provider == "" {
+			return cfg, errors.New("provider is required")
+		}
+		if cfg.Model == "" {
+			return cfg, errors.New("model is required")
+		}
+		if cfg.Provider == "openai-compatible" && cfg.BaseURL == "" {
+			return cfg, errors.New("openai-compatible provider requires base_url (config) or --base-url")
+		}
 	}
-	if cfg.Model == "" {
-		return cfg, errors.New("model is required")
+	if cfg.ContinueID != "" && cfg.Provider != "openai" {
+		return cfg, fmt.Errorf("--continue only works with the openai provider, not %s", cfg.Provider)
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		return cfg, fmt.Errorf("invalid log format %q: must be text or json", cfg.LogFormat)
 	}
 
 	return cfg, nil
 }
 
-func readFileConfig(path string) (fileConfig, error) {
-	if path == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			return fileConfig{}, err
+// parseHeaders turns "Key: Value" strings into a header map, expanding
+// $VAR and ${VAR} in the values.
+func parseHeaders(list []string) (map[string]string, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+	headers := make(map[string]string, len(list))
+	for _, h := range list {
+		key, value, ok := strings.Cut(h, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("invalid header %q: want 'Key: Value'", h)
 		}
-		path = filepath.Join(home, ".config", "gogo", "config.json")
+		headers[key] = os.ExpandEnv(strings.TrimSpace(value))
+	}
+	return headers, nil
+}
+
+// filePath returns path, or the default config.json location when empty.
+func filePath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.json"), nil
+}
+
+func readFileConfig(path string) (fileConfig, error) {
+	path, err := filePath(path)
+	if err != nil {
+		return fileConfig{}, err
 	}
 
 	b, err := os.ReadFile(path)
@@ -95,9 +417,43 @@ func applyFile(cfg *Config, f fileConfig) {
 	if f.Temperature != 0 {
 		cfg.Temperature = f.Temperature
 	}
+	if f.TopP > 0 {
+		cfg.TopP = f.TopP
+	}
+	if f.TopK > 0 {
+		cfg.TopK = f.TopK
+	}
+	if len(f.Stop) > 0 {
+		cfg.Stop = f.Stop
+	}
 	if f.TimeoutMS > 0 {
 		cfg.Timeout = time.Duration(f.TimeoutMS) * time.Millisecond
 	}
+	if f.RequestMS > 0 {
+		cfg.RequestTimeout = time.Duration(f.RequestMS) * time.Millisecond
+	}
+	if f.System != "" {
+		cfg.System = f.System
+	}
+	if f.IdleMS > 0 {
+		cfg.IdleTimeout = time.Duration(f.IdleMS) * time.Millisecond
+	}
+	if f.BaseURL != "" {
+		cfg.BaseURL = f.BaseURL
+	}
+	if f.MaxRounds > 0 {
+		cfg.MaxToolRounds = f.MaxRounds
+	}
+	if f.FinalTemp != nil {
+		t := *f.FinalTemp
+		cfg.FinalTemperature = &t
+	}
+	if f.Builtins != nil {
+		cfg.Builtins = append([]string{}, *f.Builtins...)
+	}
+	cfg.APIKeys = f.APIKeys
+	cfg.APIKeyEnv = f.APIKeyEnv
+	cfg.CompatTools = f.CompatTools
 }
 
 func applyEnv(cfg *Config) {
@@ -107,6 +463,9 @@ func applyEnv(cfg *Config) {
 	if v := os.Getenv("GOGO_MODEL"); v != "" {
 		cfg.Model = v
 	}
+	if v := os.Getenv("GOGO_BASE_URL"); v != "" {
+		cfg.BaseURL = v
+	}
 	if v := os.Getenv("GOGO_MAX_TOKENS"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
 			cfg.MaxTokens = n
@@ -131,26 +490,84 @@ func applyFlags(cfg *Config, f Flags) {
 	if f.Model != "" {
 		cfg.Model = f.Model
 	}
+	if f.BaseURL != "" {
+		cfg.BaseURL = f.BaseURL
+	}
 	if f.MaxTokens > 0 {
 		cfg.MaxTokens = f.MaxTokens
 	}
 	if f.Temperature != 0 {
 		cfg.Temperature = f.Temperature
 	}
+	if f.TopP > 0 {
+		cfg.TopP = f.TopP
+	}
+	if f.TopK > 0 {
+		cfg.TopK = f.TopK
+	}
+	if len(f.Stop) > 0 {
+		cfg.Stop = f.Stop
+	}
+	if f.MaxToolRounds > 0 {
+		cfg.MaxToolRounds = f.MaxToolRounds
+	}
 	if f.Timeout > 0 {
 		cfg.Timeout = f.Timeout
 	}
+	if f.RequestTimeout > 0 {
+		cfg.RequestTimeout = f.RequestTimeout
+	}
+	if f.IdleTimeout > 0 {
+		cfg.IdleTimeout = f.IdleTimeout
+	}
+	if f.System != "" {
+		cfg.System = f.System
+	}
+	cfg.AppendSystem = f.AppendSystem
+	cfg.Docs = f.Docs
+	cfg.DumpMessages = f.DumpMessages
+	cfg.CacheSystem = f.CacheSystem
+	cfg.NoStream = f.NoStream
+	cfg.Raw = f.Raw
+	cfg.ContinueID = f.Continue
+	cfg.ThinkingBudget = f.ThinkingBudget
+	cfg.ReasoningEffort = f.Reasoning
+	cfg.StrictTools = f.StrictTools
+	cfg.Budget = budget.New(f.BudgetCalls, f.BudgetUSD)
 	cfg.Debug = f.Debug
+	cfg.Quiet = f.Quiet
+	cfg.LogFormat = f.LogFormat
 }
 
 func applyDefaults(cfg *Config) {
-	if cfg.Provider == "openai" && cfg.Model == "" {
-		cfg.Model = "gpt-4o-mini"
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
 	}
-	if cfg.Provider == "anthropic" && cfg.Model == "" {
-		cfg.Model = "claude-3-5-haiku-latest"
+	if cfg.MaxToolRounds == 0 {
+		cfg.MaxToolRounds = DefaultMaxToolRounds
 	}
-	if cfg.Provider == "gemini" && cfg.Model == "" {
-		cfg.Model = "gemini-1.5-flash"
+	if cfg.LogFormat == "" {
+		cfg.LogFormat = "text"
+	}
+	if cfg.Model == "" {
+		cfg.Model = DefaultModel(cfg.Provider)
+	}
+	if cfg.Provider == "anthropic" && cfg.MaxTokens == 0 {
+		cfg.MaxTokens = DefaultAnthropicMaxTokens
+		cfg.defaultMaxTokens = true
+	}
+}
+
+// DefaultModel returns the model used for provider when none is
+// configured, or "" when the provider has no default.
+func DefaultModel(provider string) string {
+	switch provider {
+	case "openai":
+		return "gpt-4o-mini"
+	case "anthropic":
+		return "claude-3-5-haiku-latest"
+	case "gemini":
+		return "gemini-1.5-flash"
 	}
+	return ""
 }
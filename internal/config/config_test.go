@@ -1,8 +1,11 @@
 package config
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -60,3 +63,516 @@ func TestDefaults(t *testing.T) {
 		t.Fatalf("default model not set: %s", cfg.Model)
 	}
 }
+
+func TestAnthropicDefaultMaxTokens(t *testing.T) {
+	t.Setenv("GOGO_MAX_TOKENS", "")
+	missing := filepath.Join(t.TempDir(), "config.json")
+
+	cfg, err := Load(Flags{Provider: "anthropic", ConfigPath: missing})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.MaxTokens != DefaultAnthropicMaxTokens {
+		t.Fatalf("anthropic max tokens = %d, want %d", cfg.MaxTokens, DefaultAnthropicMaxTokens)
+	}
+
+	cfg, err = Load(Flags{Provider: "anthropic", ConfigPath: missing, MaxTokens: 256})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.MaxTokens != 256 {
+		t.Fatalf("explicit max tokens overridden: %d", cfg.MaxTokens)
+	}
+
+	cfg, err = Load(Flags{Provider: "openai", ConfigPath: missing})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.MaxTokens != 0 {
+		t.Fatalf("openai should keep the API default, got %d", cfg.MaxTokens)
+	}
+}
+
+func TestParamMapForProvider(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"param_map":{"openai":{"max_output_tokens":"max_completion_tokens"},"anthropic":{"x":"y"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(Flags{Provider: "openai", ConfigPath: path})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if got := cfg.ParamMap["max_output_tokens"]; got != "max_completion_tokens" {
+		t.Fatalf("param map not resolved for provider: %v", cfg.ParamMap)
+	}
+	if _, ok := cfg.ParamMap["x"]; ok {
+		t.Fatalf("param map leaked from another provider: %v", cfg.ParamMap)
+	}
+}
+
+func TestSystemPromptPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"provider":"openai","system_prompt":"from file"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(Flags{ConfigPath: path})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.System != "from file" {
+		t.Fatalf("file system prompt not applied: %q", cfg.System)
+	}
+
+	cfg, err = Load(Flags{ConfigPath: path, System: "from flag"})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.System != "from flag" {
+		t.Fatalf("flag should override file system prompt: %q", cfg.System)
+	}
+}
+
+func TestSystemFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "system.md")
+	if err := os.WriteFile(path, []byte("from system file\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(Flags{Provider: "openai", SystemFile: path})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.System != "from system file" {
+		t.Fatalf("system file not applied: %q", cfg.System)
+	}
+
+	cfg, err = Load(Flags{Provider: "openai", SystemFile: path, System: "inline"})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.System != "inline" {
+		t.Fatalf("inline --system should override --system-file: %q", cfg.System)
+	}
+
+	_, err = Load(Flags{Provider: "openai", SystemFile: filepath.Join(dir, "missing.md")})
+	if err == nil || !strings.Contains(err.Error(), "system file") {
+		t.Fatalf("expected system file error, got %v", err)
+	}
+}
+
+func TestAppendSystem(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"provider":"openai","system_prompt":"from file"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(Flags{ConfigPath: path, AppendSystem: "guardrail"})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.AppendSystem != "guardrail" || cfg.System != "from file" {
+		t.Fatalf("unexpected system prompts: %q / %q", cfg.System, cfg.AppendSystem)
+	}
+
+	for _, f := range []Flags{
+		{ConfigPath: path, AppendSystem: "guardrail", System: "inline"},
+		{ConfigPath: path, AppendSystem: "guardrail", SystemFile: path},
+	} {
+		if _, err := Load(f); err == nil || !strings.Contains(err.Error(), "--append-system") {
+			t.Fatalf("expected conflict error, got %v", err)
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"provider":"openai","system_prompt":"from file"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(Flags{ConfigPath: path, Raw: true})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if !cfg.Raw || cfg.System != "" {
+		t.Fatalf("expected raw config without a system prompt, got raw=%v system=%q", cfg.Raw, cfg.System)
+	}
+
+	for _, f := range []Flags{
+		{ConfigPath: path, Raw: true, System: "inline"},
+		{ConfigPath: path, Raw: true, AppendSystem: "guardrail"},
+		{ConfigPath: path, Raw: true, Tools: []string{"fs"}},
+		{ConfigPath: path, Raw: true, Summarize: "tldr"},
+	} {
+		if _, err := Load(f); err == nil || !strings.Contains(err.Error(), "--raw") {
+			t.Fatalf("expected conflict error, got %v", err)
+		}
+	}
+}
+
+func TestFinalTemperatureZero(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"provider":"openai","temperature":0.8,"final_temperature":0}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(Flags{ConfigPath: path})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.FinalTemperature == nil || *cfg.FinalTemperature != 0 {
+		t.Fatalf("final_temperature 0 should be kept, got %v", cfg.FinalTemperature)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"provider":"openai","temperature":0.8}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err = Load(Flags{ConfigPath: path}); err != nil || cfg.FinalTemperature != nil {
+		t.Fatalf("expected no final temperature, got %v, %v", cfg.FinalTemperature, err)
+	}
+}
+
+func TestContinueOpenAIOnly(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	if _, err := Load(Flags{ConfigPath: missing, Provider: "openai", Continue: "resp_1"}); err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	_, err := Load(Flags{ConfigPath: missing, Provider: "anthropic", Continue: "resp_1"})
+	if err == nil || !strings.Contains(err.Error(), "--continue") {
+		t.Fatalf("expected provider error, got %v", err)
+	}
+}
+
+func TestThinkingBudget(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	for _, n := range []int{-1, 0, 2048} {
+		n := n
+		cfg, err := Load(Flags{ConfigPath: missing, Provider: "gemini", ThinkingBudget: &n})
+		if err != nil || cfg.ThinkingBudget == nil || *cfg.ThinkingBudget != n {
+			t.Fatalf("budget %d: cfg=%v err=%v", n, cfg.ThinkingBudget, err)
+		}
+	}
+	bad := -2
+	if _, err := Load(Flags{ConfigPath: missing, Provider: "gemini", ThinkingBudget: &bad}); err == nil {
+		t.Fatal("expected error for a negative budget")
+	}
+}
+
+func TestReasoningEffort(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	cfg, err := Load(Flags{ConfigPath: missing, Provider: "openai", Reasoning: "low"})
+	if err != nil || cfg.ReasoningEffort != "low" {
+		t.Fatalf("effort=%q err=%v", cfg.ReasoningEffort, err)
+	}
+	if _, err := Load(Flags{ConfigPath: missing, Provider: "openai", Reasoning: "extreme"}); err == nil || !strings.Contains(err.Error(), "reasoning effort") {
+		t.Fatalf("expected invalid effort error, got %v", err)
+	}
+}
+
+func TestModelAlias(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"provider":"anthropic","model_aliases":{"sonnet":"claude-3-5-sonnet-latest"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(Flags{ConfigPath: path, Model: "sonnet"})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.Model != "claude-3-5-sonnet-latest" {
+		t.Fatalf("alias not resolved: %s", cfg.Model)
+	}
+
+	cfg, err = Load(Flags{ConfigPath: path, Model: "claude-3-opus-latest"})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.Model != "claude-3-opus-latest" {
+		t.Fatalf("non-alias model changed: %s", cfg.Model)
+	}
+}
+
+func TestForProvider(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	body := `{"provider":"anthropic","temperature":0.5,"model_aliases":{"fast":"gemini-2.0-flash"},"param_map":{"openai":{"max_tokens":"max_completion_tokens"}}}`
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(Flags{ConfigPath: path})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+
+	openai := cfg.ForProvider("openai", "")
+	if openai.Model != "gpt-4o-mini" || openai.MaxTokens != 0 || openai.Temperature != 0.5 || openai.ParamMap["max_tokens"] != "max_completion_tokens" {
+		t.Fatalf("unexpected openai config: %+v", openai)
+	}
+	if gemini := cfg.ForProvider("gemini", "fast"); gemini.Model != "gemini-2.0-flash" || gemini.ParamMap != nil {
+		t.Fatalf("unexpected gemini config: %+v", gemini)
+	}
+	if back := openai.ForProvider("anthropic", ""); back.MaxTokens != DefaultAnthropicMaxTokens {
+		t.Fatalf("anthropic default max tokens not restored: %d", back.MaxTokens)
+	}
+}
+
+func TestDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("GOGO_CONFIG_DIR", "")
+
+	xdg := t.TempDir()
+	override := t.TempDir()
+	tests := []struct {
+		xdg, override, want string
+	}{
+		{"", "", filepath.Join(home, ".config", "gogo")},
+		{"relative/dir", "", filepath.Join(home, ".config", "gogo")},
+		{xdg, "", filepath.Join(xdg, "gogo")},
+		{xdg, override, override},
+	}
+	for _, tc := range tests {
+		t.Setenv("XDG_CONFIG_HOME", tc.xdg)
+		t.Setenv("GOGO_CONFIG_DIR", tc.override)
+		if got, err := Dir(); err != nil || got != tc.want {
+			t.Errorf("XDG_CONFIG_HOME=%q GOGO_CONFIG_DIR=%q: got %q, %v; want %q", tc.xdg, tc.override, got, err, tc.want)
+		}
+	}
+
+	// The default config file is read from there.
+	t.Setenv("GOGO_CONFIG_DIR", override)
+	if err := os.WriteFile(filepath.Join(override, "config.json"), []byte(`{"provider":"gemini"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(Flags{})
+	if err != nil || cfg.Provider != "gemini" {
+		t.Fatalf("config not read from GOGO_CONFIG_DIR: provider=%q err=%v", cfg.Provider, err)
+	}
+}
+
+func TestEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	envPath := filepath.Join(dir, "keys.env")
+	if err := os.WriteFile(envPath, []byte("GOGO_MODEL=from-dotenv\nGOGO_PROVIDER=gemini\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOGO_MODEL", "")
+	os.Unsetenv("GOGO_MODEL")
+	t.Setenv("GOGO_PROVIDER", "anthropic")
+
+	missing := filepath.Join(dir, "missing.json")
+	cfg, err := Load(Flags{ConfigPath: missing, EnvFile: envPath})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.Model != "from-dotenv" || cfg.Provider != "anthropic" {
+		t.Fatalf("got provider=%q model=%q, want the real env to win over the file", cfg.Provider, cfg.Model)
+	}
+
+	if _, err := Load(Flags{ConfigPath: missing, EnvFile: filepath.Join(dir, "nope.env")}); err == nil || !strings.Contains(err.Error(), "env file") {
+		t.Fatalf("expected missing env file error, got %v", err)
+	}
+}
+
+func TestHeaderFromEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	envPath := filepath.Join(dir, "keys.env")
+	if err := os.WriteFile(envPath, []byte("GOGO_TEST_TOKEN=from-dotenv\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOGO_TEST_TOKEN", "")
+	os.Unsetenv("GOGO_TEST_TOKEN")
+
+	cfg, err := Load(Flags{ConfigPath: filepath.Join(dir, "missing.json"), Provider: "openai", EnvFile: envPath, Headers: []string{"Authorization: Bearer $GOGO_TEST_TOKEN"}})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if got := cfg.Headers["Authorization"]; got != "Bearer from-dotenv" {
+		t.Fatalf("header not expanded from the env file, got %q", got)
+	}
+}
+
+func TestDotenvFromConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"provider":"openai","dotenv":true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("GOGO_MODEL=from-dotenv\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOGO_MODEL", "")
+	os.Unsetenv("GOGO_MODEL")
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	cfg, err := Load(Flags{ConfigPath: path})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.Model != "from-dotenv" {
+		t.Fatalf("./.env not loaded: model=%q", cfg.Model)
+	}
+}
+
+func TestConfigTemplateKeys(t *testing.T) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(configTemplate), &keys); err != nil {
+		t.Fatalf("template is not valid JSON: %v", err)
+	}
+	typ := reflect.TypeOf(fileConfig{})
+	for i := 0; i < typ.NumField(); i++ {
+		tag := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if tag == "" || tag == "-" {
+			continue
+		}
+		if _, ok := keys[tag]; !ok {
+			t.Errorf("config template is missing %q", tag)
+		}
+		if _, ok := keys["//"+tag]; !ok {
+			t.Errorf("config template has no note for %q", tag)
+		}
+	}
+}
+
+func TestInit(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "gogo")
+
+	paths, err := Init(dir, false)
+	if err != nil {
+		t.Fatalf("Init returned error: %v", err)
+	}
+	if len(paths) != 2 {
+		t.Fatalf("expected 2 files, got %v", paths)
+	}
+
+	// The template must load cleanly.
+	cfg, err := Load(Flags{ConfigPath: filepath.Join(dir, "config.json")})
+	if err != nil {
+		t.Fatalf("template config does not load: %v", err)
+	}
+	if cfg.Provider != "openai" || cfg.Model != "gpt-4o-mini" {
+		t.Fatalf("unexpected template config: %+v", cfg)
+	}
+
+	if _, err := Init(dir, false); err == nil {
+		t.Fatal("expected Init to refuse to overwrite existing files")
+	}
+
+	os.WriteFile(filepath.Join(dir, "config.json"), []byte("{}"), 0600)
+	if _, err := Init(dir, true); err != nil {
+		t.Fatalf("Init with force returned error: %v", err)
+	}
+	b, _ := os.ReadFile(filepath.Join(dir, "config.json"))
+	if string(b) != configTemplate {
+		t.Fatal("force did not overwrite config.json")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	cfg, err := Load(Flags{Provider: "openai"})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.IdleTimeout != DefaultIdleTimeout {
+		t.Fatalf("default idle timeout not set: %v", cfg.IdleTimeout)
+	}
+
+	cfg, err = Load(Flags{Provider: "openai", IdleTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.IdleTimeout != 5*time.Second {
+		t.Fatalf("idle timeout flag not applied: %v", cfg.IdleTimeout)
+	}
+}
+
+func TestBuiltins(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		file string
+		want []string
+	}{
+		{`{}`, nil},
+		{`{"builtins":[]}`, []string{}},
+		{`{"builtins":["fetch"]}`, []string{"fetch"}},
+	} {
+		path := filepath.Join(dir, "config.json")
+		if err := os.WriteFile(path, []byte(tc.file), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load(Flags{Provider: "openai", ConfigPath: path})
+		if err != nil {
+			t.Fatalf("Load returned error: %v", err)
+		}
+		if (cfg.Builtins == nil) != (tc.want == nil) || strings.Join(cfg.Builtins, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%s: builtins = %#v, want %#v", tc.file, cfg.Builtins, tc.want)
+		}
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	t.Setenv("GOGO_TEST_ORG", "acme")
+	cfg, err := Load(Flags{Provider: "openai", Headers: []string{"X-Org-ID: $GOGO_TEST_ORG", "X-Empty:", "X-Url: https://a.b/c"}})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	want := map[string]string{"X-Org-ID": "acme", "X-Empty": "", "X-Url": "https://a.b/c"}
+	if len(cfg.Headers) != len(want) {
+		t.Fatalf("unexpected headers: %v", cfg.Headers)
+	}
+	for k, v := range want {
+		if cfg.Headers[k] != v {
+			t.Errorf("header %s = %q, want %q", k, cfg.Headers[k], v)
+		}
+	}
+
+	for _, bad := range []string{"no-colon", ": value", "Bad Key: v"} {
+		if _, err := Load(Flags{Provider: "openai", Headers: []string{bad}}); err == nil || !strings.Contains(err.Error(), "invalid header") {
+			t.Errorf("%q: expected invalid header error, got %v", bad, err)
+		}
+	}
+}
+
+func TestAPIKeysWarnWorldReadable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"api_keys":{"openai":"sk-file"}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(Flags{Provider: "openai", ConfigPath: path})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if cfg.APIKeys["openai"] != "sk-file" {
+		t.Fatalf("api_keys not loaded: %v", cfg.APIKeys)
+	}
+	if len(cfg.Warnings) != 0 {
+		t.Fatalf("unexpected warnings for a 0600 file: %v", cfg.Warnings)
+	}
+
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = Load(Flags{Provider: "openai", ConfigPath: path})
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(cfg.Warnings) != 1 || !strings.Contains(cfg.Warnings[0], "world-readable") {
+		t.Fatalf("expected a world-readable warning, got %v", cfg.Warnings)
+	}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// configTemplate lists every supported config.json key. JSON has no comments,
+// so notes live under "//" keys, which Load ignores.
+const configTemplate = `{
+  "//": "gogo config. Priority: flags > environment > this file > defaults.",
+
+  "//provider": "openai | anthropic | gemini | openai-compatible",
+  "provider": "openai",
+
+  "//model": "Leave empty for the provider default (gpt-4o-mini, claude-3-5-haiku-latest, gemini-1.5-flash)",
+  "model": "",
+
+  "//max_tokens": "Maximum output tokens; 0 uses the provider default",
+  "max_tokens": 0,
+
+  "//temperature": "Sampling temperature (0.0 - 2.0); 0 uses the provider default",
+  "temperature": 0,
+
+  "//top_p": "Nucleus sampling probability (0.0 - 1.0); 0 uses the provider default",
+  "top_p": 0,
+
+  "//top_k": "Sample from the top k tokens (anthropic, gemini); 0 uses the provider default",
+  "top_k": 0,
+
+  "//stop": "Default stop sequences; --stop replaces them (not supported by openai)",
+  "stop": [],
+
+  "//timeout_ms": "Overall request timeout in milliseconds; 0 means no timeout",
+  "timeout_ms": 0,
+
+  "//request_timeout_ms": "Timeout for each provider request in milliseconds; 0 means none",
+  "request_timeout_ms": 0,
+
+  "//idle_timeout_ms": "Fail a stream that sends nothing for this long; 0 uses the default (60000)",
+  "idle_timeout_ms": 0,
+
+  "//system_prompt": "Prepended to the tool instructions on every request",
+  "system_prompt": "",
+
+  "//base_url": "API root for openai-compatible, e.g. https://api.groq.com/openai/v1",
+  "base_url": "",
+
+  "//api_key_env": "Environment variable holding the openai-compatible key (default GOGO_API_KEY)",
+  "api_key_env": "",
+
+  "//supports_tools": "Offer tools to the openai-compatible endpoint",
+  "supports_tools": false,
+
+  "//max_tool_rounds": "Rounds of tool calls per run; 0 uses the default (1)",
+  "max_tool_rounds": 0,
+
+  "//final_temperature": "Temperature of the request after the last tool round; null uses temperature",
+  "final_temperature": null,
+
+  "//builtins": "Builtin tools to register; [] disables them all",
+  "builtins": ["fs", "fetch"],
+
+  "//dotenv": "Load ./.env into the environment when present; real env vars win",
+  "dotenv": false,
+
+  "//api_keys": "Keys by provider name, used when the environment variable is unset; keep this file 0600",
+  "api_keys": {},
+
+  "//model_aliases": "Short names resolved to full model ids",
+  "model_aliases": {
+    "sonnet": "claude-3-5-sonnet-latest"
+  },
+
+  "//param_map": "Per-provider renames of request body keys, for gateways",
+  "param_map": {}
+}
+`
+
+// pluginsTemplate is the starting point for plugins.json.
+const pluginsTemplate = `{
+  "//": "Custom tools for gogo. See https://github.com/sirsjg/gogo for the full format.",
+
+  "//exec_allowlist": "Executables exec tools may run; empty means unrestricted",
+  "exec_allowlist": [],
+
+  "//fetch_allow_private": "Let the builtin fetch tool reach loopback/private addresses",
+  "fetch_allow_private": false,
+
+  "//max_tool_output_bytes": "Cut tool results longer than this before sending them to the model; -1 disables the cap",
+  "max_tool_output_bytes": 102400,
+
+  "tools": []
+}
+`
+
+// Dir returns the gogo config directory: $GOGO_CONFIG_DIR when set, else
+// $XDG_CONFIG_HOME/gogo, else ~/.config/gogo. A relative XDG_CONFIG_HOME
+// is ignored, as the XDG spec requires.
+func Dir() (string, error) {
+	if dir := os.Getenv("GOGO_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(xdg) {
+		return filepath.Join(xdg, "gogo"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "gogo"), nil
+}
+
+// Init writes template config.json and plugins.json into dir, creating it if
+// needed. Existing files are left alone unless force is set. It returns the
+// paths that were written.
+func Init(dir string, force bool) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name    string
+		content string
+	}{
+		{"config.json", configTemplate},
+		{"plugins.json", pluginsTemplate},
+	}
+
+	// Check everything first so a refusal doesn't leave a half-written setup.
+	if !force {
+		for _, f := range files {
+			path := filepath.Join(dir, f.name)
+			if _, err := os.Stat(path); err == nil {
+				return nil, fmt.Errorf("%s already exists (use --force to overwrite)", path)
+			} else if !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+
+	written := make([]string, 0, len(files))
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, []byte(f.content), 0600); err != nil {
+			return written, err
+		}
+		written = append(written, path)
+	}
+	return written, nil
+}
@@ -0,0 +1,118 @@
+// Package dotenv reads KEY=VALUE files into the process environment, for
+// API keys kept in a project-local .env file.
+package dotenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var keyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Parse reads KEY=VALUE lines. Blank lines and lines starting with # are
+// skipped, and an "export " prefix is allowed. Values may be wrapped in
+// double quotes, which understand \n, \t, \" and \\ escapes, or in single
+// quotes, which are literal. An unquoted value ends at a # preceded by
+// whitespace. Later lines win over earlier ones for the same key.
+func Parse(r io.Reader) (map[string]string, error) {
+	vars := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", n)
+		}
+		key = strings.TrimSpace(key)
+		if !keyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", n, key)
+		}
+		value, err := parseValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		vars[key] = value
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+func parseValue(v string) (string, error) {
+	if v == "" {
+		return "", nil
+	}
+	switch q := v[0]; q {
+	case '"', '\'':
+		end := closingQuote(v, q)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated %c quote", q)
+		}
+		if rest := strings.TrimSpace(v[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected text after closing quote: %q", rest)
+		}
+		if q == '\'' {
+			return v[1:end], nil
+		}
+		return unescape(v[1:end]), nil
+	}
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.Index(v, "\t#"); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v), nil
+}
+
+// closingQuote returns the index of the quote closing v[0], skipping
+// backslash escapes inside double quotes, or -1.
+func closingQuote(v string, q byte) int {
+	for i := 1; i < len(v); i++ {
+		switch {
+		case q == '"' && v[i] == '\\':
+			i++
+		case v[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+var escapes = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`)
+
+func unescape(s string) string {
+	return escapes.Replace(s)
+}
+
+// Load reads the file at path and sets each variable that is not already
+// set, so real environment variables always win over the file.
+func Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	vars, err := Parse(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for k, v := range vars {
+		if _, set := os.LookupEnv(k); set {
+			continue
+		}
+		if err := os.Setenv(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
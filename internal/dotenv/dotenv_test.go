@@ -0,0 +1,83 @@
+package dotenv
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	in := `# API keys
+OPENAI_API_KEY=sk-plain
+
+export ANTHROPIC_API_KEY = sk-ant-exported
+SPACED=  value with spaces   # trailing comment
+HASH=abc#def
+DOUBLE="line one\nline \"two\" # not a comment"
+SINGLE='raw \n $HOME # kept'
+EMPTY=
+EMPTY_QUOTED=""
+DUP=first
+DUP=second
+`
+	got, err := Parse(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"OPENAI_API_KEY":    "sk-plain",
+		"ANTHROPIC_API_KEY": "sk-ant-exported",
+		"SPACED":            "value with spaces",
+		"HASH":              "abc#def",
+		"DOUBLE":            "line one\nline \"two\" # not a comment",
+		"SINGLE":            `raw \n $HOME # kept`,
+		"EMPTY":             "",
+		"EMPTY_QUOTED":      "",
+		"DUP":               "second",
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d vars, want %d: %q", len(got), len(want), got)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		in, want string
+	}{
+		{"KEY=ok\nNOEQUALS", "line 2: expected KEY=VALUE"},
+		{"1KEY=x", `line 1: invalid key "1KEY"`},
+		{`KEY="open`, "line 1: unterminated \" quote"},
+		{`KEY="a" b`, "line 1: unexpected text after closing quote"},
+	} {
+		_, err := Parse(strings.NewReader(tc.in))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("Parse(%q) = %v, want error containing %q", tc.in, err, tc.want)
+		}
+	}
+}
+
+func TestLoadKeepsRealEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	if err := os.WriteFile(path, []byte("GOGO_DOTENV_SET=from-file\nGOGO_DOTENV_NEW=from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOGO_DOTENV_SET", "real")
+	t.Setenv("GOGO_DOTENV_NEW", "")
+	os.Unsetenv("GOGO_DOTENV_NEW")
+
+	if err := Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("GOGO_DOTENV_SET"); got != "real" {
+		t.Errorf("real env var overridden: %q", got)
+	}
+	if got := os.Getenv("GOGO_DOTENV_NEW"); got != "from-file" {
+		t.Errorf("file var not set: %q", got)
+	}
+}
@@ -0,0 +1,89 @@
+// Package history loads and saves provider-neutral conversation history
+// stored as JSON Lines, one {"role","content"} message per line.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Message is a single provider-neutral conversation turn. JSONL history
+// only holds user and assistant text; transcripts also carry the assistant's
+// tool calls and "tool" turns holding their results.
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+
+	// ToolCalls are the tools an assistant turn asked for.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+
+	// ToolCallID and Name identify the call a tool turn answers.
+	ToolCallID string `json:"tool_call_id,omitempty"`
+	Name       string `json:"name,omitempty"`
+}
+
+// ToolCall is one tool invocation requested by the assistant.
+type ToolCall struct {
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+// Load reads messages from a JSONL file. A missing file yields no messages so
+// a new conversation can be started with --history-append.
+func Load(path string) ([]Message, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var msgs []Message
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var m Message
+		if err := json.Unmarshal([]byte(text), &m); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if m.Role != "user" && m.Role != "assistant" {
+			return nil, fmt.Errorf("%s:%d: unsupported role %q (must be user or assistant)", path, line, m.Role)
+		}
+		msgs = append(msgs, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// Append writes messages to the end of a JSONL file, creating it if needed.
+// A new file is private (0600), since it holds full prompts and responses.
+func Append(path string, msgs ...Message) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, m := range msgs {
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package history
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadAndAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	content := `{"role":"user","content":"hi"}
+
+{"role":"assistant","content":"hello\nthere"}
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if !reflect.DeepEqual(msgs, []Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello\nthere"}}) {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+
+	if err := Append(path, Message{Role: "user", Content: "next"}, Message{Role: "assistant", Content: "reply"}); err != nil {
+		t.Fatalf("Append returned error: %v", err)
+	}
+	msgs, err = Load(path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(msgs) != 4 || !reflect.DeepEqual(msgs[3], Message{Role: "assistant", Content: "reply"}) {
+		t.Fatalf("unexpected messages after append: %+v", msgs)
+	}
+
+	created := filepath.Join(t.TempDir(), "new.jsonl")
+	if err := Append(created, Message{Role: "user", Content: "hi"}); err != nil {
+		t.Fatalf("Append returned error: %v", err)
+	}
+	if info, err := os.Stat(created); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Fatalf("history file should be mode 0600, got %v", info.Mode().Perm())
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	msgs, err := Load(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(msgs) != 0 {
+		t.Fatalf("expected no messages, got %+v", msgs)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	bad := filepath.Join(dir, "bad.jsonl")
+	os.WriteFile(bad, []byte(`{"role":"user","content":"ok"}`+"\n{not json}\n"), 0644)
+	if _, err := Load(bad); err == nil {
+		t.Fatal("expected error for invalid JSON")
+	}
+
+	role := filepath.Join(dir, "role.jsonl")
+	os.WriteFile(role, []byte(`{"role":"tool","content":"x"}`+"\n"), 0644)
+	if _, err := Load(role); err == nil {
+		t.Fatal("expected error for unsupported role")
+	}
+}
+
+func TestTranscriptRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transcript.json")
+	msgs := []Message{
+		{Role: "user", Content: "weather in Oslo?"},
+		{Role: "assistant", Content: "Checking.", ToolCalls: []ToolCall{{ID: "call_1", Name: "weather", Input: json.RawMessage(`{"city":"Oslo"}`)}}},
+		{Role: "tool", ToolCallID: "call_1", Name: "weather", Content: `{"ok":true,"data":"sunny"}`},
+		{Role: "assistant", Content: "It is sunny."},
+	}
+	if err := SaveTranscript(path, msgs); err != nil {
+		t.Fatalf("SaveTranscript returned error: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Fatalf("transcript should be mode 0600, got %v", info.Mode().Perm())
+	}
+	got, err := LoadTranscript(path)
+	if err != nil {
+		t.Fatalf("LoadTranscript returned error: %v", err)
+	}
+	if !reflect.DeepEqual(got, msgs) {
+		t.Fatalf("round trip changed the transcript:\n got %+v\nwant %+v", got, msgs)
+	}
+
+	if msgs, err := LoadTranscript(filepath.Join(t.TempDir(), "missing.json")); err != nil || msgs != nil {
+		t.Fatalf("missing transcript: %+v, %v", msgs, err)
+	}
+}
+
+func TestTranscriptErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"version":  `{"version":2,"messages":[]}`,
+		"role":     `{"version":1,"messages":[{"role":"system","content":"x"}]}`,
+		"orphan":   `{"version":1,"messages":[{"role":"tool","tool_call_id":"call_9","content":"{}"}]}`,
+		"unnamed":  `{"version":1,"messages":[{"role":"assistant","tool_calls":[{"id":"call_1"}]}]}`,
+		"not json": `[{"role":"user"}`,
+	} {
+		path := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadTranscript(path); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
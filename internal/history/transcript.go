@@ -0,0 +1,88 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TranscriptVersion is the format version written by SaveTranscript.
+const TranscriptVersion = 1
+
+// transcript is the JSON document behind --save-transcript and
+// --load-transcript. Messages are provider-neutral: each provider converts
+// them to its own turn shape, tool calls included.
+type transcript struct {
+	Version  int       `json:"version"`
+	Messages []Message `json:"messages"`
+}
+
+// LoadTranscript reads a transcript written by SaveTranscript. A missing
+// file yields no messages so the same path can be loaded and saved to keep
+// a conversation going.
+func LoadTranscript(path string) ([]Message, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var t transcript
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if t.Version != TranscriptVersion {
+		return nil, fmt.Errorf("%s: unsupported transcript version %d", path, t.Version)
+	}
+	if err := validateTranscript(t.Messages); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// Undo SaveTranscript's indentation of tool inputs.
+	for _, m := range t.Messages {
+		for i, c := range m.ToolCalls {
+			var buf bytes.Buffer
+			if json.Compact(&buf, c.Input) == nil {
+				m.ToolCalls[i].Input = buf.Bytes()
+			}
+		}
+	}
+	return t.Messages, nil
+}
+
+// SaveTranscript writes msgs to path as a transcript, replacing the file.
+// A new file is only readable by the user, as it may hold tool output.
+func SaveTranscript(path string, msgs []Message) error {
+	b, err := json.MarshalIndent(transcript{Version: TranscriptVersion, Messages: msgs}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0600)
+}
+
+// validateTranscript checks roles and that every tool turn answers a call
+// made by an earlier assistant turn.
+func validateTranscript(msgs []Message) error {
+	calls := map[string]bool{}
+	for i, m := range msgs {
+		switch m.Role {
+		case "user":
+		case "assistant":
+			for _, c := range m.ToolCalls {
+				if c.ID == "" || c.Name == "" {
+					return fmt.Errorf("message %d: tool call needs an id and a name", i)
+				}
+				calls[c.ID] = true
+			}
+		case "tool":
+			if !calls[m.ToolCallID] {
+				return fmt.Errorf("message %d: tool result for unknown call %q", i, m.ToolCallID)
+			}
+		default:
+			return fmt.Errorf("message %d: unsupported role %q (must be user, assistant, or tool)", i, m.Role)
+		}
+	}
+	return nil
+}
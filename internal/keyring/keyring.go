@@ -0,0 +1,204 @@
+// Package keyring stores API keys in the operating system's credential
+// store by driving its command-line tool: security on macOS, secret-tool
+// (libsecret) on Linux, and PowerShell for Credential Manager on Windows.
+package keyring
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Service is the service name keys are stored under; the account is the
+// provider name.
+const Service = "gogo"
+
+// ErrNotFound is returned by Get when the keyring holds no key for the
+// provider.
+var ErrNotFound = errors.New("no key in keyring")
+
+// Lookups happen on every run without a key in the environment, so a
+// locked or prompting keyring must not stall them for long; storing a key
+// leaves time to answer an unlock prompt.
+const (
+	getTimeout = 5 * time.Second
+	setTimeout = time.Minute
+)
+
+// goos and run are replaced in tests.
+var (
+	goos = runtime.GOOS
+	run  = func(timeout time.Duration, stdin, name string, args ...string) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stdin = strings.NewReader(stdin)
+		cmd.WaitDelay = time.Second
+		out, err := cmd.Output()
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("%s did not finish within %s", name, timeout)
+		}
+		return string(out), err
+	}
+)
+
+// Get returns the key stored for provider.
+func Get(provider string) (string, error) {
+	var out string
+	var err error
+	switch goos {
+	case "darwin":
+		out, err = run(getTimeout, "", "security", "find-generic-password", "-s", Service, "-a", provider, "-w")
+	case "linux", "freebsd", "openbsd", "netbsd":
+		out, err = run(getTimeout, "", "secret-tool", "lookup", "service", Service, "account", provider)
+	case "windows":
+		out, err = powershell(getTimeout, "", fmt.Sprintf(
+			"$k = [GogoCred]::Read(%s); if ($k -eq $null) { exit 1 }; [Console]::Out.Write($k)",
+			psQuote(target(provider))))
+	default:
+		return "", unsupported()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimSpace(out)
+	if key == "" {
+		return "", ErrNotFound
+	}
+	return key, nil
+}
+
+// Set stores key for provider, replacing any earlier key. The key is
+// passed on the tool's stdin so it never shows up in the process list.
+func Set(provider, key string) error {
+	if key == "" {
+		return errors.New("empty key")
+	}
+	var err error
+	switch goos {
+	case "darwin":
+		// security only reads a password from stdin in interactive mode,
+		// where the command line is split on whitespace and quotes.
+		if strings.ContainsAny(key, " \t\r\n\"'\\") {
+			return errors.New("key contains whitespace, quotes or backslashes")
+		}
+		_, err = run(setTimeout, fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n", Service, provider, key), "security", "-i")
+		// Interactive mode exits 0 even when the command fails.
+		if err == nil {
+			if got, gerr := Get(provider); gerr != nil || got != key {
+				return errors.New("security did not store the key")
+			}
+		}
+	case "linux", "freebsd", "openbsd", "netbsd":
+		_, err = run(setTimeout, key, "secret-tool", "store", "--label", Service+" "+provider+" API key", "service", Service, "account", provider)
+		if errors.Is(err, exec.ErrNotFound) {
+			return fmt.Errorf("%w (install libsecret)", err)
+		}
+	case "windows":
+		_, err = powershell(setTimeout, key, fmt.Sprintf(
+			"try { [GogoCred]::Write(%s, %s, [Console]::In.ReadToEnd()) } catch { [Console]::Error.WriteLine($_.Exception.Message); exit 1 }",
+			psQuote(target(provider)), psQuote(provider)))
+	default:
+		return unsupported()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+// credManager is a C# wrapper around CredRead and CredWrite that the
+// Windows scripts load first. Windows ships no command-line tool that reads
+// a stored password back, and cmdkey would take the key as an argument.
+const credManager = `Add-Type -TypeDefinition @'
+using System;
+using System.Runtime.InteropServices;
+using System.Runtime.InteropServices.ComTypes;
+public static class GogoCred {
+	[StructLayout(LayoutKind.Sequential, CharSet = CharSet.Unicode)]
+	struct Credential {
+		public int Flags;
+		public int Type;
+		public string TargetName;
+		public string Comment;
+		public FILETIME LastWritten;
+		public int CredentialBlobSize;
+		public IntPtr CredentialBlob;
+		public int Persist;
+		public int AttributeCount;
+		public IntPtr Attributes;
+		public string TargetAlias;
+		public string UserName;
+	}
+	[DllImport("advapi32.dll", CharSet = CharSet.Unicode, SetLastError = true)]
+	static extern bool CredRead(string target, int type, int flags, out IntPtr cred);
+	[DllImport("advapi32.dll", CharSet = CharSet.Unicode, SetLastError = true)]
+	static extern bool CredWrite(ref Credential cred, int flags);
+	[DllImport("advapi32.dll")]
+	static extern void CredFree(IntPtr cred);
+	public static string Read(string target) {
+		IntPtr p;
+		if (!CredRead(target, 1, 0, out p)) return null;
+		try {
+			Credential c = (Credential)Marshal.PtrToStructure(p, typeof(Credential));
+			return Marshal.PtrToStringUni(c.CredentialBlob, c.CredentialBlobSize / 2);
+		} finally {
+			CredFree(p);
+		}
+	}
+	public static void Write(string target, string user, string secret) {
+		byte[] blob = System.Text.Encoding.Unicode.GetBytes(secret);
+		Credential c = new Credential();
+		c.Type = 1;
+		c.TargetName = target;
+		c.UserName = user;
+		c.Persist = 2;
+		c.CredentialBlobSize = blob.Length;
+		c.CredentialBlob = Marshal.AllocHGlobal(blob.Length);
+		try {
+			Marshal.Copy(blob, 0, c.CredentialBlob, blob.Length);
+			if (!CredWrite(ref c, 0)) throw new System.ComponentModel.Win32Exception(Marshal.GetLastWin32Error());
+		} finally {
+			Marshal.FreeHGlobal(c.CredentialBlob);
+		}
+	}
+}
+'@
+`
+
+// target is the Credential Manager name a provider's key is stored under.
+func target(provider string) string {
+	return Service + ":" + provider
+}
+
+// powershell runs script after loading credManager. The script is passed
+// with -EncodedCommand so Windows command-line quoting cannot mangle it;
+// the key itself only ever travels on stdin.
+func powershell(timeout time.Duration, stdin, script string) (string, error) {
+	u := utf16.Encode([]rune(credManager + script))
+	b := make([]byte, 0, len(u)*2)
+	for _, c := range u {
+		b = append(b, byte(c), byte(c>>8))
+	}
+	return run(timeout, stdin, "powershell", "-NoProfile", "-NonInteractive", "-EncodedCommand", base64.StdEncoding.EncodeToString(b))
+}
+
+// psQuote quotes s as a PowerShell single-quoted string.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func unsupported() error {
+	return fmt.Errorf("no keyring support on %s", goos)
+}
@@ -0,0 +1,150 @@
+package keyring
+
+import (
+	"encoding/base64"
+	"errors"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// fakeStore stands in for the keyring tools, recording each command and
+// serving lookups from a map.
+type fakeStore struct {
+	keys  map[string]string
+	calls []string
+}
+
+// psTarget finds the credential name in a decoded PowerShell script.
+var psTarget = regexp.MustCompile(`::(Read|Write)\('gogo:([^']*)'`)
+
+func (f *fakeStore) run(_ time.Duration, stdin, name string, args ...string) (string, error) {
+	f.calls = append(f.calls, name+" "+strings.Join(args, " "))
+	account := args[len(args)-1]
+	if name == "powershell" {
+		return f.powershell(stdin, args)
+	}
+	switch {
+	case name == "secret-tool" && args[0] == "store":
+		f.keys[account] = stdin
+	case name == "secret-tool" && args[0] == "lookup",
+		name == "security" && args[0] == "find-generic-password":
+		if name == "security" {
+			account = args[len(args)-2]
+		}
+		if k, ok := f.keys[account]; ok {
+			return k + "\n", nil
+		}
+		return "", &exec.ExitError{}
+	case name == "security" && args[0] == "-i":
+		fields := strings.Fields(stdin)
+		f.keys[fields[5]] = fields[7]
+	}
+	return "", nil
+}
+
+func (f *fakeStore) powershell(stdin string, args []string) (string, error) {
+	if args[len(args)-2] != "-EncodedCommand" {
+		return "", errors.New("script not encoded")
+	}
+	b, err := base64.StdEncoding.DecodeString(args[len(args)-1])
+	if err != nil || len(b)%2 != 0 {
+		return "", errors.New("bad encoding")
+	}
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = uint16(b[2*i]) | uint16(b[2*i+1])<<8
+	}
+	script := string(utf16.Decode(u))
+	if !strings.Contains(script, "CredRead") {
+		return "", errors.New("credential wrapper not loaded")
+	}
+	m := psTarget.FindStringSubmatch(script)
+	if m == nil {
+		return "", errors.New("no target in script")
+	}
+	if m[1] == "Write" {
+		f.keys[m[2]] = stdin
+		return "", nil
+	}
+	if k, ok := f.keys[m[2]]; ok {
+		return k, nil
+	}
+	return "", &exec.ExitError{}
+}
+
+func stub(t *testing.T, system string) *fakeStore {
+	f := &fakeStore{keys: map[string]string{}}
+	oldGOOS, oldRun := goos, run
+	goos, run = system, f.run
+	t.Cleanup(func() { goos, run = oldGOOS, oldRun })
+	return f
+}
+
+func TestSetGet(t *testing.T) {
+	for _, system := range []string{"linux", "darwin", "windows"} {
+		t.Run(system, func(t *testing.T) {
+			f := stub(t, system)
+			if _, err := Get("openai"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+			if err := Set("openai", "sk-secret"); err != nil {
+				t.Fatal(err)
+			}
+			if key, err := Get("openai"); err != nil || key != "sk-secret" {
+				t.Fatalf("Get = %q, %v", key, err)
+			}
+			for _, c := range f.calls {
+				if strings.Contains(c, "sk-secret") {
+					t.Errorf("key passed as an argument: %s", c)
+				}
+			}
+		})
+	}
+}
+
+func TestSetRejects(t *testing.T) {
+	stub(t, "darwin")
+	for _, key := range []string{"", "sk with space", `sk"quote`} {
+		if err := Set("openai", key); err == nil {
+			t.Errorf("Set(%q) should fail", key)
+		}
+	}
+	stub(t, "plan9")
+	if err := Set("openai", "sk-secret"); err == nil || !strings.Contains(err.Error(), "plan9") {
+		t.Errorf("expected unsupported error, got %v", err)
+	}
+	if _, err := Get("openai"); err == nil {
+		t.Error("expected unsupported error from Get")
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("no sleep command")
+	}
+	start := time.Now()
+	_, err := run(50*time.Millisecond, "", "sleep", "5")
+	if err == nil || !strings.Contains(err.Error(), "did not finish") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Fatalf("run waited %s", d)
+	}
+}
+
+func TestSetMissingTool(t *testing.T) {
+	for system, hint := range map[string]bool{"linux": true, "darwin": false} {
+		stub(t, system)
+		run = func(time.Duration, string, string, ...string) (string, error) {
+			return "", &exec.Error{Name: "tool", Err: exec.ErrNotFound}
+		}
+		err := Set("openai", "sk-secret")
+		if !errors.Is(err, exec.ErrNotFound) || strings.Contains(err.Error(), "libsecret") != hint {
+			t.Errorf("%s: got %v", system, err)
+		}
+	}
+}
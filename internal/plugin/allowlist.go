@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ExecAllowEnv is the environment variable holding a comma-separated list of
+// executables that exec tools may run.
+const ExecAllowEnv = "GOGO_EXEC_ALLOW"
+
+var (
+	allowMu       sync.RWMutex
+	execAllowlist []string
+
+	// warnOut receives the one-time warning about unrestricted exec tools.
+	warnOut  io.Writer = os.Stderr
+	warnOnce sync.Once
+)
+
+// SetExecAllowlist sets the executables exec tools are permitted to run.
+// Entries may be bare names resolved via PATH or absolute paths. An empty
+// list leaves exec tools unrestricted unless GOGO_EXEC_ALLOW is set.
+func SetExecAllowlist(list []string) {
+	allowMu.Lock()
+	defer allowMu.Unlock()
+	execAllowlist = append([]string(nil), list...)
+}
+
+// ExecAllowlist returns the effective allowlist from config and environment.
+func ExecAllowlist() []string {
+	allowMu.RLock()
+	list := append([]string(nil), execAllowlist...)
+	allowMu.RUnlock()
+
+	for _, v := range strings.Split(os.Getenv(ExecAllowEnv), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// checkExecAllowed returns an error if command is not on the allowlist.
+// With no allowlist configured every command is allowed, and a warning is
+// written once per process.
+func checkExecAllowed(command string) error {
+	list := ExecAllowlist()
+	if len(list) == 0 {
+		warnOnce.Do(func() {
+			fmt.Fprintln(warnOut, "warning: exec tools are unrestricted; set exec_allowlist in plugins.json or "+ExecAllowEnv+" to limit them")
+		})
+		return nil
+	}
+
+	resolved := resolveExecutable(command)
+	for _, entry := range list {
+		if entry == command || resolveExecutable(entry) == resolved {
+			return nil
+		}
+	}
+	return fmt.Errorf("command %q is not in the exec allowlist", command)
+}
+
+// resolveExecutable returns the absolute, symlink-free path of an executable,
+// or the input unchanged if it cannot be resolved.
+func resolveExecutable(name string) string {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return name
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	return path
+}
@@ -0,0 +1,21 @@
+package plugin
+
+import "regexp"
+
+// ansiPattern matches CSI sequences (colors, cursor movement) and OSC
+// sequences (titles, hyperlinks).
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)`)
+
+// stripANSI controls whether exec tool output has escape sequences removed.
+var stripANSI bool
+
+// SetStripANSI enables removal of ANSI escape sequences from exec tool
+// stdout and stderr before they are returned to the model.
+func SetStripANSI(enabled bool) {
+	stripANSI = enabled
+}
+
+// StripANSI removes ANSI escape sequences from s.
+func StripANSI(s string) string {
+	return ansiPattern.ReplaceAllString(s, "")
+}
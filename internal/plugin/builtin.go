@@ -1,7 +1,13 @@
 package plugin
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
 
 	"gogo/internal/tool"
 )
@@ -9,19 +15,101 @@ import (
 // FSToolName is the name of the built-in filesystem tool.
 const FSToolName = "fs"
 
+// FetchToolName is the name of the built-in HTTP fetch tool.
+const FetchToolName = "fetch"
+
+// fetchAllowPrivate lets the fetch tool reach loopback and private
+// addresses. It is atomic because --compare runs tools concurrently.
+var fetchAllowPrivate atomic.Bool
+
+// SetFetchAllowPrivate controls whether the fetch tool may reach loopback,
+// private, and link-local addresses. It is disabled by default.
+func SetFetchAllowPrivate(allow bool) {
+	fetchAllowPrivate.Store(allow)
+}
+
+var (
+	// fsConfirm asks on the terminal before the fs tool deletes or replaces
+	// anything; fsYes allows it without asking.
+	fsConfirm bool
+	fsYes     bool
+
+	// fsShowDiff writes a diff to fsDiffOut before write replaces a file.
+	fsShowDiff bool
+	fsDiffOut  io.Writer = os.Stderr
+
+	// fsBackupDir receives copies of what the fs tool overwrites or
+	// removes.
+	fsBackupDir string
+)
+
+// SetFSPolicy controls the fs tool's destructive ops: write over an
+// existing file, delete, rmdir, and move or copy onto an existing path.
+// With confirm, each needs a y/n answer on the terminal, and without a
+// terminal it is refused unless yes is also set.
+func SetFSPolicy(confirm, yes bool) {
+	fsConfirm = confirm
+	fsYes = yes
+}
+
+// SetShowDiff makes the fs tool print a unified diff to stderr before a
+// write replaces an existing file.
+func SetShowDiff(enabled bool) {
+	fsShowDiff = enabled
+}
+
+// SetBackupDir makes the fs tool copy each file it is about to overwrite
+// or remove into dir first; "" turns backups off.
+func SetBackupDir(dir string) {
+	fsBackupDir = dir
+}
+
+// fsOptions returns the fs tool options for the current policy.
+func fsOptions() tool.FSOptions {
+	opts := tool.FSOptions{BackupDir: fsBackupDir}
+	if fsConfirm {
+		opts.Confirm = approveFS
+	}
+	if fsShowDiff {
+		opts.Diff = fsDiffOut
+	}
+	return opts
+}
+
+// approveFS applies the fs policy to a destructive action.
+func approveFS(action string) error {
+	if fsYes {
+		return nil
+	}
+	if !shellInteractive() {
+		return fmt.Errorf("%s refused: no terminal to confirm on (use --yes to allow without confirmation)", action)
+	}
+	if !askTerminal("allow fs " + action + "?") {
+		return fmt.Errorf("%s declined by user", action)
+	}
+	return nil
+}
+
 // BuiltinFS creates a plugin wrapper for the built-in filesystem tool.
 func BuiltinFS() *Tool {
 	return &Tool{
 		Name:        FSToolName,
-		Description: "Filesystem operations (read/write/append/delete/mkdir/rmdir/list/stat/move/copy)",
+		Description: "Filesystem operations (" + strings.Join(tool.Ops, "/") + ")",
 		Type:        "builtin",
 		InputSchema: map[string]interface{}{
 			"type": "object",
 			"properties": map[string]interface{}{
-				"op":   map[string]string{"type": "string", "description": "Operation: read, write, append, delete, mkdir, rmdir, list, stat, move, copy"},
-				"path": map[string]string{"type": "string", "description": "File or directory path"},
-				"data": map[string]string{"type": "string", "description": "Data to write (for write/append)"},
-				"dest": map[string]string{"type": "string", "description": "Destination path (for move/copy)"},
+				"op":         map[string]interface{}{"type": "string", "enum": tool.Ops, "description": "Operation to perform"},
+				"path":       map[string]string{"type": "string", "description": "File or directory path"},
+				"data":       map[string]string{"type": "string", "description": "Data to write (for write/append), or an octal mode like 0755 (for chmod)"},
+				"dest":       map[string]string{"type": "string", "description": "Destination path (for move/copy)"},
+				"offset":     map[string]string{"type": "integer", "description": "Byte offset to start reading from (for read; optional)"},
+				"length":     map[string]string{"type": "integer", "description": "Maximum bytes to read (for read; optional, 0 reads to the end)"},
+				"start_line": map[string]string{"type": "integer", "description": "First line to read, 1-indexed (for read; returns numbered lines)"},
+				"end_line":   map[string]string{"type": "integer", "description": "Last line to read, inclusive (for read; optional, 0 reads to the end)"},
+				"lines":      map[string]string{"type": "integer", "description": "Number of lines to return (for head/tail; optional, default 10)"},
+				"atomic":     map[string]string{"type": "boolean", "description": "Write via temp file and rename so readers never see a partial file (for write)"},
+				"pattern":    map[string]string{"type": "string", "description": "Glob such as *.go or **/*.txt; returns matching entries relative to path (for list; optional)"},
 			},
 			"required": []string{"op", "path"},
 		},
@@ -34,7 +122,7 @@ func ExecuteFS(input []byte) Result {
 	if err := json.Unmarshal(input, &req); err != nil {
 		return Result{OK: false, Error: err.Error()}
 	}
-	fsResult := tool.FS(req)
+	fsResult := tool.FSWithOptions(req, fsOptions())
 	return Result{
 		OK:    fsResult.OK,
 		Data:  fsResult.Data,
@@ -42,26 +130,89 @@ func ExecuteFS(input []byte) Result {
 	}
 }
 
-// LoadWithBuiltins loads user plugins and adds built-in tools.
-func LoadWithBuiltins() (*Registry, error) {
-	reg, err := LoadDefault()
+// BuiltinFetch creates a plugin wrapper for the built-in HTTP fetch tool.
+func BuiltinFetch() *Tool {
+	return &Tool{
+		Name:        FetchToolName,
+		Description: "Fetch a URL over HTTP(S) and return its status, headers, and body",
+		Type:        "builtin",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"url":     map[string]string{"type": "string", "description": "URL to fetch (http or https)"},
+				"method":  map[string]string{"type": "string", "description": "HTTP method (default GET)"},
+				"headers": map[string]interface{}{"type": "object", "description": "Request headers", "additionalProperties": map[string]string{"type": "string"}},
+				"body":    map[string]string{"type": "string", "description": "Request body"},
+			},
+			"required": []string{"url"},
+		},
+	}
+}
+
+// ExecuteFetch runs the built-in fetch tool.
+func ExecuteFetch(ctx context.Context, input []byte) Result {
+	var req tool.FetchRequest
+	if err := json.Unmarshal(input, &req); err != nil {
+		return Result{OK: false, Error: err.Error()}
+	}
+	resp, err := tool.Fetch(ctx, req, tool.FetchOptions{AllowPrivate: fetchAllowPrivate.Load()})
+	if err != nil {
+		return Result{OK: false, Error: err.Error()}
+	}
+	return Result{OK: true, Data: resp}
+}
+
+// DefaultBuiltins are the built-in tools registered when the config does
+// not name any.
+var DefaultBuiltins = []string{FSToolName, FetchToolName}
+
+// LoadWithBuiltins loads user plugins and adds the named built-in tools;
+// nil means DefaultBuiltins and an empty list adds none. The shell tool is
+// not named here: it is added whenever SetShellPolicy enabled it. Warnings
+// are the tool definitions LoadFromFile skipped.
+func LoadWithBuiltins(builtins []string) (*Registry, []error, error) {
+	if builtins == nil {
+		builtins = DefaultBuiltins
+	}
+	for _, name := range builtins {
+		if name != FSToolName && name != FetchToolName {
+			return nil, nil, fmt.Errorf("unknown builtin %q (available: %s, %s)", name, FSToolName, FetchToolName)
+		}
+	}
+
+	reg, warnings, err := LoadDefault()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Add built-in fs tool (can be overridden by user plugins)
-	fs := BuiltinFS()
-	fs.Type = "builtin" // Mark as builtin for special handling
-	reg.tools[FSToolName] = fs
+	for _, name := range builtins {
+		switch name {
+		case FSToolName:
+			fs := BuiltinFS()
+			fs.Type = "builtin" // Mark as builtin for special handling
+			reg.setTool(fs)
+		case FetchToolName:
+			reg.setTool(BuiltinFetch())
+		}
+	}
+
+	// The shell tool is only offered when a policy allows it to run
+	if shellEnabled() {
+		reg.setTool(BuiltinShell())
+	}
 
-	return reg, nil
+	return reg, warnings, nil
 }
 
 // ExecuteBuiltin handles execution of built-in tools.
-func ExecuteBuiltin(name string, input []byte) (Result, bool) {
+func ExecuteBuiltin(ctx context.Context, name string, input []byte) (Result, bool) {
 	switch name {
 	case FSToolName:
 		return ExecuteFS(input), true
+	case FetchToolName:
+		return ExecuteFetch(ctx, input), true
+	case ShellToolName:
+		return ExecuteShell(ctx, input), true
 	default:
 		return Result{}, false
 	}
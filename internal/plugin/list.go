@@ -0,0 +1,40 @@
+package plugin
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// WriteList prints the tools in r as name, type and description, the
+// builtins first and then the user-defined tools, each group sorted by
+// name.
+func WriteList(w io.Writer, r *Registry) error {
+	var builtins, user []*Tool
+	for _, t := range r.All() {
+		if t.Type == "builtin" {
+			builtins = append(builtins, t)
+		} else {
+			user = append(user, t)
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, group := range []struct {
+		title string
+		tools []*Tool
+	}{
+		{"Builtin tools:", builtins},
+		{"User tools (" + DefaultPath() + "):", user},
+	} {
+		fmt.Fprintln(tw, group.title)
+		if len(group.tools) == 0 {
+			fmt.Fprintln(tw, "  (none)")
+		}
+		sort.Slice(group.tools, func(i, j int) bool { return group.tools[i].Name < group.tools[j].Name })
+		for _, t := range group.tools {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", t.Name, t.Type, t.Description)
+		}
+	}
+	return tw.Flush()
+}
@@ -1,57 +1,128 @@
 package plugin
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+
+	"gogo/internal/config"
 )
 
 // PluginsConfig is the structure of the plugins.json config file.
 type PluginsConfig struct {
 	Tools []Tool `json:"tools"`
+
+	// ExecAllowlist limits which executables exec tools may run.
+	ExecAllowlist []string `json:"exec_allowlist,omitempty"`
+
+	// FetchAllowPrivate lets the builtin fetch tool reach private addresses.
+	FetchAllowPrivate bool `json:"fetch_allow_private,omitempty"`
+
+	// MaxToolOutputBytes caps each tool result sent back to the model.
+	// Zero uses DefaultMaxOutputBytes; negative disables the cap.
+	MaxToolOutputBytes int `json:"max_tool_output_bytes,omitempty"`
 }
 
-// LoadFromFile loads plugins from a JSON config file.
-func LoadFromFile(path string) (*Registry, error) {
+// LoadFromFile loads plugins from a JSON config file. Tool definitions that
+// fail validation are skipped; each skip is reported in warnings so the
+// caller can tell the user why a tool is missing.
+func LoadFromFile(path string) (reg *Registry, warnings []error, err error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return NewRegistry(), nil
+			return NewRegistry(), nil, nil
 		}
-		return nil, err
+		return nil, nil, err
 	}
 
 	var cfg PluginsConfig
 	if err := json.Unmarshal(b, &cfg); err != nil {
-		return nil, err
+		if line, col, ok := jsonErrorPosition(b, err); ok {
+			return nil, nil, fmt.Errorf("%s:%d:%d: %w", path, line, col, err)
+		}
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
 	}
 
-	reg := NewRegistry()
+	if len(cfg.ExecAllowlist) > 0 {
+		SetExecAllowlist(cfg.ExecAllowlist)
+	}
+
+	if cfg.FetchAllowPrivate {
+		SetFetchAllowPrivate(true)
+	}
+
+	// Two definitions with the same name would silently replace each other
+	// in the registry, so which one the model gets would depend on order.
+	seen := make(map[string]int, len(cfg.Tools))
+	for i, t := range cfg.Tools {
+		if t.Name == "" {
+			continue
+		}
+		if j, ok := seen[t.Name]; ok {
+			return nil, nil, fmt.Errorf("duplicate tool name %q (tools[%d] and tools[%d])", t.Name, j, i)
+		}
+		seen[t.Name] = i
+	}
+
+	reg = NewRegistry()
+	reg.SetMaxOutputBytes(cfg.MaxToolOutputBytes)
 	for i := range cfg.Tools {
 		if err := reg.Register(&cfg.Tools[i]); err != nil {
 			// Skip invalid tools but continue loading others
-			continue
+			warnings = append(warnings, fmt.Errorf("skipped tools[%d] %q: %w", i, cfg.Tools[i].Name, err))
 		}
 	}
 
-	return reg, nil
+	return reg, warnings, nil
 }
 
-// LoadDefault loads plugins from the default config location (~/.config/gogo/plugins.json).
-func LoadDefault() (*Registry, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return NewRegistry(), nil
+// jsonErrorPosition maps the byte offset of a JSON syntax or type error in
+// data to a 1-based line and column.
+func jsonErrorPosition(data []byte, err error) (line, col int, ok bool) {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return 0, 0, false
+	}
+	// Offset counts the bytes read through the offending byte, which for a
+	// type error is the end of the value.
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset > 0 {
+		offset--
+	}
+	before := data[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col, true
+}
+
+// LoadDefault loads plugins from plugins.json in the config directory
+// (see config.Dir).
+func LoadDefault() (*Registry, []error, error) {
+	path := DefaultPath()
+	if path == "" {
+		return NewRegistry(), nil, nil
 	}
-	path := filepath.Join(home, ".config", "gogo", "plugins.json")
 	return LoadFromFile(path)
 }
 
-// DefaultPath returns the default plugins config path.
+// DefaultPath returns the default plugins config path, or "" when the
+// config directory cannot be determined.
 func DefaultPath() string {
-	home, err := os.UserHomeDir()
+	dir, err := config.Dir()
 	if err != nil {
 		return ""
 	}
-	return filepath.Join(home, ".config", "gogo", "plugins.json")
+	return filepath.Join(dir, "plugins.json")
 }
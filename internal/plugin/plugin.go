@@ -5,14 +5,20 @@ package plugin
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -36,6 +42,14 @@ type Tool struct {
 	// Headers are HTTP headers to include (supports env var substitution with $VAR)
 	Headers map[string]string `json:"headers,omitempty"`
 
+	// Query are query parameters appended to the URL for HTTP tools
+	// (values support {{.field}} placeholders and are URL-encoded)
+	Query map[string]string `json:"query,omitempty"`
+
+	// ResultPath is a dotted path (e.g. "data.items.0.name") selecting the
+	// part of an HTTP tool's JSON response returned as the result
+	ResultPath string `json:"result_path,omitempty"`
+
 	// Body is the request body template for HTTP tools (supports {{.field}} placeholders)
 	Body string `json:"body,omitempty"`
 
@@ -57,13 +71,55 @@ type Result struct {
 	OK    bool        `json:"ok"`
 	Data  interface{} `json:"data,omitempty"`
 	Error string      `json:"error,omitempty"`
+
+	// Warning notes a non-fatal problem, such as a result_path that did
+	// not resolve.
+	Warning string `json:"warning,omitempty"`
+
+	// DurationMS is how long the tool ran, so the model can account for
+	// slow operations. It is set by the Registry's Execute methods.
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// ExecOutput is the Result.Data payload of an exec tool.
+type ExecOutput struct {
+	Stdout   interface{} `json:"stdout"`
+	Stderr   string      `json:"stderr"`
+	ExitCode int         `json:"exit_code"`
 }
 
-// Registry holds all registered tools.
+// Registry holds all registered tools. It is safe for concurrent use; mu
+// guards every field below it.
 type Registry struct {
+	mu    sync.RWMutex
 	tools map[string]*Tool
+	cache formatCache
+
+	// maxOutputBytes caps result data; see SetMaxOutputBytes.
+	maxOutputBytes int
+}
+
+// formatCache holds provider tool payloads and the tool instruction so they
+// are built once per registry state rather than on every request.
+type formatCache struct {
+	anthropic   []map[string]interface{}
+	openai      []map[string]interface{}
+	gemini      []map[string]interface{}
+	instruction *string
 }
 
+// toolNamePattern is the name format OpenAI and Anthropic both accept.
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// httpMethods are the methods http tools may use.
+var httpMethods = map[string]bool{
+	"GET": true, "HEAD": true, "OPTIONS": true,
+	"POST": true, "PUT": true, "PATCH": true, "DELETE": true,
+}
+
+// bodylessMethods send tool params in the query string instead of a body.
+var bodylessMethods = map[string]bool{"GET": true, "HEAD": true, "OPTIONS": true}
+
 // NewRegistry creates an empty tool registry.
 func NewRegistry() *Registry {
 	return &Registry{
@@ -76,54 +132,112 @@ func (r *Registry) Register(t *Tool) error {
 	if t.Name == "" {
 		return errors.New("tool name is required")
 	}
+	if !toolNamePattern.MatchString(t.Name) {
+		return fmt.Errorf("invalid tool name %q: must be 1-64 letters, digits, '_' or '-'", t.Name)
+	}
 	if t.Type != "http" && t.Type != "exec" && t.Type != "builtin" {
 		return fmt.Errorf("invalid tool type %q: must be 'http', 'exec', or 'builtin'", t.Type)
 	}
 	if t.Type == "http" && t.URL == "" {
 		return errors.New("url is required for http tools")
 	}
+	if t.Type == "http" && t.Method != "" && !httpMethods[strings.ToUpper(t.Method)] {
+		return fmt.Errorf("invalid method %q: must be one of GET, HEAD, OPTIONS, POST, PUT, PATCH, DELETE", t.Method)
+	}
 	if t.Type == "exec" && t.Command == "" {
 		return errors.New("command is required for exec tools")
 	}
-	r.tools[t.Name] = t
+	r.setTool(t)
 	return nil
 }
 
+// setTool stores t and invalidates the format cache.
+func (r *Registry) setTool(t *Tool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tools[t.Name] = t
+	r.cache = formatCache{}
+}
+
 // Get retrieves a tool by name.
 func (r *Registry) Get(name string) (*Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	t, ok := r.tools[name]
 	return t, ok
 }
 
-// All returns all registered tools.
+// All returns all registered tools, sorted by name.
 func (r *Registry) All() []*Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.sorted()
+}
+
+// Names returns the names of all registered tools, sorted.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.tools))
+	for _, t := range r.sorted() {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// sorted returns the registered tools ordered by name, so everything built
+// from them (tool payloads, the instruction, cache keys) is the same from
+// one run to the next. The caller must hold r.mu.
+func (r *Registry) sorted() []*Tool {
 	tools := make([]*Tool, 0, len(r.tools))
 	for _, t := range r.tools {
 		tools = append(tools, t)
 	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
 	return tools
 }
 
-// Names returns the names of all registered tools.
-func (r *Registry) Names() []string {
-	names := make([]string, 0, len(r.tools))
-	for name := range r.tools {
-		names = append(names, name)
+// Filter returns a registry holding only the named tools. Names that are
+// not registered are ignored. An empty list returns r unchanged.
+func (r *Registry) Filter(names []string) *Registry {
+	if len(names) == 0 {
+		return r
 	}
-	return names
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	filtered := NewRegistry()
+	filtered.maxOutputBytes = r.maxOutputBytes
+	for _, name := range names {
+		if t, ok := r.tools[name]; ok {
+			filtered.tools[name] = t
+		}
+	}
+	return filtered
 }
 
 // Execute runs a tool with the given input and returns the result.
-func (r *Registry) Execute(name string, input []byte) Result {
-	t, ok := r.tools[name]
+func (r *Registry) Execute(ctx context.Context, name string, input []byte) Result {
+	// The lock only covers the lookup; tools run without holding it.
+	t, ok := r.Get(name)
 	if !ok {
 		return Result{OK: false, Error: fmt.Sprintf("unknown tool: %s", name)}
 	}
-	return t.Execute(input)
+	return timed(func() Result {
+		return truncateResult(t.Execute(ctx, input), r.outputLimit())
+	})
+}
+
+// timed runs fn and records its duration in the result.
+func timed(fn func() Result) Result {
+	start := time.Now()
+	res := fn()
+	res.DurationMS = time.Since(start).Milliseconds()
+	return res
 }
 
-// Execute runs the tool with the given JSON input.
-func (t *Tool) Execute(input []byte) Result {
+// Execute runs the tool with the given JSON input. The tool's own timeout
+// applies on top of ctx.
+func (t *Tool) Execute(ctx context.Context, input []byte) Result {
 	// Parse input into a map for template substitution
 	var params map[string]interface{}
 	if len(input) > 0 {
@@ -134,6 +248,10 @@ func (t *Tool) Execute(input []byte) Result {
 	if params == nil {
 		params = make(map[string]interface{})
 	}
+	coerceInput(t.InputSchema, params)
+	if err := validateInput(t.InputSchema, params); err != nil {
+		return Result{OK: false, Error: fmt.Sprintf("invalid input: %v", err)}
+	}
 
 	timeout := time.Duration(t.TimeoutMS) * time.Millisecond
 	if timeout == 0 {
@@ -142,9 +260,9 @@ func (t *Tool) Execute(input []byte) Result {
 
 	switch t.Type {
 	case "http":
-		return t.executeHTTP(params, timeout)
+		return t.executeHTTP(ctx, params, timeout)
 	case "exec":
-		return t.executeExec(params, timeout)
+		return t.executeExec(ctx, params, timeout)
 	case "builtin":
 		// Builtin tools are handled separately by ExecuteBuiltin
 		return Result{OK: false, Error: "builtin tools must be executed via ExecuteBuiltin"}
@@ -153,14 +271,46 @@ func (t *Tool) Execute(input []byte) Result {
 	}
 }
 
-func (t *Tool) executeHTTP(params map[string]interface{}, timeout time.Duration) Result {
-	// Substitute placeholders in URL
-	url := substituteTemplate(t.URL, params)
+func (t *Tool) executeHTTP(ctx context.Context, params map[string]interface{}, timeout time.Duration) Result {
+	method := strings.ToUpper(t.Method)
+	if method == "" {
+		method = "POST"
+	}
+
+	// Substitute placeholders, then environment variables, in URL and body.
+	// Dollar signs in the params are escaped first so tool input cannot
+	// reference the environment.
+	envSafe := escapeEnvParams(params)
+	target := substituteEnvVars(substituteTemplate(t.URL, envSafe))
+
+	// An explicit query map decides the query string; without one,
+	// bodyless methods put the params the URL template did not place there.
+	autoQuery := bodylessMethods[method] && len(t.Query) == 0
+	if len(t.Query) > 0 || autoQuery {
+		u, err := url.Parse(target)
+		if err != nil {
+			return Result{OK: false, Error: fmt.Sprintf("failed to create request: %v", err)}
+		}
+		q := u.Query()
+		for key, value := range t.Query {
+			q.Set(key, substituteEnvVars(substituteTemplate(value, envSafe)))
+		}
+		if autoQuery {
+			for key, value := range params {
+				if !strings.Contains(t.URL, "{{."+key+"}}") {
+					q.Set(key, paramString(value))
+				}
+			}
+		}
+		u.RawQuery = q.Encode()
+		target = u.String()
+	}
 
-	// Substitute placeholders in body
 	var body io.Reader
-	if t.Body != "" {
-		bodyStr := substituteTemplate(t.Body, params)
+	if bodylessMethods[method] {
+		// No body for GET, HEAD and OPTIONS.
+	} else if t.Body != "" {
+		bodyStr := substituteEnvVars(substituteTemplate(t.Body, envSafe))
 		body = strings.NewReader(bodyStr)
 	} else if len(params) > 0 {
 		// If no body template but we have params, send as JSON
@@ -171,12 +321,7 @@ func (t *Tool) executeHTTP(params map[string]interface{}, timeout time.Duration)
 		body = bytes.NewReader(b)
 	}
 
-	method := t.Method
-	if method == "" {
-		method = "POST"
-	}
-
-	req, err := http.NewRequest(method, url, body)
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
 	if err != nil {
 		return Result{OK: false, Error: fmt.Sprintf("failed to create request: %v", err)}
 	}
@@ -186,8 +331,8 @@ func (t *Tool) executeHTTP(params map[string]interface{}, timeout time.Duration)
 		req.Header.Set(key, substituteEnvVars(value))
 	}
 
-	// Default content type for POST/PUT
-	if (method == "POST" || method == "PUT") && req.Header.Get("Content-Type") == "" {
+	// Default content type for requests with a body
+	if body != nil && req.Header.Get("Content-Type") == "" {
 		req.Header.Set("Content-Type", "application/json")
 	}
 
@@ -213,10 +358,43 @@ func (t *Tool) executeHTTP(params map[string]interface{}, timeout time.Duration)
 		data = string(respBody)
 	}
 
+	if t.ResultPath != "" {
+		v, ok := extractPath(data, t.ResultPath)
+		if !ok {
+			return Result{OK: true, Data: data, Warning: fmt.Sprintf("result_path %q not found; returning the full response", t.ResultPath)}
+		}
+		data = v
+	}
+
 	return Result{OK: true, Data: data}
 }
 
-func (t *Tool) executeExec(params map[string]interface{}, timeout time.Duration) Result {
+// extractPath walks a dotted path through decoded JSON. Object keys select
+// fields and numeric segments index arrays.
+func extractPath(data interface{}, path string) (interface{}, bool) {
+	cur := data
+	for _, seg := range strings.Split(path, ".") {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			next, ok := v[seg]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(seg)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+func (t *Tool) executeExec(ctx context.Context, params map[string]interface{}, timeout time.Duration) Result {
 	// Substitute placeholders in command and args
 	command := substituteTemplate(t.Command, params)
 	args := make([]string, len(t.Args))
@@ -224,44 +402,71 @@ func (t *Tool) executeExec(params map[string]interface{}, timeout time.Duration)
 		args[i] = substituteTemplate(arg, params)
 	}
 
-	cmd := exec.Command(command, args...)
+	if err := checkExecAllowed(command); err != nil {
+		return Result{OK: false, Error: err.Error()}
+	}
+	return runCommand(ctx, command, args, timeout)
+}
+
+// runCommand runs command and returns its stdout, stderr and exit code as
+// an ExecOutput result.
+func runCommand(ctx context.Context, command string, args []string, timeout time.Duration) Result {
+	runCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(runCtx, command, args...)
+	// Don't let a grandchild holding the output pipes keep Wait blocked
+	// after the command itself was killed.
+	cmd.WaitDelay = time.Second
+	// Ask well-behaved tools not to emit color codes
+	cmd.Env = append(os.Environ(), "NO_COLOR=1", "TERM=dumb")
 
 	// Capture output
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
 
-	// Run with timeout
-	done := make(chan error, 1)
-	go func() {
-		done <- cmd.Run()
-	}()
+	// Start before waiting so a missing or unrunnable command is reported
+	// as a failed result rather than a non-zero exit.
+	if err := cmd.Start(); err != nil {
+		return Result{OK: false, Error: err.Error()}
+	}
 
-	select {
-	case err := <-done:
-		if err != nil {
-			errMsg := stderr.String()
-			if errMsg == "" {
-				errMsg = err.Error()
-			}
-			return Result{OK: false, Error: errMsg}
-		}
-	case <-time.After(timeout):
-		if cmd.Process != nil {
-			cmd.Process.Kill()
+	err := cmd.Wait()
+	if runCtx.Err() != nil {
+		if ctx.Err() != nil {
+			return Result{OK: false, Error: "command cancelled: " + ctx.Err().Error()}
 		}
 		return Result{OK: false, Error: "command timed out"}
 	}
+	exitCode := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return Result{OK: false, Error: err.Error()}
+		}
+		exitCode = exitErr.ExitCode()
+	}
 
 	output := stdout.String()
+	errOutput := stderr.String()
+	if stripANSI {
+		output = StripANSI(output)
+		errOutput = StripANSI(errOutput)
+	}
 
-	// Try to parse as JSON
+	// Try to parse stdout as JSON
 	var data interface{}
 	if err := json.Unmarshal([]byte(output), &data); err != nil {
 		data = output
 	}
 
-	return Result{OK: true, Data: data}
+	// Non-zero exits are still OK results so the model sees the output of
+	// tools like linters that signal findings via the exit code.
+	return Result{OK: true, Data: ExecOutput{
+		Stdout:   data,
+		Stderr:   errOutput,
+		ExitCode: exitCode,
+	}}
 }
 
 // substituteTemplate replaces {{.field}} placeholders with values from params.
@@ -269,20 +474,44 @@ func substituteTemplate(template string, params map[string]interface{}) string {
 	result := template
 	for key, value := range params {
 		placeholder := "{{." + key + "}}"
-		var strValue string
-		switch v := value.(type) {
-		case string:
-			strValue = v
-		default:
-			b, _ := json.Marshal(v)
-			strValue = string(b)
-		}
-		result = strings.ReplaceAll(result, placeholder, strValue)
+		result = strings.ReplaceAll(result, placeholder, paramString(value))
 	}
 	return result
 }
 
+// paramString formats a param value for templates and query strings:
+// strings as-is, anything else as JSON.
+func paramString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
+
 // substituteEnvVars replaces $VAR and ${VAR} with environment variable values.
+// $$ is a literal $.
 func substituteEnvVars(s string) string {
-	return os.ExpandEnv(s)
+	return os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		return os.Getenv(name)
+	})
+}
+
+// escapeEnvParams returns params with every $ doubled, so values placed by
+// substituteTemplate come through substituteEnvVars unchanged. Non-string
+// values are converted to their JSON text first.
+func escapeEnvParams(params map[string]interface{}) map[string]interface{} {
+	escaped := make(map[string]interface{}, len(params))
+	for key, value := range params {
+		s, ok := value.(string)
+		if !ok {
+			b, _ := json.Marshal(value)
+			s = string(b)
+		}
+		escaped[key] = strings.ReplaceAll(s, "$", "$$")
+	}
+	return escaped
 }
@@ -1,10 +1,22 @@
 package plugin
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"gogo/internal/tool"
 )
 
 func TestRegistryBasics(t *testing.T) {
@@ -57,6 +69,67 @@ func TestRegistryBasics(t *testing.T) {
 	}
 }
 
+func TestRegistryFilter(t *testing.T) {
+	reg := NewRegistry()
+	for _, name := range []string{"a", "b", "c"} {
+		reg.Register(&Tool{Name: name, Type: "http", URL: "http://example.com"})
+	}
+
+	if got := reg.Filter(nil); got != reg {
+		t.Fatal("empty filter should return the full registry")
+	}
+
+	filtered := reg.Filter([]string{"a", "c", "missing"})
+	if len(filtered.All()) != 2 {
+		t.Fatalf("expected 2 tools, got %v", filtered.Names())
+	}
+	if _, ok := filtered.Get("b"); ok {
+		t.Fatal("unselected tool present")
+	}
+	if len(filtered.FormatOpenAITools()) != 2 {
+		t.Fatal("filtered registry should only format selected tools")
+	}
+	if len(reg.All()) != 3 {
+		t.Fatal("Filter modified the original registry")
+	}
+}
+
+func TestRegistryConcurrentAccess(t *testing.T) {
+	reg := NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				name := fmt.Sprintf("tool_%d_%d", i, j)
+				if err := reg.Register(&Tool{Name: name, Type: "http", URL: "http://example.com"}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				reg.Get("tool_0_0")
+				reg.All()
+				reg.Names()
+				reg.GetToolDefs()
+				reg.FormatOpenAITools()
+				reg.GenerateInstruction()
+				reg.Filter([]string{"tool_0_0"})
+				reg.Execute(context.Background(), "missing", nil)
+				reg.ExecuteTool(context.Background(), "missing", nil)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(reg.Names()); n != 8*50 {
+		t.Fatalf("expected %d tools, got %d", 8*50, n)
+	}
+}
+
 func TestRegistryValidation(t *testing.T) {
 	reg := NewRegistry()
 
@@ -65,6 +138,16 @@ func TestRegistryValidation(t *testing.T) {
 		t.Error("should reject tool without name")
 	}
 
+	// Names the provider APIs reject
+	for _, name := range []string{"my tool", "my tool!", "tool.name", "t/x", strings.Repeat("a", 65)} {
+		if err := reg.Register(&Tool{Name: name, Type: "http", URL: "http://example.com"}); err == nil {
+			t.Errorf("should reject tool name %q", name)
+		}
+	}
+	if err := reg.Register(&Tool{Name: "Get_weather-2", Type: "http", URL: "http://example.com"}); err != nil {
+		t.Errorf("valid tool name rejected: %v", err)
+	}
+
 	// Invalid type
 	if err := reg.Register(&Tool{Name: "test", Type: "invalid"}); err == nil {
 		t.Error("should reject tool with invalid type")
@@ -117,7 +200,7 @@ func TestHTTPToolExecution(t *testing.T) {
 	}
 
 	input, _ := json.Marshal(map[string]string{"message": "hello"})
-	result := tool.Execute(input)
+	result := tool.Execute(context.Background(), input)
 
 	if !result.OK {
 		t.Errorf("expected OK, got error: %s", result.Error)
@@ -132,6 +215,248 @@ func TestHTTPToolExecution(t *testing.T) {
 	}
 }
 
+func TestHTTPToolEnvSubstitution(t *testing.T) {
+	t.Setenv("GOGO_TEST_TOKEN", "s3cret")
+	t.Setenv("GOGO_TEST_USER", "ann")
+
+	var gotURL, gotBody, gotHeader string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		gotURL, gotBody, gotHeader = r.URL.String(), string(b), r.Header.Get("X-Auth")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	tool := &Tool{
+		Name:    "env",
+		Type:    "http",
+		URL:     server.URL + "/{{.path}}?token=$GOGO_TEST_TOKEN&price=$$5",
+		Body:    `{"user":"${GOGO_TEST_USER}","q":"{{.q}}","cost":"$$1"}`,
+		Headers: map[string]string{"X-Auth": "Bearer $GOGO_TEST_TOKEN $$"},
+	}
+	input := []byte(`{"path":"items","q":"$GOGO_TEST_TOKEN"}`)
+	if res := tool.Execute(context.Background(), input); !res.OK {
+		t.Fatalf("expected OK, got error: %s", res.Error)
+	}
+
+	if gotURL != "/items?token=s3cret&price=$5" {
+		t.Errorf("unexpected URL: %q", gotURL)
+	}
+	if gotBody != `{"user":"ann","q":"$GOGO_TEST_TOKEN","cost":"$1"}` {
+		t.Errorf("unexpected body: %q", gotBody)
+	}
+	if gotHeader != "Bearer s3cret $" {
+		t.Errorf("unexpected header: %q", gotHeader)
+	}
+}
+
+func TestHTTPToolGetQueryParams(t *testing.T) {
+	var got *http.Request
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	tool := &Tool{Name: "search", Type: "http", Method: "get", URL: server.URL + "/items/{{.id}}?v=1"}
+	input := []byte(`{"id":"42","q":"a b&c","limit":5}`)
+	if res := tool.Execute(context.Background(), input); !res.OK {
+		t.Fatalf("expected OK, got error: %s", res.Error)
+	}
+
+	if got.Method != "GET" || got.URL.Path != "/items/42" {
+		t.Fatalf("unexpected request: %s %s", got.Method, got.URL)
+	}
+	q := got.URL.Query()
+	if q.Get("v") != "1" || q.Get("q") != "a b&c" || q.Get("limit") != "5" || q.Has("id") {
+		t.Fatalf("unexpected query: %s", got.URL.RawQuery)
+	}
+	if len(gotBody) != 0 || got.Header.Get("Content-Type") != "" {
+		t.Fatalf("GET should have no body or content type, got %q (%s)", gotBody, got.Header.Get("Content-Type"))
+	}
+}
+
+func TestHTTPToolQueryMap(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	tool := &Tool{
+		Name:   "search",
+		Type:   "http",
+		Method: "GET",
+		URL:    server.URL + "/search",
+		Query:  map[string]string{"q": "{{.term}}", "lang": "en"},
+	}
+	input := []byte(`{"term":"a&b=c d/é?#","extra":"x"}`)
+	if res := tool.Execute(context.Background(), input); !res.OK {
+		t.Fatalf("expected OK, got error: %s", res.Error)
+	}
+
+	if !strings.Contains(got.URL.RawQuery, "q=a%26b%3Dc+d%2F%C3%A9%3F%23") {
+		t.Fatalf("query value not encoded: %s", got.URL.RawQuery)
+	}
+	q := got.URL.Query()
+	if q.Get("q") != "a&b=c d/é?#" || q.Get("lang") != "en" || q.Has("extra") || q.Has("term") {
+		t.Fatalf("unexpected query: %s", got.URL.RawQuery)
+	}
+}
+
+func TestHTTPToolResultPath(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"items":[{"name":"first"},{"name":"second","tags":["a","b"]}],"total":2}}`))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"data.total", `2`},
+		{"data.items.1.name", `"second"`},
+		{"data.items.1.tags.0", `"a"`},
+		{"data.items.0", `{"name":"first"}`},
+	}
+	for _, tt := range tests {
+		tool := &Tool{Name: "t", Type: "http", Method: "GET", URL: server.URL, ResultPath: tt.path}
+		res := tool.Execute(context.Background(), []byte(`{}`))
+		got, _ := json.Marshal(res.Data)
+		if !res.OK || string(got) != tt.want || res.Warning != "" {
+			t.Errorf("%s: got %s (ok=%v warning=%q), want %s", tt.path, got, res.OK, res.Warning, tt.want)
+		}
+	}
+
+	for _, path := range []string{"data.missing", "data.items.5.name", "data.items.x", "data.total.deeper"} {
+		tool := &Tool{Name: "t", Type: "http", Method: "GET", URL: server.URL, ResultPath: path}
+		res := tool.Execute(context.Background(), []byte(`{}`))
+		full, ok := res.Data.(map[string]interface{})
+		if !res.OK || !ok || full["data"] == nil || !strings.Contains(res.Warning, path) {
+			t.Errorf("%s: expected full response with warning, got %+v", path, res)
+		}
+	}
+}
+
+func TestToolInputValidation(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	tool := &Tool{
+		Name: "weather",
+		Type: "http",
+		URL:  server.URL,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"city":  map[string]interface{}{"type": "string"},
+				"days":  map[string]interface{}{"type": "integer"},
+				"units": map[string]interface{}{"type": []interface{}{"string", "null"}},
+				"tags":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+				"where": map[string]interface{}{
+					"type":     "object",
+					"required": []interface{}{"lat"},
+					"properties": map[string]interface{}{
+						"lat": map[string]interface{}{"type": "number"},
+					},
+				},
+			},
+			"required": []interface{}{"city"},
+		},
+	}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`{}`, `missing required field "city"`},
+		{`{"days":3}`, `missing required field "city"`},
+		{`{"city":42}`, `field "city" must be string, got number`},
+		{`{"city":"Oslo","days":"three"}`, `field "days" must be integer, got string`},
+		{`{"city":"Oslo","days":1.5}`, `field "days" must be integer, got number`},
+		{`{"city":"Oslo","units":true}`, `field "units" must be string or null, got boolean`},
+		{`{"city":"Oslo","tags":["a",1]}`, `field "tags[1]" must be string, got number`},
+		{`{"city":"Oslo","where":{}}`, `missing required field "where.lat"`},
+		{`{"city":"Oslo","where":{"lat":"north"}}`, `field "where.lat" must be number, got string`},
+	}
+	for _, tt := range tests {
+		res := tool.Execute(context.Background(), []byte(tt.input))
+		if res.OK || !strings.Contains(res.Error, "invalid input: "+tt.want) {
+			t.Errorf("%s: expected %q, got %+v", tt.input, tt.want, res)
+		}
+	}
+	if calls != 0 {
+		t.Fatalf("invalid input reached the endpoint %d times", calls)
+	}
+
+	valid := `{"city":"Oslo","days":3,"units":null,"tags":["a"],"where":{"lat":59.9},"extra":"ok"}`
+	if res := tool.Execute(context.Background(), []byte(valid)); !res.OK {
+		t.Fatalf("valid input rejected: %s", res.Error)
+	}
+}
+
+func TestToolInputCoercion(t *testing.T) {
+	var gotBody []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	tool := &Tool{
+		Name: "search",
+		Type: "http",
+		URL:  server.URL,
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"limit":  map[string]interface{}{"type": "integer"},
+				"score":  map[string]interface{}{"type": "number"},
+				"exact":  map[string]interface{}{"type": "boolean"},
+				"code":   map[string]interface{}{"type": "string"},
+				"either": map[string]interface{}{"type": []interface{}{"string", "integer"}},
+				"ids":    map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "integer"}},
+			},
+		},
+	}
+
+	input := `{"limit":"10","score":" 0.5","exact":"false","code":"007","either":"12","ids":["1","2"]}`
+	if res := tool.Execute(context.Background(), []byte(input)); !res.OK {
+		t.Fatalf("expected OK, got error: %s", res.Error)
+	}
+	want := `{"code":"007","either":"12","exact":false,"ids":[1,2],"limit":10,"score":0.5}`
+	if string(gotBody) != want {
+		t.Fatalf("unexpected body:\n got %s\nwant %s", gotBody, want)
+	}
+
+	res := tool.Execute(context.Background(), []byte(`{"exact":"yes"}`))
+	if res.OK || !strings.Contains(res.Error, `field "exact" must be boolean`) {
+		t.Fatalf("expected unparseable boolean to be rejected, got %+v", res)
+	}
+}
+
+func TestHTTPToolMethodValidation(t *testing.T) {
+	reg := NewRegistry()
+	for _, m := range []string{"", "GET", "head", "Options", "POST", "PUT", "PATCH", "DELETE"} {
+		if err := reg.Register(&Tool{Name: "ok", Type: "http", URL: "http://x", Method: m}); err != nil {
+			t.Errorf("method %q rejected: %v", m, err)
+		}
+	}
+	for _, m := range []string{"PSOT", "CONNECT", "G E T"} {
+		err := reg.Register(&Tool{Name: "bad", Type: "http", URL: "http://x", Method: m})
+		if err == nil || !strings.Contains(err.Error(), "invalid method") {
+			t.Errorf("method %q: expected invalid method error, got %v", m, err)
+		}
+	}
+}
+
 func TestExecToolExecution(t *testing.T) {
 	tool := &Tool{
 		Name:        "test-echo",
@@ -143,18 +468,542 @@ func TestExecToolExecution(t *testing.T) {
 	}
 
 	input, _ := json.Marshal(map[string]string{"message": "hello world"})
-	result := tool.Execute(input)
+	result := tool.Execute(context.Background(), input)
 
 	if !result.OK {
 		t.Errorf("expected OK, got error: %s", result.Error)
 	}
 
-	output, ok := result.Data.(string)
+	output, ok := result.Data.(ExecOutput)
+	if !ok {
+		t.Fatalf("expected ExecOutput result, got %T", result.Data)
+	}
+	if output.Stdout != "hello world\n" {
+		t.Errorf("expected 'hello world\\n', got %q", output.Stdout)
+	}
+	if output.ExitCode != 0 {
+		t.Errorf("expected exit code 0, got %d", output.ExitCode)
+	}
+}
+
+func TestExecToolNonZeroExit(t *testing.T) {
+	tool := &Tool{
+		Name:    "test-fail",
+		Type:    "exec",
+		Command: "sh",
+		Args:    []string{"-c", "echo findings; echo oops >&2; exit 3"},
+	}
+
+	result := tool.Execute(context.Background(), nil)
+	if !result.OK {
+		t.Fatalf("non-zero exit should still be OK, got error: %s", result.Error)
+	}
+	output, ok := result.Data.(ExecOutput)
+	if !ok {
+		t.Fatalf("expected ExecOutput result, got %T", result.Data)
+	}
+	if output.Stdout != "findings\n" {
+		t.Errorf("unexpected stdout: %q", output.Stdout)
+	}
+	if output.Stderr != "oops\n" {
+		t.Errorf("unexpected stderr: %q", output.Stderr)
+	}
+	if output.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", output.ExitCode)
+	}
+}
+
+func TestToolExecuteCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	tools := []*Tool{
+		{Name: "slow_http", Type: "http", URL: server.URL},
+		{Name: "slow_exec", Type: "exec", Command: "sleep", Args: []string{"10"}},
+	}
+	for _, tool := range tools {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+		res := tool.Execute(ctx, nil)
+		cancel()
+		if res.OK {
+			t.Fatalf("%s: expected cancelled result", tool.Name)
+		}
+		if tool.Type == "exec" && !strings.Contains(res.Error, "cancelled") {
+			t.Fatalf("%s: expected cancellation error, got %q", tool.Name, res.Error)
+		}
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Fatalf("%s: cancellation took %v", tool.Name, elapsed)
+		}
+	}
+}
+
+func TestExecToolStartFailure(t *testing.T) {
+	tool := &Tool{Name: "missing", Type: "exec", Command: "gogo-no-such-command"}
+	if result := tool.Execute(context.Background(), nil); result.OK {
+		t.Fatal("expected error result when command cannot be started")
+	}
+}
+
+func TestExecAllowlist(t *testing.T) {
+	SetExecAllowlist([]string{"echo"})
+	defer SetExecAllowlist(nil)
+
+	allowed := &Tool{Name: "echo", Type: "exec", Command: "echo", Args: []string{"hi"}}
+	if res := allowed.Execute(context.Background(), nil); !res.OK {
+		t.Fatalf("expected allowed command to run, got error: %s", res.Error)
+	}
+
+	denied := &Tool{Name: "ls", Type: "exec", Command: "ls"}
+	res := denied.Execute(context.Background(), nil)
+	if res.OK {
+		t.Fatal("expected command outside allowlist to be rejected")
+	}
+	if !strings.Contains(res.Error, "allowlist") {
+		t.Fatalf("unexpected error: %s", res.Error)
+	}
+}
+
+func TestExecAllowlistEnv(t *testing.T) {
+	t.Setenv(ExecAllowEnv, "true, echo")
+
+	denied := &Tool{Name: "ls", Type: "exec", Command: "ls"}
+	if res := denied.Execute(context.Background(), nil); res.OK {
+		t.Fatal("expected command outside env allowlist to be rejected")
+	}
+	allowed := &Tool{Name: "echo", Type: "exec", Command: "echo"}
+	if res := allowed.Execute(context.Background(), nil); !res.OK {
+		t.Fatalf("expected env-allowed command to run, got error: %s", res.Error)
+	}
+}
+
+func TestExecAllowlistFromFile(t *testing.T) {
+	defer SetExecAllowlist(nil)
+
+	path := filepath.Join(t.TempDir(), "plugins.json")
+	cfg := `{"exec_allowlist":["echo"],"tools":[{"name":"ls","type":"exec","command":"ls"}]}`
+	if err := os.WriteFile(path, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reg, _, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile returned error: %v", err)
+	}
+	if res := reg.Execute(context.Background(), "ls", nil); res.OK {
+		t.Fatal("expected command outside file allowlist to be rejected")
+	}
+}
+
+func TestLoadFromFileDuplicateNames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugins.json")
+	cfg := `{"tools":[
+		{"name":"dup","type":"http","url":"http://a.example"},
+		{"name":"other","type":"http","url":"http://b.example"},
+		{"name":"dup","type":"http","url":"http://c.example"}
+	]}`
+	if err := os.WriteFile(path, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := LoadFromFile(path)
+	if err == nil || !strings.Contains(err.Error(), `duplicate tool name "dup"`) {
+		t.Fatalf("expected duplicate name error, got %v", err)
+	}
+}
+
+func TestLoadFromFileErrorPosition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugins.json")
+	tests := []struct {
+		cfg  string
+		want string
+	}{
+		{"{\"tools\":[\n  {\"name\":\"a\",},\n]}", path + ":2:15: "},
+		{"{\"tools\":[\n  {\"name\":\"a\"},\n  {\"name\":42}\n]}", path + ":3:12: "},
+	}
+	for _, tc := range tests {
+		if err := os.WriteFile(path, []byte(tc.cfg), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err := LoadFromFile(path)
+		if err == nil || !strings.HasPrefix(err.Error(), tc.want) {
+			t.Errorf("expected error starting with %q, got %v", tc.want, err)
+		}
+	}
+}
+
+func TestLoadFromFileWarnings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plugins.json")
+	cfg := `{"tools":[
+		{"name":"good","type":"http","url":"http://a.example"},
+		{"name":"typo","type":"htpp","url":"http://b.example"}
+	]}`
+	if err := os.WriteFile(path, []byte(cfg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reg, warnings, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatalf("LoadFromFile returned error: %v", err)
+	}
+	if _, ok := reg.Get("good"); !ok {
+		t.Fatal("valid tool not loaded")
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Error(), `"typo"`) || !strings.Contains(warnings[0].Error(), "invalid tool type") {
+		t.Fatalf("expected one warning for the typo'd tool, got %v", warnings)
+	}
+}
+
+func TestTruncateResult(t *testing.T) {
+	res := truncateResult(Result{OK: true, Data: strings.Repeat("a", 20)}, 8)
+	if res.Data != "aaaaaaaa...[truncated 12 bytes]" {
+		t.Fatalf("unexpected string truncation: %v", res.Data)
+	}
+
+	res = truncateResult(Result{OK: true, Data: map[string]string{"k": strings.Repeat("b", 20)}}, 10)
+	s, ok := res.Data.(string)
+	if !ok || !strings.HasPrefix(s, `{"k":"bbbb`) || !strings.HasSuffix(s, "...[truncated 18 bytes]") {
+		t.Fatalf("unexpected structured truncation: %v", res.Data)
+	}
+
+	// Cuts never split a multi-byte rune.
+	res = truncateResult(Result{Data: "ééé"}, 3)
+	if res.Data != "é...[truncated 4 bytes]" {
+		t.Fatalf("unexpected rune-safe truncation: %v", res.Data)
+	}
+
+	small := Result{OK: true, Data: "short"}
+	if got := truncateResult(small, 100); got.Data != "short" {
+		t.Fatalf("small result changed: %v", got.Data)
+	}
+	if got := truncateResult(Result{Data: strings.Repeat("x", 50)}, -1); got.Data != strings.Repeat("x", 50) {
+		t.Fatal("negative limit should disable truncation")
+	}
+}
+
+func TestRegistryTruncatesOutput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("z", 500)))
+	}))
+	defer server.Close()
+
+	reg := NewRegistry()
+	reg.SetMaxOutputBytes(100)
+	reg.Register(&Tool{Name: "big", Type: "http", URL: server.URL})
+
+	for _, res := range []Result{reg.Execute(context.Background(), "big", nil), reg.ExecuteTool(context.Background(), "big", nil)} {
+		s, ok := res.Data.(string)
+		if !ok || !strings.HasSuffix(s, "...[truncated 400 bytes]") {
+			t.Fatalf("expected truncated data, got %v", res.Data)
+		}
+	}
+}
+
+func TestExecUnrestrictedWarnsOnce(t *testing.T) {
+	var buf bytes.Buffer
+	origOut := warnOut
+	warnOut = &buf
+	warnOnce = sync.Once{}
+	defer func() { warnOut = origOut }()
+
+	tool := &Tool{Name: "echo", Type: "exec", Command: "echo"}
+	tool.Execute(context.Background(), nil)
+	tool.Execute(context.Background(), nil)
+
+	if n := strings.Count(buf.String(), "warning:"); n != 1 {
+		t.Fatalf("expected exactly one warning, got %d: %q", n, buf.String())
+	}
+}
+
+func TestFetchBuiltin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			t.Errorf("expected PUT, got %s", r.Method)
+		}
+		if r.Header.Get("X-Test") != "yes" {
+			t.Errorf("expected X-Test header, got %q", r.Header.Get("X-Test"))
+		}
+		w.Header().Set("X-Reply", "ok")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	defer server.Close()
+
+	SetFetchAllowPrivate(true)
+	defer SetFetchAllowPrivate(false)
+
+	input, _ := json.Marshal(map[string]interface{}{
+		"url":     server.URL,
+		"method":  "PUT",
+		"headers": map[string]string{"X-Test": "yes"},
+		"body":    "payload",
+	})
+	res, handled := ExecuteBuiltin(context.Background(), FetchToolName, input)
+	if !handled {
+		t.Fatal("fetch should be handled as a builtin")
+	}
+	if !res.OK {
+		t.Fatalf("expected OK, got error: %s", res.Error)
+	}
+	resp, ok := res.Data.(tool.FetchResponse)
 	if !ok {
-		t.Errorf("expected string result, got %T", result.Data)
+		t.Fatalf("expected FetchResponse, got %T", res.Data)
+	}
+	if resp.Status != http.StatusCreated || resp.Body != "created" || resp.Headers["X-Reply"] != "ok" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+}
+
+func TestFetchBlocksPrivateByDefault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request to loopback should have been blocked")
+	}))
+	defer server.Close()
+
+	input, _ := json.Marshal(map[string]string{"url": server.URL})
+	res := ExecuteFetch(context.Background(), input)
+	if res.OK {
+		t.Fatal("expected loopback fetch to be blocked")
+	}
+	if !strings.Contains(res.Error, "not allowed") {
+		t.Fatalf("unexpected error: %s", res.Error)
+	}
+}
+
+func TestFetchIgnoresProxyForPrivateCheck(t *testing.T) {
+	// A proxy would be dialed instead of the target, hiding the target's
+	// address from the private-address check.
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request should not go through the proxy")
+	}))
+	defer proxy.Close()
+	t.Setenv("HTTP_PROXY", proxy.URL)
+	t.Setenv("http_proxy", proxy.URL)
+
+	input, _ := json.Marshal(map[string]string{"url": "http://169.254.169.254/latest/meta-data/"})
+	res := ExecuteFetch(context.Background(), input)
+	if res.OK || !strings.Contains(res.Error, "169.254.169.254 is not allowed") {
+		t.Fatalf("expected the target address to be blocked, got %+v", res)
+	}
+}
+
+func TestFetchTruncatesBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("a", 100)))
+	}))
+	defer server.Close()
+
+	resp, err := tool.Fetch(context.Background(), tool.FetchRequest{URL: server.URL}, tool.FetchOptions{AllowPrivate: true, MaxBytes: 10})
+	if err != nil {
+		t.Fatalf("Fetch returned error: %v", err)
+	}
+	if len(resp.Body) != 10 || !resp.Truncated {
+		t.Fatalf("expected 10-byte truncated body, got %d bytes truncated=%t", len(resp.Body), resp.Truncated)
+	}
+}
+
+func TestExecStripANSI(t *testing.T) {
+	SetStripANSI(true)
+	defer SetStripANSI(false)
+
+	tool := &Tool{
+		Name:    "color",
+		Type:    "exec",
+		Command: "printf",
+		Args:    []string{"\033[1;31merror\033[0m: \033]8;;http://x\033\\link\033]8;;\033\\ done"},
+	}
+	res := tool.Execute(context.Background(), nil)
+	if !res.OK {
+		t.Fatalf("expected OK, got error: %s", res.Error)
+	}
+	out := res.Data.(ExecOutput)
+	if out.Stdout != "error: link done" {
+		t.Fatalf("escape sequences not stripped: %q", out.Stdout)
 	}
-	if output != "hello world\n" {
-		t.Errorf("expected 'hello world\\n', got %q", output)
+}
+
+func TestExecSetsNoColorEnv(t *testing.T) {
+	tool := &Tool{Name: "env", Type: "exec", Command: "sh", Args: []string{"-c", "printf '%s %s' \"$NO_COLOR\" \"$TERM\""}}
+	res := tool.Execute(context.Background(), nil)
+	if !res.OK {
+		t.Fatalf("expected OK, got error: %s", res.Error)
+	}
+	if out := res.Data.(ExecOutput); out.Stdout != "1 dumb" {
+		t.Fatalf("unexpected child env: %q", out.Stdout)
+	}
+}
+
+func TestShellBuiltinPolicy(t *testing.T) {
+	origIn, origOut, origTTY := shellIn, shellOut, shellInteractive
+	defer func() {
+		shellIn, shellOut, shellInteractive = origIn, origOut, origTTY
+		SetShellPolicy(false, false)
+	}()
+	input := []byte(`{"command":"echo hi; echo oops >&2; exit 3"}`)
+
+	SetShellPolicy(false, false)
+	if reg, _, err := LoadWithBuiltins(nil); err != nil {
+		t.Fatal(err)
+	} else if _, ok := reg.Get(ShellToolName); ok {
+		t.Fatal("shell tool registered without a policy")
+	}
+
+	// --confirm-shell without a terminal refuses
+	SetShellPolicy(true, false)
+	shellInteractive = func() bool { return false }
+	if res := ExecuteShell(context.Background(), input); res.OK || !strings.Contains(res.Error, "--yes") {
+		t.Fatalf("expected refusal, got %+v", res)
+	}
+
+	// A terminal answer of n declines, y runs
+	var prompt bytes.Buffer
+	shellOut = &prompt
+	shellInteractive = func() bool { return true }
+	shellIn = strings.NewReader("n\n")
+	if res := ExecuteShell(context.Background(), input); res.OK || !strings.Contains(res.Error, "declined") {
+		t.Fatalf("expected decline, got %+v", res)
+	}
+	if !strings.Contains(prompt.String(), "echo hi") {
+		t.Fatalf("prompt did not show the command: %q", prompt.String())
+	}
+	shellIn = strings.NewReader("y\n")
+	res := ExecuteShell(context.Background(), input)
+	if !res.OK {
+		t.Fatalf("expected OK, got error: %s", res.Error)
+	}
+	out := res.Data.(ExecOutput)
+	if out.Stdout != "hi\n" || out.Stderr != "oops\n" || out.ExitCode != 3 {
+		t.Fatalf("unexpected output: %+v", out)
+	}
+
+	// --yes alone does not enable the shell tool
+	SetShellPolicy(false, true)
+	if res := ExecuteShell(context.Background(), input); res.OK || !strings.Contains(res.Error, "disabled") {
+		t.Fatalf("expected disabled shell, got %+v", res)
+	}
+	reg, _, err := LoadWithBuiltins(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reg.Get(ShellToolName); ok {
+		t.Fatal("shell tool registered with --yes alone")
+	}
+
+	// --confirm-shell --yes runs without asking
+	SetShellPolicy(true, true)
+	shellInteractive = func() bool { t.Fatal("--yes should not check for a terminal"); return false }
+	if res := ExecuteShell(context.Background(), input); !res.OK {
+		t.Fatalf("expected OK, got error: %s", res.Error)
+	}
+	if reg, _, err = LoadWithBuiltins(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reg.Get(ShellToolName); !ok {
+		t.Fatal("shell tool not registered with --confirm-shell")
+	}
+}
+
+func TestFSConfirmPolicy(t *testing.T) {
+	origIn, origOut, origTTY := shellIn, shellOut, shellInteractive
+	defer func() {
+		shellIn, shellOut, shellInteractive = origIn, origOut, origTTY
+		SetFSPolicy(false, false)
+	}()
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	input := []byte(fmt.Sprintf(`{"op":"write","path":%q,"data":"new"}`, path))
+
+	// --confirm-destructive without a terminal refuses
+	SetFSPolicy(true, false)
+	shellInteractive = func() bool { return false }
+	if res := ExecuteFS(input); res.OK || !strings.Contains(res.Error, "--yes") {
+		t.Fatalf("expected refusal, got %+v", res)
+	}
+
+	// A terminal answer of n declines, y writes
+	var prompt bytes.Buffer
+	shellOut = &prompt
+	shellInteractive = func() bool { return true }
+	shellIn = strings.NewReader("n\n")
+	if res := ExecuteFS(input); res.OK || !strings.Contains(res.Error, "declined") {
+		t.Fatalf("expected decline, got %+v", res)
+	}
+	if !strings.Contains(prompt.String(), "overwrite "+path) {
+		t.Fatalf("prompt did not show the action: %q", prompt.String())
+	}
+	if b, _ := os.ReadFile(path); string(b) != "old" {
+		t.Fatalf("declined write changed the file: %q", b)
+	}
+	shellIn = strings.NewReader("y\n")
+	if res := ExecuteFS(input); !res.OK {
+		t.Fatalf("expected OK, got error: %s", res.Error)
+	}
+
+	// --yes allows without asking, and does not enable the shell tool
+	SetFSPolicy(true, true)
+	SetShellPolicy(false, true)
+	defer SetShellPolicy(false, false)
+	shellInteractive = func() bool { t.Fatal("--yes should not check for a terminal"); return false }
+	if res := ExecuteFS(input); !res.OK {
+		t.Fatalf("expected OK, got error: %s", res.Error)
+	}
+	reg, _, err := LoadWithBuiltins(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reg.Get(ShellToolName); ok {
+		t.Fatal("--confirm-destructive --yes registered the shell tool")
+	}
+}
+
+func TestLoadWithBuiltinsList(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	tests := []struct {
+		builtins []string
+		want     []string
+	}{
+		{nil, []string{FetchToolName, FSToolName}},
+		{[]string{}, nil},
+		{[]string{FetchToolName}, []string{FetchToolName}},
+	}
+	for _, tc := range tests {
+		reg, _, err := LoadWithBuiltins(tc.builtins)
+		if err != nil {
+			t.Fatalf("LoadWithBuiltins(%v) returned error: %v", tc.builtins, err)
+		}
+		got := reg.Names()
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("LoadWithBuiltins(%v) registered %v, want %v", tc.builtins, got, tc.want)
+		}
+	}
+
+	if _, _, err := LoadWithBuiltins([]string{"ftp"}); err == nil || !strings.Contains(err.Error(), `unknown builtin "ftp"`) {
+		t.Fatalf("expected unknown builtin error, got %v", err)
+	}
+}
+
+func TestResultDuration(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(&Tool{Name: "nap", Type: "exec", Command: "sleep", Args: []string{"0.2"}})
+	reg.setTool(BuiltinFS())
+
+	res := reg.Execute(context.Background(), "nap", nil)
+	if !res.OK {
+		t.Fatalf("expected OK, got error: %s", res.Error)
+	}
+	if res.DurationMS < 150 || res.DurationMS > 5000 {
+		t.Fatalf("duration_ms = %d, want about 200", res.DurationMS)
+	}
+	if !strings.Contains(res.ToJSON(), fmt.Sprintf(`"duration_ms":%d`, res.DurationMS)) {
+		t.Fatalf("duration_ms missing from payload: %s", res.ToJSON())
+	}
+
+	res = reg.ExecuteTool(context.Background(), FSToolName, []byte(`{"op":"stat","path":"."}`))
+	if !res.OK || !strings.Contains(res.ToJSON(), `"duration_ms":`) {
+		t.Fatalf("builtin result missing duration_ms: %s", res.ToJSON())
 	}
 }
 
@@ -263,6 +1112,44 @@ func TestGenerateInstruction(t *testing.T) {
 	}
 }
 
+func TestFormatCache(t *testing.T) {
+	reg := NewRegistry()
+	reg.Register(&Tool{Name: "one", Description: "first", Type: "http", URL: "http://example.com"})
+
+	same := func(a, b []map[string]interface{}) bool {
+		return len(a) > 0 && len(b) > 0 && &a[0] == &b[0]
+	}
+
+	openai := reg.FormatOpenAITools()
+	if !same(openai, reg.FormatOpenAITools()) {
+		t.Error("expected cached OpenAI tools to be reused")
+	}
+	anthropic := reg.FormatAnthropicTools()
+	if !same(anthropic, reg.FormatAnthropicTools()) {
+		t.Error("expected cached Anthropic tools to be reused")
+	}
+	gemini := reg.FormatGeminiTools()
+	if !same(gemini, reg.FormatGeminiTools()) {
+		t.Error("expected cached Gemini tools to be reused")
+	}
+	instr := reg.GenerateInstruction()
+
+	reg.Register(&Tool{Name: "two", Description: "second", Type: "http", URL: "http://example.com"})
+
+	if got := reg.FormatOpenAITools(); same(openai, got) || len(got) != 2 {
+		t.Error("expected OpenAI tools to be rebuilt after Register")
+	}
+	if got := reg.FormatAnthropicTools(); same(anthropic, got) || len(got) != 2 {
+		t.Error("expected Anthropic tools to be rebuilt after Register")
+	}
+	if got := reg.FormatGeminiTools(); same(gemini, got) || len(got) != 2 {
+		t.Error("expected Gemini tools to be rebuilt after Register")
+	}
+	if got := reg.GenerateInstruction(); got == instr || !contains(got, "second") {
+		t.Error("expected instruction to be rebuilt after Register")
+	}
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsHelper(s, substr))
 }
@@ -275,3 +1162,76 @@ func containsHelper(s, substr string) bool {
 	}
 	return false
 }
+
+func TestFSSchemaOpEnum(t *testing.T) {
+	reg := NewRegistry()
+	if err := reg.Register(BuiltinFS()); err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(reg.FormatAnthropicTools()[0]["input_schema"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties struct {
+			Op struct {
+				Enum []string `json:"enum"`
+			} `json:"op"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(b, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"read", "write", "append", "delete", "mkdir", "rmdir", "list", "stat", "move", "copy", "chmod", "touch", "head", "tail"}
+	if strings.Join(schema.Properties.Op.Enum, ",") != strings.Join(want, ",") {
+		t.Fatalf("op enum = %v, want %v", schema.Properties.Op.Enum, want)
+	}
+	// Every advertised op must be one FS handles.
+	for _, op := range schema.Properties.Op.Enum {
+		if res := tool.FS(tool.FSRequest{Op: op}); res.Error == "unknown op" {
+			t.Errorf("op %q is advertised but not implemented", op)
+		}
+	}
+}
+
+func TestWriteList(t *testing.T) {
+	reg := NewRegistry()
+	reg.setTool(BuiltinFetch())
+	reg.Register(&Tool{Name: "weather", Description: "Current weather", Type: "http", URL: "http://example.com"})
+	reg.Register(&Tool{Name: "date", Description: "Print the date", Type: "exec", Command: "date"})
+
+	var out bytes.Buffer
+	if err := WriteList(&out, reg); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 || lines[0] != "Builtin tools:" || !strings.HasPrefix(lines[2], "User tools") {
+		t.Fatalf("unexpected grouping:\n%s", out.String())
+	}
+	for i, want := range map[int][]string{1: {"fetch", "builtin"}, 3: {"date", "exec", "Print the date"}, 4: {"weather", "http", "Current weather"}} {
+		for _, w := range want {
+			if !strings.Contains(lines[i], w) {
+				t.Errorf("line %d %q missing %q", i, lines[i], w)
+			}
+		}
+	}
+}
+
+func TestDefaultPathConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("GOGO_CONFIG_DIR", dir)
+	if got, want := DefaultPath(), filepath.Join(dir, "plugins.json"); got != want {
+		t.Fatalf("DefaultPath() = %q, want %q", got, want)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "plugins.json"), []byte(`{"tools":[{"name":"date","type":"exec","command":"date"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reg, _, err := LoadDefault()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reg.Get("date"); !ok {
+		t.Fatal("plugins.json in GOGO_CONFIG_DIR was not loaded")
+	}
+}
@@ -1,6 +1,7 @@
 package plugin
 
 import (
+	"context"
 	"encoding/json"
 )
 
@@ -14,8 +15,10 @@ type ToolDef struct {
 // GetToolDefs returns tool definitions for all registered tools.
 // This is used by providers to build their tool registration payloads.
 func (r *Registry) GetToolDefs() []ToolDef {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	defs := make([]ToolDef, 0, len(r.tools))
-	for _, t := range r.tools {
+	for _, t := range r.sorted() {
 		schema := t.InputSchema
 		if schema == nil {
 			// Default schema if none provided
@@ -34,70 +37,83 @@ func (r *Registry) GetToolDefs() []ToolDef {
 }
 
 // ExecuteTool runs a tool by name with JSON input bytes.
-// It handles both builtin and user-defined tools.
-func (r *Registry) ExecuteTool(name string, input []byte) Result {
-	t, ok := r.tools[name]
+// It handles both builtin and user-defined tools. Cancelling ctx aborts
+// in-flight HTTP requests and kills running commands.
+func (r *Registry) ExecuteTool(ctx context.Context, name string, input []byte) Result {
+	t, ok := r.Get(name)
 	if !ok {
 		return Result{OK: false, Error: "unknown tool: " + name}
 	}
 
-	if t.Type == "builtin" {
-		res, handled := ExecuteBuiltin(name, input)
-		if handled {
-			return res
+	return timed(func() Result {
+		if t.Type == "builtin" {
+			res, handled := ExecuteBuiltin(ctx, name, input)
+			if handled {
+				return truncateResult(res, r.outputLimit())
+			}
+			return Result{OK: false, Error: "unhandled builtin tool: " + name}
 		}
-		return Result{OK: false, Error: "unhandled builtin tool: " + name}
-	}
-
-	return t.Execute(input)
+		return truncateResult(t.Execute(ctx, input), r.outputLimit())
+	})
 }
 
 // FormatAnthropicTools formats tools for Anthropic's API.
+// The result is cached until the registry changes; callers must not modify it.
 func (r *Registry) FormatAnthropicTools() []map[string]interface{} {
-	tools := make([]map[string]interface{}, 0, len(r.tools))
-	for _, t := range r.tools {
-		schema := t.InputSchema
-		if schema == nil {
-			schema = map[string]interface{}{
-				"type":       "object",
-				"properties": map[string]interface{}{},
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cache.anthropic == nil {
+		r.cache.anthropic = r.formatTools(func(t *Tool, schema map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{
+				"name":         t.Name,
+				"description":  t.Description,
+				"input_schema": schema,
 			}
-		}
-		tools = append(tools, map[string]interface{}{
-			"name":         t.Name,
-			"description":  t.Description,
-			"input_schema": schema,
 		})
 	}
-	return tools
+	return r.cache.anthropic
 }
 
 // FormatOpenAITools formats tools for OpenAI's API.
+// The result is cached until the registry changes; callers must not modify it.
 func (r *Registry) FormatOpenAITools() []map[string]interface{} {
-	tools := make([]map[string]interface{}, 0, len(r.tools))
-	for _, t := range r.tools {
-		schema := t.InputSchema
-		if schema == nil {
-			schema = map[string]interface{}{
-				"type":       "object",
-				"properties": map[string]interface{}{},
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cache.openai == nil {
+		r.cache.openai = r.formatTools(func(t *Tool, schema map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{
+				"type":        "function",
+				"name":        t.Name,
+				"description": t.Description,
+				"parameters":  schema,
 			}
-		}
-		tools = append(tools, map[string]interface{}{
-			"type":        "function",
-			"name":        t.Name,
-			"description": t.Description,
-			"parameters":  schema,
 		})
 	}
-	return tools
+	return r.cache.openai
 }
 
 // FormatGeminiTools formats tools for Gemini's API.
-// Returns a slice that can be used directly in geminiFunctionDecl structs.
+// Each entry is a functionDeclarations item.
+// The result is cached until the registry changes; callers must not modify it.
 func (r *Registry) FormatGeminiTools() []map[string]interface{} {
-	decls := make([]map[string]interface{}, 0, len(r.tools))
-	for _, t := range r.tools {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cache.gemini == nil {
+		r.cache.gemini = r.formatTools(func(t *Tool, schema map[string]interface{}) map[string]interface{} {
+			return map[string]interface{}{
+				"name":        t.Name,
+				"description": t.Description,
+				"parameters":  schema,
+			}
+		})
+	}
+	return r.cache.gemini
+}
+
+// formatTools builds one provider payload per tool. The caller must hold r.mu.
+func (r *Registry) formatTools(format func(t *Tool, schema map[string]interface{}) map[string]interface{}) []map[string]interface{} {
+	tools := make([]map[string]interface{}, 0, len(r.tools))
+	for _, t := range r.sorted() {
 		schema := t.InputSchema
 		if schema == nil {
 			schema = map[string]interface{}{
@@ -105,13 +121,9 @@ func (r *Registry) FormatGeminiTools() []map[string]interface{} {
 				"properties": map[string]interface{}{},
 			}
 		}
-		decls = append(decls, map[string]interface{}{
-			"name":        t.Name,
-			"description": t.Description,
-			"parameters":  schema,
-		})
+		tools = append(tools, format(t, schema))
 	}
-	return decls
+	return tools
 }
 
 // ToJSON converts a result to JSON string.
@@ -121,15 +133,22 @@ func (r Result) ToJSON() string {
 }
 
 // GenerateInstruction creates a system instruction for all registered tools.
+// The result is cached until the registry changes.
 func (r *Registry) GenerateInstruction() string {
-	if len(r.tools) == 0 {
-		return ""
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cache.instruction != nil {
+		return *r.cache.instruction
 	}
 
-	instruction := "You have access to the following tools. Use them when appropriate:\n\n"
-	for _, t := range r.tools {
-		instruction += "- " + t.Name + ": " + t.Description + "\n"
+	instruction := ""
+	if len(r.tools) > 0 {
+		instruction = "You have access to the following tools. Use them when appropriate:\n\n"
+		for _, t := range r.sorted() {
+			instruction += "- " + t.Name + ": " + t.Description + "\n"
+		}
+		instruction += "\nCall tools when needed to complete the user's request. Do not claim to have performed actions without using the appropriate tool."
 	}
-	instruction += "\nCall tools when needed to complete the user's request. Do not claim to have performed actions without using the appropriate tool."
+	r.cache.instruction = &instruction
 	return instruction
 }
@@ -0,0 +1,214 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// coerceInput converts string values to the number, integer or boolean
+// type their schema declares, since some models quote every value. Values
+// that do not parse are left alone for validateInput to report.
+func coerceInput(schema map[string]interface{}, params map[string]interface{}) {
+	if schema != nil {
+		coerceValue(schema, params)
+	}
+}
+
+func coerceValue(schema map[string]interface{}, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		types := schemaTypes(schema)
+		if len(types) == 0 || matchesAny(types, v) {
+			return v
+		}
+		for _, t := range types {
+			if c, ok := coerceString(t, v); ok {
+				return c
+			}
+		}
+	case map[string]interface{}:
+		props, _ := schema["properties"].(map[string]interface{})
+		for name, sub := range props {
+			subSchema, ok := sub.(map[string]interface{})
+			if field, present := v[name]; ok && present {
+				v[name] = coerceValue(subSchema, field)
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				v[i] = coerceValue(items, item)
+			}
+		}
+	}
+	return value
+}
+
+func coerceString(t, s string) (interface{}, bool) {
+	s = strings.TrimSpace(s)
+	switch t {
+	case "integer":
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return float64(n), true
+		}
+	case "number":
+		if f, err := strconv.ParseFloat(s, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return f, true
+		}
+	case "boolean":
+		switch s {
+		case "true":
+			return true, true
+		case "false":
+			return false, true
+		}
+	}
+	return nil, false
+}
+
+// validateInput checks params against a tool's JSON Schema: required
+// fields and the declared types of properties, recursing into nested
+// objects and array items. It covers the subset of JSON Schema tool
+// definitions use, and reports the first problem in terms the model can
+// act on.
+func validateInput(schema map[string]interface{}, params map[string]interface{}) error {
+	if schema == nil {
+		return nil
+	}
+	return validateValue(schema, params, "")
+}
+
+func validateValue(schema map[string]interface{}, value interface{}, path string) error {
+	if types := schemaTypes(schema); len(types) > 0 && !matchesAny(types, value) {
+		return fmt.Errorf("%s must be %s, got %s", fieldName(path), strings.Join(types, " or "), jsonType(value))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, name := range requiredFields(schema) {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("missing required field %q", joinPath(path, name))
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(props))
+		for name := range props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sub, ok := props[name].(map[string]interface{})
+			field, present := v[name]
+			if !ok || !present {
+				continue
+			}
+			if err := validateValue(sub, field, joinPath(path, name)); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items, ok := schema["items"].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for i, item := range v {
+			if err := validateValue(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// schemaTypes returns the schema's "type", which may be a string or a list.
+func schemaTypes(schema map[string]interface{}) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var types []string
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func requiredFields(schema map[string]interface{}) []string {
+	switch req := schema["required"].(type) {
+	case []string:
+		return req
+	case []interface{}:
+		var names []string
+		for _, v := range req {
+			if s, ok := v.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+func matchesAny(types []string, value interface{}) bool {
+	for _, t := range types {
+		if matchesType(t, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesType(t string, value interface{}) bool {
+	switch t {
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "object", "array", "string", "boolean", "null":
+		return jsonType(value) == t
+	}
+	// Unknown types are not ours to reject.
+	return true
+}
+
+// jsonType names the JSON type of a value decoded by encoding/json.
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func fieldName(path string) string {
+	if path == "" {
+		return "input"
+	}
+	return fmt.Sprintf("field %q", path)
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,116 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ShellToolName is the name of the built-in shell tool.
+const ShellToolName = "shell"
+
+// shellTimeout bounds a single shell command.
+const shellTimeout = 2 * time.Minute
+
+var (
+	// shellConfirm enables the shell tool, asking on the terminal before
+	// each command; shellYes skips the question. Without shellConfirm the
+	// shell tool is not registered.
+	shellConfirm bool
+	shellYes     bool
+
+	// shellIn and shellOut are the confirmation prompt's terminal, and
+	// shellInteractive reports whether shellIn is one.
+	shellIn          io.Reader = os.Stdin
+	shellOut         io.Writer = os.Stderr
+	shellInteractive           = stdinIsTerminal
+)
+
+// SetShellPolicy enables the shell tool with confirm: each command is shown
+// and needs a y/n answer on the terminal, and without a terminal every
+// command is refused. yes runs the commands without asking, but does not
+// enable the tool on its own.
+func SetShellPolicy(confirm, yes bool) {
+	shellConfirm = confirm
+	shellYes = yes
+}
+
+func shellEnabled() bool {
+	return shellConfirm
+}
+
+func stdinIsTerminal() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// BuiltinShell creates a plugin wrapper for the built-in shell tool.
+func BuiltinShell() *Tool {
+	return &Tool{
+		Name:        ShellToolName,
+		Description: "Run a shell command with sh -c and return its stdout, stderr, and exit code",
+		Type:        "builtin",
+		InputSchema: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"command": map[string]string{"type": "string", "description": "Shell command line to run"},
+			},
+			"required": []string{"command"},
+		},
+	}
+}
+
+// ExecuteShell runs the built-in shell tool after the configured approval.
+func ExecuteShell(ctx context.Context, input []byte) Result {
+	var req struct {
+		Command string `json:"command"`
+	}
+	if err := json.Unmarshal(input, &req); err != nil {
+		return Result{OK: false, Error: err.Error()}
+	}
+	if strings.TrimSpace(req.Command) == "" {
+		return Result{OK: false, Error: "command is required"}
+	}
+	if err := approveShell(req.Command); err != nil {
+		return Result{OK: false, Error: err.Error()}
+	}
+	return runCommand(ctx, "sh", []string{"-c", req.Command}, shellTimeout)
+}
+
+// approveShell applies the shell policy to command.
+func approveShell(command string) error {
+	if !shellConfirm {
+		return fmt.Errorf("shell tool is disabled")
+	}
+	if shellYes {
+		return nil
+	}
+	if !shellInteractive() {
+		return fmt.Errorf("shell command refused: no terminal to confirm on (use --yes to allow without confirmation)")
+	}
+	if !askTerminal(fmt.Sprintf("run shell command?\n  %s", command)) {
+		return fmt.Errorf("shell command declined by user")
+	}
+	return nil
+}
+
+// askTerminal shows question on the confirmation terminal and reports
+// whether the answer was yes.
+func askTerminal(question string) bool {
+	fmt.Fprintf(shellOut, "\n%s\n[y/N] ", question)
+	answer, _ := bufio.NewReader(shellIn).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
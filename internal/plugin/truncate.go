@@ -0,0 +1,54 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// DefaultMaxOutputBytes caps a tool result sent back to the model when
+// plugins.json does not set max_tool_output_bytes.
+const DefaultMaxOutputBytes = 100 * 1024
+
+// SetMaxOutputBytes sets the result size cap for tools run through r.
+// Zero restores the default; a negative value disables truncation.
+func (r *Registry) SetMaxOutputBytes(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maxOutputBytes = n
+}
+
+func (r *Registry) outputLimit() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.maxOutputBytes == 0 {
+		return DefaultMaxOutputBytes
+	}
+	return r.maxOutputBytes
+}
+
+// truncateResult shortens res.Data to at most max bytes. String data is cut
+// directly; anything else is measured and cut as its JSON encoding, so the
+// truncated Data becomes a string.
+func truncateResult(res Result, max int) Result {
+	if max < 0 || res.Data == nil {
+		return res
+	}
+	s, ok := res.Data.(string)
+	if !ok {
+		b, err := json.Marshal(res.Data)
+		if err != nil || len(b) <= max {
+			return res
+		}
+		s = string(b)
+	}
+	if len(s) <= max {
+		return res
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	res.Data = s[:cut] + fmt.Sprintf("...[truncated %d bytes]", len(s)-cut)
+	return res
+}
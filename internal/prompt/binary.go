@@ -0,0 +1,40 @@
+package prompt
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+// binarySample is how much of the input looksBinary inspects.
+const binarySample = 8 << 10
+
+// allowBinary disables the binary input check in Read.
+var allowBinary bool
+
+// SetAllowBinary lets stdin that looks binary through as the prompt.
+func SetAllowBinary(enabled bool) {
+	allowBinary = enabled
+}
+
+// errBinary is returned for stdin that looksBinary rejects.
+var errBinary = errors.New("stdin looks like binary data, not text; pass --allow-binary to send it anyway")
+
+// looksBinary reports whether more than a tenth of the first binarySample
+// bytes of b are NUL or not valid UTF-8.
+func looksBinary(b []byte) bool {
+	if len(b) > binarySample {
+		b = b[:binarySample]
+	}
+	bad := 0
+	for i := 0; i < len(b); {
+		r, size := utf8.DecodeRune(b[i:])
+		// A rune cut off by the sample boundary is not evidence of binary.
+		if r == utf8.RuneError && size == 1 && !(len(b) == binarySample && len(b)-i < utf8.UTFMax) {
+			bad++
+		} else if r == 0 {
+			bad++
+		}
+		i += size
+	}
+	return bad*10 > len(b)
+}
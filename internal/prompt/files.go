@@ -0,0 +1,32 @@
+package prompt
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ReadFiles reads each path and concatenates the contents, each under an
+// "=== path ===" header so the model can tell where one file ends and the
+// next begins. Files that look binary are rejected like binary stdin.
+func ReadFiles(paths []string) (string, error) {
+	var b strings.Builder
+	for i, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read file: %w", err)
+		}
+		if !allowBinary && looksBinary(data) {
+			return "", fmt.Errorf("%s looks like binary data, not text; pass --allow-binary to send it anyway", path)
+		}
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "=== %s ===\n", path)
+		b.Write(data)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			b.WriteString("\n")
+		}
+	}
+	return b.String(), nil
+}
@@ -6,6 +6,10 @@ import (
 	"os"
 )
 
+// ErrNoPrompt is returned by ReadWithFile when no inline prompt or prompt
+// file was given and stdin is a terminal.
+var ErrNoPrompt = errors.New("no prompt provided")
+
 // HasStdin returns true if stdin has piped input available.
 func HasStdin() bool {
 	stat, err := os.Stdin.Stat()
@@ -15,6 +19,18 @@ func HasStdin() bool {
 	return stat.Mode()&os.ModeCharDevice == 0
 }
 
+// stdinIsData reports whether stdin is a pipe or regular file, the only
+// kinds of input worth combining with an inline prompt. Sockets and other
+// non-terminal stdins left open by cron, systemd or CI are ignored so that
+// reading them to EOF cannot hang the run.
+func stdinIsData() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeNamedPipe != 0 || stat.Mode().IsRegular()
+}
+
 func Read(inline string) (string, error) {
 	if inline != "" {
 		return inline, nil
@@ -29,7 +45,7 @@ func Read(inline string) (string, error) {
 		return "", nil
 	}
 
-	b, err := io.ReadAll(os.Stdin)
+	b, err := readStdin()
 	if err != nil {
 		return "", err
 	}
@@ -40,3 +56,63 @@ func Read(inline string) (string, error) {
 
 	return string(b), nil
 }
+
+// readStdin reads all of stdin, rejecting input that looks binary unless
+// SetAllowBinary was called.
+func readStdin() ([]byte, error) {
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	if !allowBinary && looksBinary(b) {
+		return nil, errBinary
+	}
+	return b, nil
+}
+
+// ReadWithFile resolves the prompt from, in order of precedence, the inline
+// text, the file at path, and piped stdin. Inline text and stdin that is a
+// pipe or regular file are combined as "<inline>\n\n<stdin>", or the other way round with
+// stdinFirst, so `cat file | gogo -p "review this"` sends both.
+func ReadWithFile(inline, path string, stdinFirst bool) (string, error) {
+	if inline != "" {
+		if !stdinIsData() {
+			return inline, nil
+		}
+		b, err := readStdin()
+		if err != nil {
+			return "", err
+		}
+		return Combine(inline, string(b), stdinFirst), nil
+	}
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		if len(b) == 0 {
+			return "", errors.New("prompt file is empty: " + path)
+		}
+		return string(b), nil
+	}
+	if !HasStdin() {
+		return "", ErrNoPrompt
+	}
+	return Read("")
+}
+
+// Combine joins an inline prompt with piped input, separated by a blank
+// line. Empty input leaves the prompt unchanged, and an empty prompt
+// leaves just the input.
+func Combine(inline, input string, inputFirst bool) string {
+	if input == "" {
+		return inline
+	}
+	if inline == "" {
+		return input
+	}
+	if inputFirst {
+		return input + "\n\n" + inline
+	}
+	return inline + "\n\n" + input
+}
@@ -2,6 +2,8 @@ package prompt
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -72,3 +74,204 @@ func TestNonTTYNoData(t *testing.T) {
 		t.Fatalf("expected error on empty stdin")
 	}
 }
+
+func pipeStdin(t *testing.T, data string) {
+	t.Helper()
+	orig := os.Stdin
+	t.Cleanup(func() { os.Stdin = orig })
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("pipe error: %v", err)
+	}
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatalf("write error: %v", err)
+	}
+	_ = w.Close()
+	os.Stdin = r
+}
+
+func TestPromptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prompt.txt")
+	if err := os.WriteFile(path, []byte("from-file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pipeStdin(t, "from-stdin")
+
+	got, err := ReadWithFile("", path, false)
+	if err != nil {
+		t.Fatalf("ReadWithFile returned error: %v", err)
+	}
+	if got != "from-file" {
+		t.Fatalf("file should take precedence over stdin, got %q", got)
+	}
+
+	got, err = ReadWithFile("inline", path, false)
+	if err != nil {
+		t.Fatalf("ReadWithFile returned error: %v", err)
+	}
+	if got != "inline\n\nfrom-stdin" {
+		t.Fatalf("inline should combine with stdin and ignore the file, got %q", got)
+	}
+}
+
+func TestInlineCombinesWithStdin(t *testing.T) {
+	pipeStdin(t, "package main\n")
+	got, err := ReadWithFile("review this", "", false)
+	if err != nil {
+		t.Fatalf("ReadWithFile returned error: %v", err)
+	}
+	if got != "review this\n\npackage main\n" {
+		t.Fatalf("unexpected prompt: %q", got)
+	}
+
+	pipeStdin(t, "package main\n")
+	got, err = ReadWithFile("review this", "", true)
+	if err != nil {
+		t.Fatalf("ReadWithFile returned error: %v", err)
+	}
+	if got != "package main\n\n\nreview this" {
+		t.Fatalf("unexpected prompt with stdin first: %q", got)
+	}
+
+	pipeStdin(t, "")
+	got, err = ReadWithFile("review this", "", false)
+	if err != nil || got != "review this" {
+		t.Fatalf("empty stdin should leave the prompt alone, got %q, %v", got, err)
+	}
+}
+
+func TestInlineIgnoresNonDataStdin(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "input.txt")
+	if err := os.WriteFile(path, []byte("from-file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdin
+	t.Cleanup(func() { os.Stdin = orig })
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	os.Stdin = f
+	got, err := ReadWithFile("review this", "", false)
+	if err != nil || got != "review this\n\nfrom-file" {
+		t.Fatalf("regular file stdin should combine, got %q, %v", got, err)
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	os.Stdin = d
+	got, err = ReadWithFile("review this", "", false)
+	if err != nil || got != "review this" {
+		t.Fatalf("non-pipe stdin should be ignored, got %q, %v", got, err)
+	}
+}
+
+func TestCombine(t *testing.T) {
+	tests := []struct {
+		inline, input string
+		first         bool
+		want          string
+	}{
+		{"review", "code", false, "review\n\ncode"},
+		{"review", "code", true, "code\n\nreview"},
+		{"review", "", false, "review"},
+		{"", "=== a.go ===\ncode", false, "=== a.go ===\ncode"},
+	}
+	for _, tc := range tests {
+		if got := Combine(tc.inline, tc.input, tc.first); got != tc.want {
+			t.Errorf("Combine(%q, %q, %v) = %q, want %q", tc.inline, tc.input, tc.first, got, tc.want)
+		}
+	}
+}
+
+func TestPromptFileFallsBackToStdin(t *testing.T) {
+	pipeStdin(t, "from-stdin")
+
+	got, err := ReadWithFile("", "", false)
+	if err != nil {
+		t.Fatalf("ReadWithFile returned error: %v", err)
+	}
+	if got != "from-stdin" {
+		t.Fatalf("unexpected prompt: %q", got)
+	}
+}
+
+func TestPromptFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := ReadWithFile("", filepath.Join(dir, "missing.txt"), false); err == nil {
+		t.Fatal("expected error for missing prompt file")
+	}
+
+	empty := filepath.Join(dir, "empty.txt")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadWithFile("", empty, false); err == nil {
+		t.Fatal("expected error for empty prompt file")
+	}
+}
+
+func TestBinaryStdin(t *testing.T) {
+	pipeStdin(t, "PK\x03\x04\x00\x00\x00\x00\x08\x00\x00\x00\x00\x00")
+	_, err := Read("")
+	if err == nil || !strings.Contains(err.Error(), "--allow-binary") {
+		t.Fatalf("expected binary input error, got %v", err)
+	}
+
+	pipeStdin(t, "\x00\x00\x00")
+	if _, err := ReadWithFile("describe this", "", false); err == nil {
+		t.Fatal("expected binary input error with -p")
+	}
+
+	SetAllowBinary(true)
+	t.Cleanup(func() { SetAllowBinary(false) })
+	pipeStdin(t, "\x00\x00\x00")
+	if got, err := Read(""); err != nil || got != "\x00\x00\x00" {
+		t.Fatalf("expected binary input with SetAllowBinary, got %q, %v", got, err)
+	}
+}
+
+func TestLooksBinary(t *testing.T) {
+	for _, text := range []string{"hello\n", "héllo wörld ✓ 日本語", strings.Repeat("é", binarySample)} {
+		if looksBinary([]byte(text)) {
+			t.Errorf("text reported as binary: %.20q", text)
+		}
+	}
+	for _, bin := range []string{"\x00", "\xff\xfe\xfd\xfc", "abc\x00\x00def"} {
+		if !looksBinary([]byte(bin)) {
+			t.Errorf("binary not detected: %q", bin)
+		}
+	}
+}
+
+func TestReadFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.go")
+	b := filepath.Join(dir, "b.go")
+	if err := os.WriteFile(a, []byte("package a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("package b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadFiles([]string{a, b})
+	if err != nil {
+		t.Fatalf("ReadFiles returned error: %v", err)
+	}
+	want := "=== " + a + " ===\npackage a\n\n=== " + b + " ===\npackage b\n"
+	if got != want {
+		t.Fatalf("unexpected concatenation:\n got %q\nwant %q", got, want)
+	}
+
+	if _, err := ReadFiles([]string{a, filepath.Join(dir, "missing.go")}); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+}
@@ -0,0 +1,44 @@
+package prompt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultSummaryStyle is used when --summarize is given without a style.
+const DefaultSummaryStyle = "bullets"
+
+var summaryPrompts = map[string]string{
+	"bullets": "You are a precise summarizer. Summarize the user's input as a concise bulleted list of the key points, " +
+		"most important first. Use one short sentence per bullet. Do not add information that is not in the input, " +
+		"and do not include a preamble or closing remarks.",
+	"tldr": "You are a precise summarizer. Summarize the user's input in one to three plain sentences that capture " +
+		"its main point. Do not add information that is not in the input, and do not include a preamble.",
+	"detailed": "You are a careful summarizer. Write a detailed summary of the user's input organized into short " +
+		"sections with headings. Cover the main argument, supporting points, notable figures or data, and any " +
+		"conclusions or open questions. Preserve the original meaning, do not add information that is not in the " +
+		"input, and do not include a preamble.",
+}
+
+// SummaryPrompt returns the built-in system prompt for a summarization style.
+func SummaryPrompt(style string) (string, error) {
+	if style == "" {
+		style = DefaultSummaryStyle
+	}
+	p, ok := summaryPrompts[strings.ToLower(style)]
+	if !ok {
+		return "", fmt.Errorf("unknown summary style %q (valid: %s)", style, strings.Join(SummaryStyles(), ", "))
+	}
+	return p, nil
+}
+
+// SummaryStyles returns the supported summarization styles in sorted order.
+func SummaryStyles() []string {
+	styles := make([]string, 0, len(summaryPrompts))
+	for s := range summaryPrompts {
+		styles = append(styles, s)
+	}
+	sort.Strings(styles)
+	return styles
+}
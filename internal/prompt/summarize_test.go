@@ -0,0 +1,41 @@
+package prompt
+
+import "testing"
+
+func TestSummaryPrompt(t *testing.T) {
+	for _, style := range []string{"bullets", "tldr", "detailed"} {
+		got, err := SummaryPrompt(style)
+		if err != nil {
+			t.Fatalf("SummaryPrompt(%q) returned error: %v", style, err)
+		}
+		if got != summaryPrompts[style] {
+			t.Fatalf("SummaryPrompt(%q) returned wrong prompt: %q", style, got)
+		}
+	}
+}
+
+func TestSummaryPromptDefault(t *testing.T) {
+	got, err := SummaryPrompt("")
+	if err != nil {
+		t.Fatalf("SummaryPrompt returned error: %v", err)
+	}
+	if got != summaryPrompts[DefaultSummaryStyle] {
+		t.Fatalf("expected default style prompt, got %q", got)
+	}
+}
+
+func TestSummaryPromptCaseInsensitive(t *testing.T) {
+	got, err := SummaryPrompt("TLDR")
+	if err != nil {
+		t.Fatalf("SummaryPrompt returned error: %v", err)
+	}
+	if got != summaryPrompts["tldr"] {
+		t.Fatalf("unexpected prompt: %q", got)
+	}
+}
+
+func TestSummaryPromptUnknown(t *testing.T) {
+	if _, err := SummaryPrompt("haiku"); err == nil {
+		t.Fatal("expected error for unknown style")
+	}
+}
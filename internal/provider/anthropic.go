@@ -5,32 +5,54 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"gogo/internal/config"
+	"gogo/internal/history"
 	"gogo/internal/plugin"
 	"gogo/internal/stream"
 )
 
-const anthropicURL = "https://api.anthropic.com/v1/messages"
+var anthropicURL = "https://api.anthropic.com/v1/messages"
+
 const anthropicVersion = "2023-06-01"
 
+// anthropicCacheBeta is the anthropic-beta value enabling prompt caching.
+const anthropicCacheBeta = "prompt-caching-2024-07-31"
+
 type anthropicRequest struct {
 	Model       string                   `json:"model"`
 	MaxTokens   int                      `json:"max_tokens,omitempty"`
-	Temperature float64                  `json:"temperature,omitempty"`
+	Temperature *float64                 `json:"temperature,omitempty"`
+	TopP        float64                  `json:"top_p,omitempty"`
+	TopK        int                      `json:"top_k,omitempty"`
+	Stop        []string                 `json:"stop_sequences,omitempty"`
 	Stream      bool                     `json:"stream"`
 	Messages    []map[string]interface{} `json:"messages"`
 	Tools       []map[string]interface{} `json:"tools,omitempty"`
-	System      string                   `json:"system,omitempty"`
+	System      interface{}              `json:"system,omitempty"`
 }
 
 type anthropicEvent struct {
 	Type         string          `json:"type"`
 	Delta        json.RawMessage `json:"delta"`
 	ContentBlock json.RawMessage `json:"content_block"`
+	Message      struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Usage anthropicUsage `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
 }
 
 type anthropicContentBlock struct {
@@ -40,6 +62,13 @@ type anthropicContentBlock struct {
 	Input json.RawMessage `json:"input"`
 }
 
+// anthropicMessage is the body of a non-streaming Messages API call.
+type anthropicMessage struct {
+	Content    []anthropicTextDelta `json:"content"`
+	StopReason string               `json:"stop_reason"`
+	Usage      anthropicUsage       `json:"usage"`
+}
+
 type anthropicTextDelta struct {
 	Type string `json:"type"`
 	Text string `json:"text"`
@@ -56,108 +85,299 @@ type toolUse struct {
 	Input string
 }
 
-func streamAnthropic(ctx context.Context, cfg config.Config, prompt string, out io.Writer, stderr io.Writer, tools *plugin.Registry) error {
-	key, err := apiKey("ANTHROPIC_API_KEY")
+func streamAnthropic(ctx context.Context, cfg config.Config, hist []history.Message, prompt string, out io.Writer, stderr io.Writer, tools *plugin.Registry) error {
+	key, err := apiKey(cfg, "ANTHROPIC_API_KEY")
 	if err != nil {
 		return err
 	}
 
-	messages := []map[string]interface{}{
-		{
-			"role": "user",
-			"content": []map[string]string{
-				{"type": "text", "text": prompt},
-			},
-		},
+	docs, err := loadDocuments(cfg.Provider, cfg.Model, cfg.Docs)
+	if err != nil {
+		return err
+	}
+	content := make([]map[string]interface{}, 0, len(docs)+1)
+	for _, d := range docs {
+		content = append(content, anthropicDocumentBlock(d))
 	}
+	content = append(content, map[string]interface{}{"type": "text", "text": prompt})
+
+	messages := anthropicHistory(hist)
+	messages = append(messages, map[string]interface{}{
+		"role":    "user",
+		"content": content,
+	})
 
 	return anthropicStreamLoop(ctx, cfg, key, messages, out, stderr, tools)
 }
 
+// anthropicHistory converts neutral history into Messages API turns.
+func anthropicHistory(hist []history.Message) []map[string]interface{} {
+	messages := make([]map[string]interface{}, 0, len(hist)+1)
+	for _, m := range hist {
+		switch {
+		case m.Role == "tool":
+			result := map[string]interface{}{
+				"type":        "tool_result",
+				"tool_use_id": m.ToolCallID,
+				"content":     []map[string]string{{"type": "text", "text": m.Content}},
+			}
+			// Results of one assistant turn share a single user turn.
+			if n := len(messages); n > 0 {
+				if blocks, ok := messages[n-1]["content"].([]map[string]interface{}); ok && messages[n-1]["role"] == "user" {
+					messages[n-1]["content"] = append(blocks, result)
+					continue
+				}
+			}
+			messages = append(messages, map[string]interface{}{
+				"role":    "user",
+				"content": []map[string]interface{}{result},
+			})
+		case len(m.ToolCalls) > 0:
+			uses := make([]toolUse, 0, len(m.ToolCalls))
+			for _, c := range m.ToolCalls {
+				uses = append(uses, toolUse{ID: c.ID, Name: c.Name, Input: toolInput(c.Input)})
+			}
+			msg := anthropicToolUseMessage(uses)
+			if m.Content != "" {
+				text := map[string]interface{}{"type": "text", "text": m.Content}
+				msg["content"] = append([]map[string]interface{}{text}, msg["content"].([]map[string]interface{})...)
+			}
+			messages = append(messages, msg)
+		default:
+			messages = append(messages, map[string]interface{}{
+				"role": m.Role,
+				"content": []map[string]string{
+					{"type": "text", "text": m.Content},
+				},
+			})
+		}
+	}
+	return messages
+}
+
 func anthropicStreamLoop(ctx context.Context, cfg config.Config, key string, messages []map[string]interface{}, out io.Writer, stderr io.Writer, tools *plugin.Registry) error {
-	toolUses, err := anthropicStreamOnce(ctx, cfg, key, messages, out, tools)
+	var toolUses []toolUse
+	err := retryEmpty(ctx, cfg, out, stderr, func() (n int, finished bool, err error) {
+		toolUses, finished, err = anthropicStreamOnce(ctx, cfg, key, messages, out, stderr, tools)
+		return len(toolUses), finished, err
+	})
 	if err != nil {
 		return err
 	}
-	if len(toolUses) == 0 {
-		return nil
+
+	next := append([]map[string]interface{}{}, messages...)
+	for round := 0; len(toolUses) > 0; round++ {
+		if round == maxToolRounds(cfg) {
+			noteToolRounds(stderr, round)
+			return nil
+		}
+		toolResults, err := anthropicRunTools(ctx, cfg, toolUses, out, stderr, tools)
+		if err != nil {
+			return err
+		}
+		if len(toolResults) == 0 {
+			return nil
+		}
+		next = append(next, anthropicToolUseMessage(toolUses), map[string]interface{}{
+			"role":    "user",
+			"content": toolResults,
+		})
+		toolUses, _, err = anthropicStreamOnce(ctx, roundConfig(cfg, round), key, next, out, stderr, tools)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// anthropicRunTools executes the registered tools among toolUses and
+// returns their tool_result blocks.
+func anthropicRunTools(ctx context.Context, cfg config.Config, toolUses []toolUse, out io.Writer, stderr io.Writer, tools *plugin.Registry) ([]map[string]interface{}, error) {
 	toolResults := make([]map[string]interface{}, 0, len(toolUses))
 	for _, use := range toolUses {
 		// Check if the tool exists in the registry
 		if _, ok := tools.Get(use.Name); !ok {
 			continue
 		}
-		res := tools.ExecuteTool(use.Name, []byte(use.Input))
-		logToolResult(stderr, "anthropic", use.Name, use.Input, res)
+		if err := emitEvent(out, Event{Type: "tool_call", Tool: use.Name, Input: rawJSON(use.Input)}); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		res := tools.ExecuteTool(ctx, use.Name, []byte(use.Input))
+		if cfg.Debug {
+			logToolResult(stderr, cfg.LogFormat, "anthropic", use.Name, use.Input, res, time.Since(start))
+		}
+		if err := emitEvent(out, Event{Type: "tool_result", Tool: use.Name, Result: &res}); err != nil {
+			return nil, err
+		}
+		if err := strictToolError(cfg, use.Name, res); err != nil {
+			return nil, err
+		}
 		toolResults = append(toolResults, map[string]interface{}{
 			"type":        "tool_result",
 			"tool_use_id": use.ID,
 			"content":     []map[string]string{{"type": "text", "text": res.ToJSON()}},
 		})
 	}
+	return toolResults, nil
+}
 
-	if len(toolResults) == 0 {
+// anthropicToolUseMessage rebuilds the assistant turn that requested
+// toolUses; each tool_result must follow the tool_use it answers.
+func anthropicToolUseMessage(toolUses []toolUse) map[string]interface{} {
+	content := make([]map[string]interface{}, 0, len(toolUses))
+	for _, use := range toolUses {
+		input := json.RawMessage(use.Input)
+		if !json.Valid(input) {
+			input = json.RawMessage("{}")
+		}
+		content = append(content, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    use.ID,
+			"name":  use.Name,
+			"input": input,
+		})
+	}
+	return map[string]interface{}{"role": "assistant", "content": content}
+}
+
+// anthropicSystem returns the system field: the plain instruction, or with
+// CacheSystem a text block marked for prompt caching so repeated calls with
+// the same long system prompt are billed at the cached rate.
+func anthropicSystem(cfg config.Config, instruction string) interface{} {
+	if instruction == "" {
 		return nil
 	}
+	if !cfg.CacheSystem {
+		return instruction
+	}
+	return []map[string]interface{}{{
+		"type":          "text",
+		"text":          instruction,
+		"cache_control": map[string]string{"type": "ephemeral"},
+	}}
+}
 
-	next := append([]map[string]interface{}{}, messages...)
-	next = append(next, map[string]interface{}{
-		"role":    "user",
-		"content": toolResults,
-	})
-	_, err = anthropicStreamOnce(ctx, cfg, key, next, out, tools)
-	return err
+// anthropicReadMessage writes the text of a non-streaming response to out
+// and reports whether it carried a stop_reason. A response cut off at
+// max_tokens is written and then reported as ErrIncomplete.
+func anthropicReadMessage(cfg config.Config, body io.Reader, out io.Writer) (bool, error) {
+	var msg anthropicMessage
+	if err := json.NewDecoder(body).Decode(&msg); err != nil {
+		return false, fmt.Errorf("decode anthropic response: %w", err)
+	}
+	used := Usage{InputTokens: msg.Usage.InputTokens, OutputTokens: msg.Usage.OutputTokens}
+	cfg.Budget.AddUsage(cfg.Model, used.InputTokens, used.OutputTokens)
+	recordUsage(out, used)
+	for _, block := range msg.Content {
+		if block.Type != "text" {
+			continue
+		}
+		if _, err := io.WriteString(out, block.Text); err != nil {
+			return false, err
+		}
+	}
+	if msg.StopReason == "max_tokens" {
+		return true, fmt.Errorf("%w: anthropic hit the output token limit (stop_reason max_tokens)", ErrIncomplete)
+	}
+	return msg.StopReason != "", nil
 }
 
-func anthropicStreamOnce(ctx context.Context, cfg config.Config, key string, messages []map[string]interface{}, out io.Writer, tools *plugin.Registry) ([]toolUse, error) {
+func anthropicStreamOnce(ctx context.Context, cfg config.Config, key string, messages []map[string]interface{}, out io.Writer, stderr io.Writer, tools *plugin.Registry) ([]toolUse, bool, error) {
+	ctx, cancel := requestContext(ctx, cfg)
+	defer cancel()
+	beginTurn(out)
+
 	reqBody := anthropicRequest{
 		Model:       cfg.Model,
 		MaxTokens:   cfg.MaxTokens,
-		Temperature: cfg.Temperature,
-		Stream:      true,
+		Temperature: requestTemperature(cfg),
+		TopP:        cfg.TopP,
+		TopK:        cfg.TopK,
+		Stop:        cfg.Stop,
+		Stream:      !cfg.NoStream,
 		Messages:    messages,
 	}
-	reqBody.System = tools.GenerateInstruction()
+	reqBody.System = anthropicSystem(cfg, systemInstruction(cfg, tools))
 	reqBody.Tools = tools.FormatAnthropicTools()
+	if cfg.DumpMessages {
+		dumpMessages(stderr, map[string]interface{}{
+			"system":   reqBody.System,
+			"messages": reqBody.Messages,
+		})
+	}
 
-	b, err := json.Marshal(reqBody)
+	b, err := marshalRequest(cfg, reqBody)
 	if err != nil {
-		return nil, err
+		return nil, false, err
+	}
+
+	if err := cfg.Budget.BeginCall(); err != nil {
+		return nil, false, err
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, anthropicURL, bytes.NewReader(b))
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	req.Header.Set("x-api-key", key)
 	req.Header.Set("anthropic-version", anthropicVersion)
+	if cfg.CacheSystem {
+		req.Header.Set("anthropic-beta", anthropicCacheBeta)
+	}
 	req.Header.Set("content-type", "application/json")
+	setHeaders(req, cfg)
 
 	httpClient := &http.Client{Timeout: 0}
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, false, networkError(ctx, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, errors.New(string(body))
+	reqID, err := checkResponse(cfg, resp, stderr)
+	if err != nil {
+		return nil, false, err
+	}
+	if cfg.NoStream {
+		finished, err := anthropicReadMessage(cfg, resp.Body, out)
+		return nil, finished, withRequestID(networkError(ctx, err), reqID)
 	}
 
 	writer := bufio.NewWriter(out)
 	toolUses := map[string]*toolUse{}
 	var activeToolID string
+	var used Usage
+	var stopReason string
 
-	err = stream.ReadEvents(resp.Body, func(data string) error {
+	err = stream.ReadEventsIdle(resp.Body, cfg.IdleTimeout, func(data string) error {
 		var event anthropicEvent
 		if err := json.Unmarshal([]byte(data), &event); err != nil {
 			return err
 		}
 
 		switch event.Type {
+		case "error":
+			if e := event.Error; e != nil {
+				return &ProviderError{Kind: codeKind(e.Type), Message: fmt.Sprintf("anthropic stream error: %s (%s)", e.Message, e.Type)}
+			}
+		case "message_start":
+			used.InputTokens = event.Message.Usage.InputTokens
+			used.OutputTokens = event.Message.Usage.OutputTokens
+		case "message_delta":
+			// output_tokens in message_delta is cumulative
+			used.OutputTokens = event.Usage.OutputTokens
+			var delta struct {
+				StopReason string `json:"stop_reason"`
+			}
+			if len(event.Delta) > 0 {
+				if err := json.Unmarshal(event.Delta, &delta); err != nil {
+					return err
+				}
+			}
+			if delta.StopReason != "" {
+				stopReason = delta.StopReason
+			}
 		case "content_block_start":
 			var block anthropicContentBlock
 			if err := json.Unmarshal(event.ContentBlock, &block); err != nil {
@@ -203,14 +423,19 @@ func anthropicStreamOnce(ctx context.Context, cfg config.Config, key string, mes
 		return nil
 	})
 	if err != nil {
-		return nil, err
+		return nil, false, withRequestID(networkError(ctx, err), reqID)
+	}
+	cfg.Budget.AddUsage(cfg.Model, used.InputTokens, used.OutputTokens)
+	recordUsage(out, used)
+	if stopReason == "max_tokens" {
+		return nil, true, fmt.Errorf("%w: anthropic hit the output token limit (stop_reason max_tokens)", ErrIncomplete)
 	}
 
 	uses := make([]toolUse, 0, len(toolUses))
 	for _, use := range toolUses {
 		uses = append(uses, *use)
 	}
-	return uses, nil
+	return uses, stopReason != "", nil
 }
 
 func toJSON(v any) string {
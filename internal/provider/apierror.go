@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// apiErrorBody covers the error JSON of every supported provider:
+//
+//	openai:    {"error":{"message":..., "type":..., "code":...}}
+//	anthropic: {"type":"error","error":{"type":..., "message":...}}
+//	gemini:    {"error":{"code":404, "message":..., "status":...}}
+//
+// Gemini's streaming endpoint wraps the object in a one-element array.
+type apiErrorBody struct {
+	Error struct {
+		Message string          `json:"message"`
+		Type    string          `json:"type"`
+		Status  string          `json:"status"`
+		Code    json.RawMessage `json:"code"`
+	} `json:"error"`
+}
+
+// apiErrorMessage turns a non-2xx response body into a concise message
+// such as "openai error (invalid_request_error): model not found". The raw
+// body is returned when it is not a recognised error object.
+func apiErrorMessage(provider string, body []byte) string {
+	raw := strings.TrimSpace(string(body))
+	trimmed := bytes.TrimSpace(body)
+
+	var parsed apiErrorBody
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var list []apiErrorBody
+		if err := json.Unmarshal(trimmed, &list); err != nil || len(list) == 0 {
+			return raw
+		}
+		parsed = list[0]
+	} else if err := json.Unmarshal(trimmed, &parsed); err != nil {
+		return raw
+	}
+	if parsed.Error.Message == "" {
+		return raw
+	}
+
+	var kind string
+	switch provider {
+	case "gemini":
+		kind = parsed.Error.Status
+	default:
+		kind = parsed.Error.Type
+		if kind == "" {
+			// Some OpenAI-compatible servers only set a string code.
+			var code string
+			if json.Unmarshal(parsed.Error.Code, &code) == nil {
+				kind = code
+			}
+		}
+	}
+	if kind == "" {
+		return fmt.Sprintf("%s error: %s", provider, parsed.Error.Message)
+	}
+	return fmt.Sprintf("%s error (%s): %s", provider, kind, parsed.Error.Message)
+}
@@ -0,0 +1,49 @@
+package provider
+
+import "testing"
+
+func TestAPIErrorMessage(t *testing.T) {
+	tests := []struct {
+		provider string
+		body     string
+		want     string
+	}{
+		{
+			"openai",
+			`{"error":{"message":"model not found","type":"invalid_request_error","param":null,"code":"model_not_found"}}`,
+			"openai error (invalid_request_error): model not found",
+		},
+		{
+			"anthropic",
+			`{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`,
+			"anthropic error (authentication_error): invalid x-api-key",
+		},
+		{
+			"gemini",
+			`{"error":{"code":400,"message":"API key not valid.","status":"INVALID_ARGUMENT"}}`,
+			"gemini error (INVALID_ARGUMENT): API key not valid.",
+		},
+		{
+			"gemini",
+			`[{"error":{"code":404,"message":"models/x is not found","status":"NOT_FOUND"}}]`,
+			"gemini error (NOT_FOUND): models/x is not found",
+		},
+		{
+			"openai-compatible",
+			`{"error":{"message":"rate limited","code":"rate_limit_exceeded"}}`,
+			"openai-compatible error (rate_limit_exceeded): rate limited",
+		},
+		{
+			"openai-compatible",
+			`{"error":{"message":"upstream failed"}}`,
+			"openai-compatible error: upstream failed",
+		},
+		{"openai", "<html>502 Bad Gateway</html>\n", "<html>502 Bad Gateway</html>"},
+		{"anthropic", `{"detail":"not an error object"}`, `{"detail":"not an error object"}`},
+	}
+	for _, tc := range tests {
+		if got := apiErrorMessage(tc.provider, []byte(tc.body)); got != tc.want {
+			t.Errorf("apiErrorMessage(%q, %s) = %q, want %q", tc.provider, tc.body, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package provider
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Per-provider limits on the size of a single attached document.
+const (
+	anthropicMaxDocBytes = 32 << 20
+	geminiMaxDocBytes    = 20 << 20
+)
+
+type document struct {
+	Path      string
+	MediaType string
+	Data      []byte
+}
+
+// loadDocuments reads the --doc attachments and checks that the provider and
+// model can accept them.
+func loadDocuments(provider, model string, paths []string) ([]document, error) {
+	if len(paths) == 0 {
+		return nil, nil
+	}
+
+	var limit int64
+	switch provider {
+	case "anthropic":
+		// Claude 3 (pre-3.5) models have no document support.
+		if strings.HasPrefix(model, "claude-3-") && !strings.HasPrefix(model, "claude-3-5") && !strings.HasPrefix(model, "claude-3-7") {
+			return nil, fmt.Errorf("model %s does not support document inputs", model)
+		}
+		limit = anthropicMaxDocBytes
+	case "gemini":
+		if strings.HasPrefix(model, "gemini-1.0") || model == "gemini-pro" {
+			return nil, fmt.Errorf("model %s does not support document inputs", model)
+		}
+		limit = geminiMaxDocBytes
+	default:
+		return nil, fmt.Errorf("provider %s does not support document inputs (use anthropic or gemini)", provider)
+	}
+
+	docs := make([]document, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() > limit {
+			return nil, fmt.Errorf("document %s is %d bytes, exceeding the %s limit of %d bytes", path, info.Size(), provider, limit)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		mediaType, err := documentMediaType(path, b)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, document{Path: path, MediaType: mediaType, Data: b})
+	}
+	return docs, nil
+}
+
+// documentMediaType returns application/pdf or text/plain, the two document
+// types both providers accept.
+func documentMediaType(path string, b []byte) (string, error) {
+	if strings.EqualFold(filepath.Ext(path), ".pdf") || strings.HasPrefix(string(b), "%PDF-") {
+		return "application/pdf", nil
+	}
+	if strings.HasPrefix(http.DetectContentType(b), "text/") {
+		return "text/plain", nil
+	}
+	return "", fmt.Errorf("document %s is not a PDF or text file", path)
+}
+
+func anthropicDocumentBlock(d document) map[string]interface{} {
+	source := map[string]interface{}{"media_type": d.MediaType}
+	if d.MediaType == "text/plain" {
+		source["type"] = "text"
+		source["data"] = string(d.Data)
+	} else {
+		source["type"] = "base64"
+		source["data"] = base64.StdEncoding.EncodeToString(d.Data)
+	}
+	return map[string]interface{}{
+		"type":   "document",
+		"source": source,
+		"title":  filepath.Base(d.Path),
+	}
+}
+
+func geminiDocumentPart(d document) geminiPart {
+	return geminiPart{InlineData: &geminiInlineData{
+		MimeType: d.MediaType,
+		Data:     base64.StdEncoding.EncodeToString(d.Data),
+	}}
+}
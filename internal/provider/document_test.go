@@ -0,0 +1,97 @@
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const smallPDF = "%PDF-1.4\n1 0 obj<<>>endobj\ntrailer<<>>\n%%EOF\n"
+
+func writeDoc(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAnthropicDocumentBlock(t *testing.T) {
+	path := writeDoc(t, "report.pdf", smallPDF)
+	docs, err := loadDocuments("anthropic", "claude-3-5-sonnet-latest", []string{path})
+	if err != nil {
+		t.Fatalf("loadDocuments returned error: %v", err)
+	}
+
+	b, _ := json.Marshal(anthropicDocumentBlock(docs[0]))
+	var block struct {
+		Type   string `json:"type"`
+		Source struct {
+			Type      string `json:"type"`
+			MediaType string `json:"media_type"`
+			Data      string `json:"data"`
+		} `json:"source"`
+	}
+	if err := json.Unmarshal(b, &block); err != nil {
+		t.Fatal(err)
+	}
+	if block.Type != "document" || block.Source.Type != "base64" || block.Source.MediaType != "application/pdf" {
+		t.Fatalf("unexpected block: %s", b)
+	}
+	if block.Source.Data != base64.StdEncoding.EncodeToString([]byte(smallPDF)) {
+		t.Fatal("document data not base64 encoded correctly")
+	}
+}
+
+func TestAnthropicTextDocumentBlock(t *testing.T) {
+	path := writeDoc(t, "notes.txt", "plain notes")
+	docs, err := loadDocuments("anthropic", "claude-3-5-haiku-latest", []string{path})
+	if err != nil {
+		t.Fatalf("loadDocuments returned error: %v", err)
+	}
+	block := anthropicDocumentBlock(docs[0])
+	source := block["source"].(map[string]interface{})
+	if source["type"] != "text" || source["data"] != "plain notes" {
+		t.Fatalf("unexpected text document block: %v", block)
+	}
+}
+
+func TestGeminiDocumentPart(t *testing.T) {
+	path := writeDoc(t, "report.pdf", smallPDF)
+	docs, err := loadDocuments("gemini", "gemini-1.5-flash", []string{path})
+	if err != nil {
+		t.Fatalf("loadDocuments returned error: %v", err)
+	}
+
+	b, _ := json.Marshal(geminiDocumentPart(docs[0]))
+	want := `{"inlineData":{"mimeType":"application/pdf","data":"` + base64.StdEncoding.EncodeToString([]byte(smallPDF)) + `"}}`
+	if string(b) != want {
+		t.Fatalf("unexpected part:\n got %s\nwant %s", b, want)
+	}
+}
+
+func TestLoadDocumentsUnsupported(t *testing.T) {
+	path := writeDoc(t, "report.pdf", smallPDF)
+
+	tests := []struct {
+		provider, model string
+	}{
+		{"openai", "gpt-4o-mini"},
+		{"anthropic", "claude-3-opus-latest"},
+		{"gemini", "gemini-1.0-pro"},
+	}
+	for _, tc := range tests {
+		if _, err := loadDocuments(tc.provider, tc.model, []string{path}); err == nil {
+			t.Errorf("expected error for %s/%s", tc.provider, tc.model)
+		}
+	}
+
+	bin := writeDoc(t, "image.bin", "\x00\x01\x02\x03")
+	if _, err := loadDocuments("anthropic", "claude-3-5-sonnet-latest", []string{bin}); err == nil || !strings.Contains(err.Error(), "not a PDF") {
+		t.Errorf("expected unsupported type error, got %v", err)
+	}
+}
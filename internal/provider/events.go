@@ -0,0 +1,159 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"gogo/internal/history"
+	"gogo/internal/plugin"
+)
+
+// Event is one typed item of --format jsonl output.
+type Event struct {
+	// Type is "text", "tool_call", "tool_result", or "done".
+	Type   string          `json:"type"`
+	Delta  string          `json:"delta,omitempty"`
+	Tool   string          `json:"tool,omitempty"`
+	Input  json.RawMessage `json:"input,omitempty"`
+	Result *plugin.Result  `json:"result,omitempty"`
+	Usage  *Usage          `json:"usage,omitempty"`
+}
+
+// eventWriter is the out writer handed to the providers. Text deltas go to
+// text (for output, history and caching) and are also emitted as events
+// when events are enabled; tool calls and usage reach it via emitEvent and
+// recordUsage.
+type eventWriter struct {
+	w     io.Writer
+	text  io.Writer
+	usage Usage
+
+	// written counts the text bytes, so an empty response can be told
+	// apart from a short one.
+	written int
+
+	// rec, when set, builds a transcript from the events. w is nil when
+	// events are not written.
+	rec *recorder
+}
+
+func (ew *eventWriter) Write(p []byte) (int, error) {
+	if _, err := ew.text.Write(p); err != nil {
+		return 0, err
+	}
+	ew.written += len(p)
+	if err := ew.emit(Event{Type: "text", Delta: string(p)}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (ew *eventWriter) emit(ev Event) error {
+	if ew.rec != nil {
+		ew.rec.record(ev)
+	}
+	if ew.w == nil {
+		return nil
+	}
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	_, err = ew.w.Write(append(b, '\n'))
+	return err
+}
+
+// emitEvent sends ev if out is an event writer; otherwise it does nothing.
+func emitEvent(out io.Writer, ev Event) error {
+	if ew, ok := out.(*eventWriter); ok {
+		return ew.emit(ev)
+	}
+	return nil
+}
+
+// textWritten returns the number of text bytes out has received, and
+// false if out is not an event writer and does not count them.
+func textWritten(out io.Writer) (int, bool) {
+	if ew, ok := out.(*eventWriter); ok {
+		return ew.written, true
+	}
+	return 0, false
+}
+
+// beginTurn marks the start of a model response, so the transcript starts
+// a new assistant turn for what follows.
+func beginTurn(out io.Writer) {
+	if ew, ok := out.(*eventWriter); ok && ew.rec != nil {
+		ew.rec.turn = -1
+	}
+}
+
+// recordUsage adds one response's token usage to the total reported in the
+// done event.
+func recordUsage(out io.Writer, u Usage) {
+	if ew, ok := out.(*eventWriter); ok {
+		ew.usage.InputTokens += u.InputTokens
+		ew.usage.OutputTokens += u.OutputTokens
+	}
+}
+
+// rawJSON returns s as a raw JSON value, quoting it when it is not valid
+// JSON on its own (e.g. truncated or empty tool arguments).
+func rawJSON(s string) json.RawMessage {
+	if json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	b, _ := json.Marshal(s)
+	return b
+}
+
+// toolInput returns a recorded tool input as a JSON object string, the
+// shape every provider expects for tool arguments.
+func toolInput(input json.RawMessage) string {
+	if len(input) == 0 || !json.Valid(input) {
+		return "{}"
+	}
+	return string(input)
+}
+
+// recorder turns the event stream of one Stream call into transcript
+// turns. Providers execute a response's tool calls after the response is
+// read, so each assistant turn is followed by its tool results. Tool calls
+// get sequential IDs, which only have to match within the transcript.
+type recorder struct {
+	msgs  []history.Message
+	turn  int
+	calls int
+}
+
+func newRecorder(prompt string) *recorder {
+	return &recorder{msgs: []history.Message{{Role: "user", Content: prompt}}, turn: -1}
+}
+
+// assistant returns the current assistant turn, starting one if needed.
+func (r *recorder) assistant() *history.Message {
+	if r.turn < 0 {
+		r.msgs = append(r.msgs, history.Message{Role: "assistant"})
+		r.turn = len(r.msgs) - 1
+	}
+	return &r.msgs[r.turn]
+}
+
+func (r *recorder) record(ev Event) {
+	switch ev.Type {
+	case "text":
+		r.assistant().Content += ev.Delta
+	case "tool_call":
+		r.calls++
+		a := r.assistant()
+		a.ToolCalls = append(a.ToolCalls, history.ToolCall{ID: fmt.Sprintf("call_%d", r.calls), Name: ev.Tool, Input: ev.Input})
+	case "tool_result":
+		r.msgs = append(r.msgs, history.Message{
+			Role:       "tool",
+			ToolCallID: fmt.Sprintf("call_%d", r.calls),
+			Name:       ev.Tool,
+			Content:    ev.Result.ToJSON(),
+		})
+	}
+}
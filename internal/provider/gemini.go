@@ -5,18 +5,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
-	"os"
+	"time"
 
 	"gogo/internal/config"
+	"gogo/internal/history"
 	"gogo/internal/plugin"
 	"gogo/internal/stream"
 )
 
-const geminiBase = "https://generativelanguage.googleapis.com/v1beta/models/"
+var geminiBase = "https://generativelanguage.googleapis.com/v1beta/models/"
 
 type geminiRequest struct {
 	Contents          []geminiContent        `json:"contents"`
@@ -32,24 +33,24 @@ type geminiContent struct {
 
 type geminiPart struct {
 	Text             string                  `json:"text,omitempty"`
+	InlineData       *geminiInlineData       `json:"inlineData,omitempty"`
 	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
 	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
 }
 
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
 type geminiTool struct {
-	FunctionDeclarations []geminiFunctionDecl `json:"functionDeclarations"`
+	FunctionDeclarations []map[string]interface{} `json:"functionDeclarations"`
 }
 
 type geminiSystem struct {
 	Parts []geminiPart `json:"parts"`
 }
 
-type geminiFunctionDecl struct {
-	Name        string                 `json:"name"`
-	Description string                 `json:"description"`
-	Parameters  map[string]interface{} `json:"parameters"`
-}
-
 type geminiFunctionCall struct {
 	Name string                 `json:"name"`
 	Args map[string]interface{} `json:"args"`
@@ -65,34 +66,126 @@ type geminiEvent struct {
 		Content struct {
 			Parts []geminiPart `json:"parts"`
 		} `json:"content"`
+		FinishReason string `json:"finishReason"`
 	} `json:"candidates"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
 }
 
-func streamGemini(ctx context.Context, cfg config.Config, prompt string, out io.Writer, stderr io.Writer, tools *plugin.Registry) error {
-	key := os.Getenv("GEMINI_API_KEY")
-	if key == "" {
-		key = os.Getenv("GOOGLE_API_KEY")
-	}
-	if key == "" {
-		return errors.New("missing GEMINI_API_KEY or GOOGLE_API_KEY")
+func streamGemini(ctx context.Context, cfg config.Config, hist []history.Message, prompt string, out io.Writer, stderr io.Writer, tools *plugin.Registry) error {
+	key, err := apiKey(cfg, "GEMINI_API_KEY", "GOOGLE_API_KEY")
+	if err != nil {
+		return err
 	}
 
-	contents := []geminiContent{
-		{Role: "user", Parts: []geminiPart{{Text: prompt}}},
+	docs, err := loadDocuments(cfg.Provider, cfg.Model, cfg.Docs)
+	if err != nil {
+		return err
+	}
+	parts := make([]geminiPart, 0, len(docs)+1)
+	for _, d := range docs {
+		parts = append(parts, geminiDocumentPart(d))
 	}
+	parts = append(parts, geminiPart{Text: prompt})
+
+	contents := geminiHistory(hist)
+	contents = append(contents, geminiContent{Role: "user", Parts: parts})
 
 	return geminiStreamLoop(ctx, cfg, key, contents, out, stderr, tools)
 }
 
+// geminiHistory converts neutral history into Gemini contents. Gemini calls
+// the assistant role "model".
+func geminiHistory(hist []history.Message) []geminiContent {
+	contents := make([]geminiContent, 0, len(hist)+1)
+	for _, m := range hist {
+		if m.Role == "tool" {
+			var result interface{}
+			if err := json.Unmarshal([]byte(m.Content), &result); err != nil {
+				result = m.Content
+			}
+			part := geminiPart{FunctionResponse: &geminiFunctionResponse{
+				Name:     m.Name,
+				Response: map[string]interface{}{"result": result},
+			}}
+			// Results of one model turn share a single function turn.
+			if n := len(contents); n > 0 && contents[n-1].Role == "function" {
+				contents[n-1].Parts = append(contents[n-1].Parts, part)
+			} else {
+				contents = append(contents, geminiContent{Role: "function", Parts: []geminiPart{part}})
+			}
+			continue
+		}
+		role := m.Role
+		if role == "assistant" {
+			role = "model"
+		}
+		var parts []geminiPart
+		if m.Content != "" || len(m.ToolCalls) == 0 {
+			parts = append(parts, geminiPart{Text: m.Content})
+		}
+		for _, c := range m.ToolCalls {
+			var args map[string]interface{}
+			_ = json.Unmarshal([]byte(toolInput(c.Input)), &args)
+			parts = append(parts, geminiPart{FunctionCall: &geminiFunctionCall{Name: c.Name, Args: args}})
+		}
+		contents = append(contents, geminiContent{Role: role, Parts: parts})
+	}
+	return contents
+}
+
 func geminiStreamLoop(ctx context.Context, cfg config.Config, key string, contents []geminiContent, out io.Writer, stderr io.Writer, tools *plugin.Registry) error {
-	calls, err := geminiStreamOnce(ctx, cfg, key, contents, out, tools)
+	var calls []geminiFunctionCall
+	err := retryEmpty(ctx, cfg, out, stderr, func() (n int, finished bool, err error) {
+		calls, finished, err = geminiStreamOnce(ctx, cfg, key, contents, out, stderr, tools)
+		return len(calls), finished, err
+	})
 	if err != nil {
 		return err
 	}
-	if len(calls) == 0 {
-		return nil
+
+	next := append([]geminiContent{}, contents...)
+	for round := 0; len(calls) > 0; round++ {
+		if round == maxToolRounds(cfg) {
+			noteToolRounds(stderr, round)
+			return nil
+		}
+		responses, err := geminiRunTools(ctx, cfg, calls, out, stderr, tools)
+		if err != nil {
+			return err
+		}
+		if len(responses) == 0 {
+			return nil
+		}
+		parts := make([]geminiPart, 0, len(calls))
+		for i := range calls {
+			parts = append(parts, geminiPart{FunctionCall: &calls[i]})
+		}
+		next = append(next,
+			geminiContent{Role: "model", Parts: parts},
+			geminiContent{Role: "function", Parts: responses},
+		)
+		calls, _, err = geminiStreamOnce(ctx, roundConfig(cfg, round), key, next, out, stderr, tools)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
+// geminiRunTools executes the registered tools among calls and returns
+// their functionResponse parts.
+func geminiRunTools(ctx context.Context, cfg config.Config, calls []geminiFunctionCall, out io.Writer, stderr io.Writer, tools *plugin.Registry) ([]geminiPart, error) {
 	responses := make([]geminiPart, 0, len(calls))
 	for _, call := range calls {
 		// Check if the tool exists in the registry
@@ -100,8 +193,20 @@ func geminiStreamLoop(ctx context.Context, cfg config.Config, key string, conten
 			continue
 		}
 		reqBytes, _ := json.Marshal(call.Args)
-		res := tools.ExecuteTool(call.Name, reqBytes)
-		logToolResult(stderr, "gemini", call.Name, string(reqBytes), res)
+		if err := emitEvent(out, Event{Type: "tool_call", Tool: call.Name, Input: rawJSON(string(reqBytes))}); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		res := tools.ExecuteTool(ctx, call.Name, reqBytes)
+		if cfg.Debug {
+			logToolResult(stderr, cfg.LogFormat, "gemini", call.Name, string(reqBytes), res, time.Since(start))
+		}
+		if err := emitEvent(out, Event{Type: "tool_result", Tool: call.Name, Result: &res}); err != nil {
+			return nil, err
+		}
+		if err := strictToolError(cfg, call.Name, res); err != nil {
+			return nil, err
+		}
 		responses = append(responses, geminiPart{
 			FunctionResponse: &geminiFunctionResponse{
 				Name:     call.Name,
@@ -109,86 +214,123 @@ func geminiStreamLoop(ctx context.Context, cfg config.Config, key string, conten
 			},
 		})
 	}
-	if len(responses) == 0 {
-		return nil
-	}
-
-	next := append([]geminiContent{}, contents...)
-	next = append(next, geminiContent{Role: "function", Parts: responses})
-	_, err = geminiStreamOnce(ctx, cfg, key, next, out, tools)
-	return err
+	return responses, nil
 }
 
-func geminiStreamOnce(ctx context.Context, cfg config.Config, key string, contents []geminiContent, out io.Writer, tools *plugin.Registry) ([]geminiFunctionCall, error) {
+func geminiStreamOnce(ctx context.Context, cfg config.Config, key string, contents []geminiContent, out io.Writer, stderr io.Writer, tools *plugin.Registry) ([]geminiFunctionCall, bool, error) {
+	ctx, cancel := requestContext(ctx, cfg)
+	defer cancel()
+	beginTurn(out)
+
 	reqBody := geminiRequest{
 		Contents: contents,
 	}
-	if cfg.MaxTokens > 0 || cfg.Temperature > 0 {
+	temperature := requestTemperature(cfg)
+	if cfg.MaxTokens > 0 || temperature != nil || cfg.TopP > 0 || cfg.TopK > 0 || len(cfg.Stop) > 0 || cfg.ThinkingBudget != nil {
 		reqBody.GenerationConfig = map[string]interface{}{}
 		if cfg.MaxTokens > 0 {
 			reqBody.GenerationConfig["maxOutputTokens"] = cfg.MaxTokens
 		}
-		if cfg.Temperature > 0 {
-			reqBody.GenerationConfig["temperature"] = cfg.Temperature
+		if temperature != nil {
+			reqBody.GenerationConfig["temperature"] = *temperature
+		}
+		if cfg.TopP > 0 {
+			reqBody.GenerationConfig["topP"] = cfg.TopP
+		}
+		if cfg.TopK > 0 {
+			reqBody.GenerationConfig["topK"] = cfg.TopK
+		}
+		if len(cfg.Stop) > 0 {
+			reqBody.GenerationConfig["stopSequences"] = cfg.Stop
+		}
+		if cfg.ThinkingBudget != nil {
+			reqBody.GenerationConfig["thinkingConfig"] = map[string]int{"thinkingBudget": *cfg.ThinkingBudget}
 		}
 	}
-	// Build function declarations from the tool registry
-	funcDecls := make([]geminiFunctionDecl, 0)
-	for _, def := range tools.GetToolDefs() {
-		funcDecls = append(funcDecls, geminiFunctionDecl{
-			Name:        def.Name,
-			Description: def.Description,
-			Parameters:  def.InputSchema,
-		})
+	// Gemini rejects an empty functionDeclarations list, so tools are only
+	// sent when the registry has some.
+	if decls := tools.FormatGeminiTools(); len(decls) > 0 {
+		reqBody.Tools = []geminiTool{{FunctionDeclarations: decls}}
 	}
-	reqBody.Tools = []geminiTool{
-		{
-			FunctionDeclarations: funcDecls,
-		},
+	if instruction := systemInstruction(cfg, tools); instruction != "" {
+		reqBody.SystemInstruction = &geminiSystem{
+			Parts: []geminiPart{{Text: instruction}},
+		}
 	}
-	reqBody.SystemInstruction = &geminiSystem{
-		Parts: []geminiPart{{Text: tools.GenerateInstruction()}},
+	if cfg.DumpMessages {
+		dumpMessages(stderr, map[string]interface{}{
+			"systemInstruction": reqBody.SystemInstruction,
+			"contents":          reqBody.Contents,
+		})
 	}
 
-	b, err := json.Marshal(reqBody)
+	b, err := marshalRequest(cfg, reqBody)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 
-	endpoint := geminiBase + url.PathEscape(cfg.Model) + ":streamGenerateContent"
-	u, _ := url.Parse(endpoint)
+	method := ":streamGenerateContent"
+	if cfg.NoStream {
+		method = ":generateContent"
+	}
+	u, _ := url.Parse(geminiBase + url.PathEscape(cfg.Model) + method)
 	q := u.Query()
-	q.Set("alt", "sse")
+	if !cfg.NoStream {
+		q.Set("alt", "sse")
+	}
 	q.Set("key", key)
 	u.RawQuery = q.Encode()
 
+	if err := cfg.Budget.BeginCall(); err != nil {
+		return nil, false, err
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(b))
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setHeaders(req, cfg)
 
 	httpClient := &http.Client{Timeout: 0}
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, false, networkError(ctx, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, errors.New(string(body))
+	reqID, err := checkResponse(cfg, resp, stderr)
+	if err != nil {
+		return nil, false, err
 	}
 
 	writer := bufio.NewWriter(out)
 	var calls []geminiFunctionCall
+	var used Usage
+	var blockReason, finishReason string
 
-	err = stream.ReadEvents(resp.Body, func(data string) error {
-		var event geminiEvent
-		if err := json.Unmarshal([]byte(data), &event); err != nil {
-			return err
+	// A non-streaming response is a single object shaped like one event.
+	handle := func(event geminiEvent) error {
+		if e := event.Error; e != nil {
+			if e.Status == "" {
+				return &ProviderError{Kind: statusKind(e.Code), StatusCode: e.Code, Message: "gemini stream error: " + e.Message}
+			}
+			return &ProviderError{Kind: codeKind(e.Status), StatusCode: e.Code, Message: fmt.Sprintf("gemini stream error: %s (%s)", e.Message, e.Status)}
+		}
+		// usageMetadata is cumulative; the last chunk carries the totals
+		if event.UsageMetadata != nil {
+			used = Usage{
+				InputTokens:  event.UsageMetadata.PromptTokenCount,
+				OutputTokens: event.UsageMetadata.CandidatesTokenCount,
+			}
+		}
+		if event.PromptFeedback != nil && event.PromptFeedback.BlockReason != "" {
+			blockReason = event.PromptFeedback.BlockReason
 		}
 		for _, cand := range event.Candidates {
+			if cand.FinishReason != "" {
+				finishReason = cand.FinishReason
+			}
 			for _, part := range cand.Content.Parts {
 				if part.Text != "" {
 					if _, err := writer.WriteString(part.Text); err != nil {
@@ -204,9 +346,46 @@ func geminiStreamOnce(ctx context.Context, cfg config.Config, key string, conten
 			}
 		}
 		return nil
-	})
+	}
+	if cfg.NoStream {
+		var event geminiEvent
+		if err = json.NewDecoder(resp.Body).Decode(&event); err == nil {
+			err = handle(event)
+		}
+	} else {
+		err = stream.ReadEventsIdle(resp.Body, cfg.IdleTimeout, func(data string) error {
+			var event geminiEvent
+			if err := json.Unmarshal([]byte(data), &event); err != nil {
+				return err
+			}
+			return handle(event)
+		})
+	}
 	if err != nil {
-		return nil, err
+		return nil, false, withRequestID(networkError(ctx, err), reqID)
+	}
+	cfg.Budget.AddUsage(cfg.Model, used.InputTokens, used.OutputTokens)
+	recordUsage(out, used)
+	if blockReason != "" {
+		return nil, false, fmt.Errorf("%w: gemini blocked the prompt (blockReason %s)", ErrIncomplete, blockReason)
+	}
+	if err := geminiFinishError(finishReason); err != nil {
+		return nil, true, err
+	}
+	return calls, finishReason != "", nil
+}
+
+// geminiFinishError reports a finishReason that means the answer was
+// blocked or cut off. STOP (or no reason) is a normal end.
+func geminiFinishError(reason string) error {
+	switch reason {
+	case "", "STOP", "FINISH_REASON_UNSPECIFIED":
+		return nil
+	case "MAX_TOKENS":
+		return fmt.Errorf("%w: gemini hit the output token limit (finishReason MAX_TOKENS)", ErrIncomplete)
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return fmt.Errorf("%w: gemini blocked the response (finishReason %s)", ErrIncomplete, reason)
+	default:
+		return fmt.Errorf("%w: gemini stopped early (finishReason %s)", ErrIncomplete, reason)
 	}
-	return calls, nil
 }
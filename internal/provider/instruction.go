@@ -1,5 +1,29 @@
 package provider
 
+import (
+	"strings"
+
+	"gogo/internal/config"
+	"gogo/internal/plugin"
+)
+
+// systemInstruction combines the configured system prompt with the tool
+// instruction generated from the registry. The custom prompt comes first so
+// it sets the tone, and the tool guidance is kept so tool calling still works.
+// AppendSystem goes last, after the tool guidance, for guardrails that should
+// have the final word.
+// The result is sent as the OpenAI instructions field, the Anthropic system
+// field, and the Gemini systemInstruction.
+func systemInstruction(cfg config.Config, tools *plugin.Registry) string {
+	var parts []string
+	for _, p := range []string{cfg.System, tools.GenerateInstruction(), cfg.AppendSystem} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
 func fsInstruction() string {
 	return "If the user requests filesystem changes (create/edit/delete/list/move/copy), call the fs tool. Do not claim changes without using fs."
 }
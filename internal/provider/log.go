@@ -1,17 +1,43 @@
 package provider
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 
 	"gogo/internal/plugin"
 )
 
-// logToolResult logs tool execution for any tool type.
-func logToolResult(w io.Writer, provider string, toolName string, input string, res plugin.Result) {
+// toolLogEntry is one tool execution in --log-format json.
+type toolLogEntry struct {
+	Tool       string          `json:"tool"`
+	Provider   string          `json:"provider"`
+	OK         bool            `json:"ok"`
+	Error      string          `json:"error,omitempty"`
+	Input      json.RawMessage `json:"input"`
+	DurationMS int64           `json:"duration_ms"`
+}
+
+// logToolResult logs tool execution for any tool type. format is "json"
+// for one object per line; anything else is the human-readable form.
+func logToolResult(w io.Writer, format string, provider string, toolName string, input string, res plugin.Result, dur time.Duration) {
 	if w == nil {
 		return
 	}
+	if format == "json" {
+		entry := toolLogEntry{
+			Tool:       toolName,
+			Provider:   provider,
+			OK:         res.OK,
+			Error:      res.Error,
+			Input:      rawJSON(input),
+			DurationMS: dur.Milliseconds(),
+		}
+		b, _ := json.Marshal(entry)
+		fmt.Fprintf(w, "%s\n", b)
+		return
+	}
 	errText := res.Error
 	if errText == "" {
 		errText = "-"
@@ -20,5 +46,18 @@ func logToolResult(w io.Writer, provider string, toolName string, input string,
 	if len(input) > 100 {
 		input = input[:97] + "..."
 	}
-	fmt.Fprintf(w, "tool %s provider=%s ok=%t err=%s input=%s\n", toolName, provider, res.OK, errText, input)
+	fmt.Fprintf(w, "tool %s provider=%s ok=%t err=%s input=%s duration=%s\n", toolName, provider, res.OK, errText, input, dur.Round(time.Millisecond))
+}
+
+// dumpMessages writes the message array about to be sent as indented JSON.
+func dumpMessages(w io.Writer, v any) {
+	if w == nil {
+		return
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintln(w, "dump messages error:", err)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", b)
 }
@@ -6,25 +6,35 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"gogo/internal/config"
+	"gogo/internal/history"
 	"gogo/internal/plugin"
 	"gogo/internal/stream"
 )
 
-const openAIURL = "https://api.openai.com/v1/responses"
+var openAIURL = "https://api.openai.com/v1/responses"
 
 type openAIRequest struct {
 	Model              string           `json:"model"`
+	Instructions       string           `json:"instructions,omitempty"`
 	Input              []any            `json:"input"`
 	MaxOutputTokens    int              `json:"max_output_tokens,omitempty"`
-	Temperature        float64          `json:"temperature,omitempty"`
+	Temperature        *float64         `json:"temperature,omitempty"`
+	TopP               float64          `json:"top_p,omitempty"`
 	Stream             bool             `json:"stream"`
 	Tools              []map[string]any `json:"tools,omitempty"`
 	ToolChoice         string           `json:"tool_choice,omitempty"`
 	PreviousResponseID string           `json:"previous_response_id,omitempty"`
+	Reasoning          *openAIReasoning `json:"reasoning,omitempty"`
+}
+
+type openAIReasoning struct {
+	Effort string `json:"effort"`
 }
 
 type responseEvent struct {
@@ -37,6 +47,33 @@ type responseCreated struct {
 	} `json:"response"`
 }
 
+type responseCompleted struct {
+	Response struct {
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+		} `json:"usage"`
+	} `json:"response"`
+}
+
+// responseError is the payload of an "error" event.
+type responseError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// responseEnded covers response.incomplete and response.failed, which carry
+// the reason on the response object.
+type responseEnded struct {
+	Response struct {
+		Status            string         `json:"status"`
+		Error             *responseError `json:"error"`
+		IncompleteDetails *struct {
+			Reason string `json:"reason"`
+		} `json:"incomplete_details"`
+	} `json:"response"`
+}
+
 type outputTextDelta struct {
 	Delta string `json:"delta"`
 }
@@ -58,6 +95,27 @@ type functionArgsDelta struct {
 	Delta  string `json:"delta"`
 }
 
+// openAIResponse is the body of a non-streaming Responses API call.
+type openAIResponse struct {
+	ID                string         `json:"id"`
+	Status            string         `json:"status"`
+	Error             *responseError `json:"error"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details"`
+	Output []struct {
+		Type    string `json:"type"`
+		Content []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	} `json:"output"`
+	Usage struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
 type toolCall struct {
 	ID        string
 	CallID    string
@@ -65,104 +123,253 @@ type toolCall struct {
 	Arguments string
 }
 
-func streamOpenAI(ctx context.Context, cfg config.Config, prompt string, out io.Writer, stderr io.Writer, tools *plugin.Registry) error {
-	key, err := apiKey("OPENAI_API_KEY")
+func streamOpenAI(ctx context.Context, cfg config.Config, hist []history.Message, prompt string, out io.Writer, stderr io.Writer, tools *plugin.Registry) error {
+	key, err := apiKey(cfg, "OPENAI_API_KEY")
 	if err != nil {
 		return err
 	}
+	// The Responses API has no stop parameter; failing here is clearer
+	// than having the request rejected or the sequences silently dropped.
+	if len(cfg.Stop) > 0 {
+		return errors.New("openai provider does not support stop sequences (use anthropic, gemini, or openai-compatible)")
+	}
+	if cfg.TopK > 0 {
+		return errors.New("openai provider does not support top_k (use anthropic or gemini)")
+	}
+	if _, err := loadDocuments(cfg.Provider, cfg.Model, cfg.Docs); err != nil {
+		return err
+	}
 
-	input := []any{
-		map[string]any{
-			"role": "system",
-			"content": []map[string]string{
-				{"type": "input_text", "text": tools.GenerateInstruction()},
-			},
-		},
-		map[string]any{
-			"role": "user",
-			"content": []map[string]string{
-				{"type": "input_text", "text": prompt},
-			},
+	input := openAIHistory(hist)
+	input = append(input, map[string]any{
+		"role": "user",
+		"content": []map[string]string{
+			{"type": "input_text", "text": prompt},
 		},
-	}
+	})
 
-	return openAIStreamLoop(ctx, cfg, key, input, out, stderr, tools)
+	cfg = dropUnsupportedSampling(cfg, stderr)
+	return openAIStreamLoop(ctx, cfg, key, systemInstruction(cfg, tools), input, out, stderr, tools)
+}
+
+// openAIHistory converts neutral history into Responses API input items.
+func openAIHistory(hist []history.Message) []any {
+	items := make([]any, 0, len(hist))
+	for _, m := range hist {
+		if m.Role == "tool" {
+			items = append(items, map[string]any{
+				"type":    "function_call_output",
+				"call_id": m.ToolCallID,
+				"output":  m.Content,
+			})
+			continue
+		}
+		textType := "input_text"
+		if m.Role == "assistant" {
+			textType = "output_text"
+		}
+		if m.Content != "" || len(m.ToolCalls) == 0 {
+			items = append(items, map[string]any{
+				"role": m.Role,
+				"content": []map[string]string{
+					{"type": textType, "text": m.Content},
+				},
+			})
+		}
+		for _, c := range m.ToolCalls {
+			items = append(items, map[string]any{
+				"type":      "function_call",
+				"call_id":   c.ID,
+				"name":      c.Name,
+				"arguments": toolInput(c.Input),
+			})
+		}
+	}
+	return items
 }
 
-func openAIStreamLoop(ctx context.Context, cfg config.Config, key string, input []any, out io.Writer, stderr io.Writer, tools *plugin.Registry) error {
-	toolCalls, responseID, err := openAIStreamOnce(ctx, cfg, key, input, out, "", tools)
+// openAIStreamLoop sends instructions with every request: unlike input
+// items, they are not carried over through previous_response_id.
+//
+// The first request continues from cfg.ContinueID when set, and the ID of
+// the last response is printed to stderr so a later run can continue from
+// it.
+func openAIStreamLoop(ctx context.Context, cfg config.Config, key, instructions string, input []any, out io.Writer, stderr io.Writer, tools *plugin.Registry) error {
+	var toolCalls []toolCall
+	var responseID string
+	err := retryEmpty(ctx, cfg, out, stderr, func() (n int, finished bool, err error) {
+		toolCalls, responseID, finished, err = openAIStreamOnce(ctx, cfg, key, instructions, input, out, stderr, cfg.ContinueID, tools)
+		return len(toolCalls), finished, err
+	})
 	if err != nil {
 		return err
 	}
 
-	if len(toolCalls) == 0 {
-		return nil
+	for round := 0; len(toolCalls) > 0; round++ {
+		if round == maxToolRounds(cfg) {
+			noteToolRounds(stderr, round)
+			break
+		}
+		toolMessages, err := openAIRunTools(ctx, cfg, toolCalls, out, stderr, tools)
+		if err != nil {
+			return err
+		}
+		if len(toolMessages) == 0 {
+			break
+		}
+		toolCalls, responseID, _, err = openAIStreamOnce(ctx, roundConfig(cfg, round), key, instructions, toolMessages, out, stderr, responseID, tools)
+		if err != nil {
+			return err
+		}
+	}
+	if responseID != "" {
+		fmt.Fprintln(stderr, "response id:", responseID)
 	}
+	return nil
+}
 
+// openAIRunTools executes the registered tools among toolCalls and returns
+// their function_call_output items.
+func openAIRunTools(ctx context.Context, cfg config.Config, toolCalls []toolCall, out io.Writer, stderr io.Writer, tools *plugin.Registry) ([]any, error) {
 	toolMessages := make([]any, 0, len(toolCalls))
 	for _, call := range toolCalls {
 		// Check if the tool exists in the registry
 		if _, ok := tools.Get(call.Name); !ok {
 			continue
 		}
-		res := tools.ExecuteTool(call.Name, []byte(call.Arguments))
-		logToolResult(stderr, "openai", call.Name, call.Arguments, res)
+		if err := emitEvent(out, Event{Type: "tool_call", Tool: call.Name, Input: rawJSON(call.Arguments)}); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		res := tools.ExecuteTool(ctx, call.Name, []byte(call.Arguments))
+		if cfg.Debug {
+			logToolResult(stderr, cfg.LogFormat, "openai", call.Name, call.Arguments, res, time.Since(start))
+		}
+		if err := emitEvent(out, Event{Type: "tool_result", Tool: call.Name, Result: &res}); err != nil {
+			return nil, err
+		}
+		if err := strictToolError(cfg, call.Name, res); err != nil {
+			return nil, err
+		}
 		toolMessages = append(toolMessages, map[string]any{
 			"type":    "function_call_output",
 			"call_id": call.CallID,
 			"output":  res.ToJSON(),
 		})
 	}
+	return toolMessages, nil
+}
 
-	if len(toolMessages) == 0 {
-		return nil
+// openAIReadResponse writes the text of a non-streaming response to out
+// and returns the response ID and whether the response completed.
+func openAIReadResponse(cfg config.Config, body io.Reader, out io.Writer) (string, bool, error) {
+	var r openAIResponse
+	if err := json.NewDecoder(body).Decode(&r); err != nil {
+		return "", false, fmt.Errorf("decode openai response: %w", err)
 	}
+	used := Usage{InputTokens: r.Usage.InputTokens, OutputTokens: r.Usage.OutputTokens}
+	cfg.Budget.AddUsage(cfg.Model, used.InputTokens, used.OutputTokens)
+	recordUsage(out, used)
 
-	_, _, err = openAIStreamOnce(ctx, cfg, key, toolMessages, out, responseID, tools)
-	return err
+	for _, item := range r.Output {
+		if item.Type != "message" {
+			continue
+		}
+		for _, c := range item.Content {
+			if c.Type != "output_text" {
+				continue
+			}
+			if _, err := io.WriteString(out, c.Text); err != nil {
+				return "", false, err
+			}
+		}
+	}
+
+	switch r.Status {
+	case "failed":
+		if e := r.Error; e != nil {
+			return "", false, fmt.Errorf("openai response failed: %s (%s)", e.Message, e.Code)
+		}
+		return "", false, errors.New("openai response failed")
+	case "incomplete":
+		reason := "unknown"
+		if d := r.IncompleteDetails; d != nil && d.Reason != "" {
+			reason = d.Reason
+		}
+		return "", true, fmt.Errorf("%w: openai response incomplete (reason %s)", ErrIncomplete, reason)
+	}
+	return r.ID, r.Status == "completed", nil
 }
 
-func openAIStreamOnce(ctx context.Context, cfg config.Config, key string, input []any, out io.Writer, previousID string, tools *plugin.Registry) ([]toolCall, string, error) {
+func openAIStreamOnce(ctx context.Context, cfg config.Config, key, instructions string, input []any, out io.Writer, stderr io.Writer, previousID string, tools *plugin.Registry) ([]toolCall, string, bool, error) {
+	ctx, cancel := requestContext(ctx, cfg)
+	defer cancel()
+	beginTurn(out)
+
 	reqBody := openAIRequest{
 		Model:              cfg.Model,
+		Instructions:       instructions,
 		Input:              input,
 		MaxOutputTokens:    cfg.MaxTokens,
-		Temperature:        cfg.Temperature,
-		Stream:             true,
+		Temperature:        requestTemperature(cfg),
+		TopP:               cfg.TopP,
+		Stream:             !cfg.NoStream,
 		PreviousResponseID: previousID,
 		Tools:              tools.FormatOpenAITools(),
-		ToolChoice:         "auto",
+	}
+	if len(reqBody.Tools) > 0 {
+		reqBody.ToolChoice = "auto"
+	}
+	if cfg.ReasoningEffort != "" {
+		reqBody.Reasoning = &openAIReasoning{Effort: cfg.ReasoningEffort}
+	}
+	if cfg.DumpMessages {
+		dumpMessages(stderr, map[string]interface{}{
+			"instructions": reqBody.Instructions,
+			"input":        reqBody.Input,
+		})
 	}
 
-	b, err := json.Marshal(reqBody)
+	b, err := marshalRequest(cfg, reqBody)
 	if err != nil {
-		return nil, "", err
+		return nil, "", false, err
+	}
+
+	if err := cfg.Budget.BeginCall(); err != nil {
+		return nil, "", false, err
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, openAIURL, bytes.NewReader(b))
 	if err != nil {
-		return nil, "", err
+		return nil, "", false, err
 	}
 	req.Header.Set("Authorization", "Bearer "+key)
 	req.Header.Set("Content-Type", "application/json")
+	setHeaders(req, cfg)
 
 	httpClient := &http.Client{Timeout: 0}
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return nil, "", err
+		return nil, "", false, networkError(ctx, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 300 {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, "", errors.New(string(body))
+	reqID, err := checkResponse(cfg, resp, stderr)
+	if err != nil {
+		return nil, "", false, err
+	}
+	if cfg.NoStream {
+		id, finished, err := openAIReadResponse(cfg, resp.Body, out)
+		return nil, id, finished, withRequestID(networkError(ctx, err), reqID)
 	}
 
 	writer := bufio.NewWriter(out)
 	toolCalls := make(map[string]*toolCall)
 	responseID := ""
+	var used Usage
+	var finished bool
 
-	err = stream.ReadEvents(resp.Body, func(data string) error {
+	err = stream.ReadEventsIdle(resp.Body, cfg.IdleTimeout, func(data string) error {
 		var evt responseEvent
 		if err := json.Unmarshal([]byte(data), &evt); err != nil {
 			return err
@@ -175,6 +382,41 @@ func openAIStreamOnce(ctx context.Context, cfg config.Config, key string, input
 				return err
 			}
 			responseID = created.Response.ID
+		case "response.completed":
+			var completed responseCompleted
+			if err := json.Unmarshal([]byte(data), &completed); err != nil {
+				return err
+			}
+			finished = true
+			used = Usage{
+				InputTokens:  completed.Response.Usage.InputTokens,
+				OutputTokens: completed.Response.Usage.OutputTokens,
+			}
+		case "error", "response.error":
+			var e responseError
+			if err := json.Unmarshal([]byte(data), &e); err != nil {
+				return err
+			}
+			return &ProviderError{Kind: codeKind(e.Code), Message: fmt.Sprintf("openai stream error: %s (%s)", e.Message, e.Code)}
+		case "response.failed":
+			var ended responseEnded
+			if err := json.Unmarshal([]byte(data), &ended); err != nil {
+				return err
+			}
+			if e := ended.Response.Error; e != nil {
+				return fmt.Errorf("openai response failed: %s (%s)", e.Message, e.Code)
+			}
+			return errors.New("openai response failed")
+		case "response.incomplete":
+			var ended responseEnded
+			if err := json.Unmarshal([]byte(data), &ended); err != nil {
+				return err
+			}
+			reason := "unknown"
+			if d := ended.Response.IncompleteDetails; d != nil && d.Reason != "" {
+				reason = d.Reason
+			}
+			return fmt.Errorf("%w: openai response incomplete (reason %s)", ErrIncomplete, reason)
 		case "response.output_text.delta":
 			var delta outputTextDelta
 			if err := json.Unmarshal([]byte(data), &delta); err != nil {
@@ -213,12 +455,14 @@ func openAIStreamOnce(ctx context.Context, cfg config.Config, key string, input
 		return nil
 	})
 	if err != nil {
-		return nil, "", err
+		return nil, "", false, withRequestID(networkError(ctx, err), reqID)
 	}
+	cfg.Budget.AddUsage(cfg.Model, used.InputTokens, used.OutputTokens)
+	recordUsage(out, used)
 
 	calls := make([]toolCall, 0, len(toolCalls))
 	for _, call := range toolCalls {
 		calls = append(calls, *call)
 	}
-	return calls, responseID, nil
+	return calls, responseID, finished, nil
 }
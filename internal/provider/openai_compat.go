@@ -0,0 +1,394 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gogo/internal/config"
+	"gogo/internal/history"
+	"gogo/internal/plugin"
+	"gogo/internal/stream"
+)
+
+// DefaultCompatKeyEnv is the API key variable for the openai-compatible
+// provider when api_key_env is not configured.
+const DefaultCompatKeyEnv = "GOGO_API_KEY"
+
+type chatMessage struct {
+	Role       string         `json:"role"`
+	Content    string         `json:"content"`
+	ToolCalls  []chatToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string         `json:"tool_call_id,omitempty"`
+}
+
+type chatToolCall struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Function struct {
+		Name      string `json:"name,omitempty"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type chatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string         `json:"content"`
+			ToolCalls []chatToolCall `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *chatError `json:"error"`
+}
+
+// chatError is an error sent in place of a chunk. Servers disagree on
+// whether code is a string or an HTTP status number, so type is tried
+// first.
+type chatError struct {
+	Message string          `json:"message"`
+	Type    string          `json:"type"`
+	Code    json.RawMessage `json:"code"`
+}
+
+func (e *chatError) kind() ErrorKind {
+	if e.Type != "" {
+		return codeKind(e.Type)
+	}
+	var status int
+	if json.Unmarshal(e.Code, &status) == nil && status != 0 {
+		return statusKind(status)
+	}
+	var code string
+	if json.Unmarshal(e.Code, &code) == nil {
+		return codeKind(code)
+	}
+	return KindBadRequest
+}
+
+// chatCompletion is the body of a non-streaming chat/completions call.
+type chatCompletion struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+func streamOpenAICompatible(ctx context.Context, cfg config.Config, hist []history.Message, prompt string, out io.Writer, stderr io.Writer, tools *plugin.Registry) error {
+	if cfg.BaseURL == "" {
+		return errors.New("openai-compatible provider requires base_url")
+	}
+	keyEnv := cfg.APIKeyEnv
+	if keyEnv == "" {
+		keyEnv = DefaultCompatKeyEnv
+	}
+	key, err := apiKey(cfg, keyEnv)
+	if err != nil {
+		return err
+	}
+	if _, err := loadDocuments(cfg.Provider, cfg.Model, cfg.Docs); err != nil {
+		return err
+	}
+	// Many compatible backends reject or mishandle tools, so they are only
+	// sent when the config says the backend supports them.
+	if !cfg.CompatTools {
+		tools = plugin.NewRegistry()
+	}
+
+	var messages []chatMessage
+	if instruction := systemInstruction(cfg, tools); instruction != "" {
+		messages = append(messages, chatMessage{Role: "system", Content: instruction})
+	}
+	messages = append(messages, chatHistory(hist)...)
+	messages = append(messages, chatMessage{Role: "user", Content: prompt})
+
+	cfg = dropUnsupportedSampling(cfg, stderr)
+	return chatStreamLoop(ctx, cfg, key, messages, out, stderr, tools)
+}
+
+// chatHistory converts neutral history into chat messages.
+func chatHistory(hist []history.Message) []chatMessage {
+	messages := make([]chatMessage, 0, len(hist))
+	for _, m := range hist {
+		msg := chatMessage{Role: m.Role, Content: m.Content, ToolCallID: m.ToolCallID}
+		for i, c := range m.ToolCalls {
+			call := chatToolCall{Index: i, ID: c.ID, Type: "function"}
+			call.Function.Name = c.Name
+			call.Function.Arguments = toolInput(c.Input)
+			msg.ToolCalls = append(msg.ToolCalls, call)
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+func chatStreamLoop(ctx context.Context, cfg config.Config, key string, messages []chatMessage, out io.Writer, stderr io.Writer, tools *plugin.Registry) error {
+	var calls []chatToolCall
+	err := retryEmpty(ctx, cfg, out, stderr, func() (n int, finished bool, err error) {
+		calls, finished, err = chatStreamOnce(ctx, cfg, key, messages, out, stderr, tools)
+		return len(calls), finished, err
+	})
+	if err != nil {
+		return err
+	}
+
+	for round := 0; len(calls) > 0; round++ {
+		if round == maxToolRounds(cfg) {
+			noteToolRounds(stderr, round)
+			return nil
+		}
+		results, err := chatRunTools(ctx, cfg, calls, out, stderr, tools)
+		if err != nil {
+			return err
+		}
+		if len(results) == 0 {
+			return nil
+		}
+		messages = append(messages, chatMessage{Role: "assistant", ToolCalls: calls})
+		messages = append(messages, results...)
+		calls, _, err = chatStreamOnce(ctx, roundConfig(cfg, round), key, messages, out, stderr, tools)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chatRunTools executes the registered tools among calls and returns their
+// tool messages.
+func chatRunTools(ctx context.Context, cfg config.Config, calls []chatToolCall, out io.Writer, stderr io.Writer, tools *plugin.Registry) ([]chatMessage, error) {
+	var results []chatMessage
+	for _, call := range calls {
+		name, args := call.Function.Name, call.Function.Arguments
+		if _, ok := tools.Get(name); !ok {
+			continue
+		}
+		if err := emitEvent(out, Event{Type: "tool_call", Tool: name, Input: rawJSON(args)}); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		res := tools.ExecuteTool(ctx, name, []byte(args))
+		if cfg.Debug {
+			logToolResult(stderr, cfg.LogFormat, "openai-compatible", name, args, res, time.Since(start))
+		}
+		if err := emitEvent(out, Event{Type: "tool_result", Tool: name, Result: &res}); err != nil {
+			return nil, err
+		}
+		if err := strictToolError(cfg, name, res); err != nil {
+			return nil, err
+		}
+		results = append(results, chatMessage{Role: "tool", ToolCallID: call.ID, Content: res.ToJSON()})
+	}
+	return results, nil
+}
+
+// chatReadCompletion writes the text of a non-streaming response to out
+// and reports whether it carried a finish_reason. A response cut off at
+// the token limit is written and then reported as ErrIncomplete.
+func chatReadCompletion(cfg config.Config, body io.Reader, out io.Writer) (bool, error) {
+	var c chatCompletion
+	if err := json.NewDecoder(body).Decode(&c); err != nil {
+		return false, fmt.Errorf("decode chat completion: %w", err)
+	}
+	used := Usage{InputTokens: c.Usage.PromptTokens, OutputTokens: c.Usage.CompletionTokens}
+	cfg.Budget.AddUsage(cfg.Model, used.InputTokens, used.OutputTokens)
+	recordUsage(out, used)
+	if len(c.Choices) == 0 {
+		return false, nil
+	}
+	if _, err := io.WriteString(out, c.Choices[0].Message.Content); err != nil {
+		return false, err
+	}
+	reason := c.Choices[0].FinishReason
+	return reason != "", chatFinishError(reason)
+}
+
+// chatFinishError reports a finish_reason of "length", which means the
+// answer was cut off at the token limit.
+func chatFinishError(reason string) error {
+	if reason == "length" {
+		return fmt.Errorf("%w: openai-compatible hit the output token limit (finish_reason length)", ErrIncomplete)
+	}
+	return nil
+}
+
+// chatTools wraps the Responses-style tool payload in the nested
+// {"type":"function","function":{...}} shape chat-completions expects.
+func chatTools(tools *plugin.Registry) []map[string]any {
+	flat := tools.FormatOpenAITools()
+	if len(flat) == 0 {
+		return nil
+	}
+	res := make([]map[string]any, 0, len(flat))
+	for _, t := range flat {
+		res = append(res, map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name":        t["name"],
+				"description": t["description"],
+				"parameters":  t["parameters"],
+			},
+		})
+	}
+	return res
+}
+
+func chatStreamOnce(ctx context.Context, cfg config.Config, key string, messages []chatMessage, out io.Writer, stderr io.Writer, tools *plugin.Registry) ([]chatToolCall, bool, error) {
+	ctx, cancel := requestContext(ctx, cfg)
+	defer cancel()
+	beginTurn(out)
+
+	reqBody := map[string]any{
+		"model":    cfg.Model,
+		"messages": messages,
+		"stream":   !cfg.NoStream,
+	}
+	if !cfg.NoStream {
+		reqBody["stream_options"] = map[string]any{"include_usage": true}
+	}
+	if cfg.MaxTokens > 0 {
+		reqBody[chatMaxTokensField(cfg.Model)] = cfg.MaxTokens
+	}
+	if t := requestTemperature(cfg); t != nil {
+		reqBody["temperature"] = *t
+	}
+	if cfg.TopP > 0 {
+		reqBody["top_p"] = cfg.TopP
+	}
+	// top_k is not part of the OpenAI API, but the common self-hosted
+	// servers (vLLM, LocalAI, Together) accept it.
+	if cfg.TopK > 0 {
+		reqBody["top_k"] = cfg.TopK
+	}
+	if len(cfg.Stop) > 0 {
+		reqBody["stop"] = cfg.Stop
+	}
+	if t := chatTools(tools); t != nil {
+		reqBody["tools"] = t
+	}
+	if cfg.DumpMessages {
+		dumpMessages(stderr, messages)
+	}
+
+	b, err := marshalRequest(cfg, reqBody)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := cfg.Budget.BeginCall(); err != nil {
+		return nil, false, err
+	}
+
+	url := strings.TrimRight(cfg.BaseURL, "/") + "/chat/completions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Content-Type", "application/json")
+	setHeaders(req, cfg)
+
+	httpClient := &http.Client{Timeout: 0}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, false, networkError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	reqID, err := checkResponse(cfg, resp, stderr)
+	if err != nil {
+		return nil, false, err
+	}
+	if cfg.NoStream {
+		finished, err := chatReadCompletion(cfg, resp.Body, out)
+		return nil, finished, withRequestID(networkError(ctx, err), reqID)
+	}
+
+	writer := bufio.NewWriter(out)
+	calls := make(map[int]*chatToolCall)
+	var used Usage
+	var finishReason string
+
+	err = stream.ReadEventsIdle(resp.Body, cfg.IdleTimeout, func(data string) error {
+		if data == "[DONE]" {
+			return nil
+		}
+		var chunk chatChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return err
+		}
+		if e := chunk.Error; e != nil {
+			return &ProviderError{Kind: e.kind(), Message: "openai-compatible stream error: " + e.Message}
+		}
+		if chunk.Usage != nil {
+			used = Usage{
+				InputTokens:  chunk.Usage.PromptTokens,
+				OutputTokens: chunk.Usage.CompletionTokens,
+			}
+		}
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+			if choice.Delta.Content != "" {
+				if _, err := writer.WriteString(choice.Delta.Content); err != nil {
+					return err
+				}
+				if err := writer.Flush(); err != nil {
+					return err
+				}
+			}
+			// Tool calls arrive in fragments keyed by index: the first
+			// carries the id and name, later ones append to the arguments.
+			for _, tc := range choice.Delta.ToolCalls {
+				call := calls[tc.Index]
+				if call == nil {
+					call = &chatToolCall{Index: tc.Index, Type: "function"}
+					calls[tc.Index] = call
+				}
+				if tc.ID != "" {
+					call.ID = tc.ID
+				}
+				if tc.Function.Name != "" {
+					call.Function.Name = tc.Function.Name
+				}
+				call.Function.Arguments += tc.Function.Arguments
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, withRequestID(networkError(ctx, err), reqID)
+	}
+	cfg.Budget.AddUsage(cfg.Model, used.InputTokens, used.OutputTokens)
+	recordUsage(out, used)
+	if err := chatFinishError(finishReason); err != nil {
+		return nil, true, err
+	}
+
+	res := make([]chatToolCall, 0, len(calls))
+	for _, call := range calls {
+		res = append(res, *call)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Index < res[j].Index })
+	return res, finishReason != "", nil
+}
@@ -0,0 +1,78 @@
+package provider
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"gogo/internal/config"
+)
+
+// openAIModelCaps describes request-shape differences between OpenAI model
+// families.
+type openAIModelCaps struct {
+	// MaxCompletionTokens is set for models that reject max_tokens and
+	// require max_completion_tokens instead (chat-completions only).
+	MaxCompletionTokens bool
+
+	// NoSampling is set for reasoning models that reject temperature and
+	// top_p.
+	NoSampling bool
+}
+
+// openAIModelTable maps model id prefixes to their capabilities. The first
+// matching prefix wins, so more specific entries come first.
+var openAIModelTable = []struct {
+	prefix string
+	caps   openAIModelCaps
+}{
+	{"o1", openAIModelCaps{MaxCompletionTokens: true, NoSampling: true}},
+	{"o3", openAIModelCaps{MaxCompletionTokens: true, NoSampling: true}},
+	{"o4", openAIModelCaps{MaxCompletionTokens: true, NoSampling: true}},
+	{"gpt-4.1", openAIModelCaps{MaxCompletionTokens: true}},
+	{"gpt-4.5", openAIModelCaps{MaxCompletionTokens: true}},
+	{"gpt-5", openAIModelCaps{MaxCompletionTokens: true, NoSampling: true}},
+}
+
+// lookupOpenAIModel returns the capabilities for a model id. Gateway-style
+// ids such as "openai/o3-mini" are matched on the part after the slash.
+func lookupOpenAIModel(model string) openAIModelCaps {
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	model = strings.ToLower(model)
+	for _, entry := range openAIModelTable {
+		if model == entry.prefix || strings.HasPrefix(model, entry.prefix+"-") ||
+			(strings.HasPrefix(entry.prefix, "gpt-") && strings.HasPrefix(model, entry.prefix)) {
+			return entry.caps
+		}
+	}
+	return openAIModelCaps{}
+}
+
+// chatMaxTokensField returns the chat-completions field that carries the
+// output token limit for model.
+func chatMaxTokensField(model string) string {
+	if lookupOpenAIModel(model).MaxCompletionTokens {
+		return "max_completion_tokens"
+	}
+	return "max_tokens"
+}
+
+// dropUnsupportedSampling clears the temperature settings and top_p for
+// models that reject them, so a global temperature in the config does not
+// fail every request with a 400. It notes the omission under Debug.
+func dropUnsupportedSampling(cfg config.Config, stderr io.Writer) config.Config {
+	if !lookupOpenAIModel(cfg.Model).NoSampling {
+		return cfg
+	}
+	if cfg.Temperature == 0 && cfg.FinalTemperature == nil && cfg.TopP == 0 {
+		return cfg
+	}
+	if cfg.Debug && stderr != nil {
+		fmt.Fprintf(stderr, "%s: model %s does not support temperature or top_p; omitted\n", cfg.Provider, cfg.Model)
+	}
+	cfg.Temperature, cfg.FinalTemperature, cfg.TopP = 0, nil, 0
+	cfg.ExplicitTemperature = false
+	return cfg
+}
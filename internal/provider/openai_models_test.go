@@ -0,0 +1,62 @@
+package provider
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gogo/internal/config"
+)
+
+func TestChatMaxTokensField(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"gpt-4o-mini", "max_tokens"},
+		{"gpt-4o", "max_tokens"},
+		{"gpt-3.5-turbo", "max_tokens"},
+		{"gpt-4.1", "max_completion_tokens"},
+		{"gpt-4.1-mini", "max_completion_tokens"},
+		{"gpt-5", "max_completion_tokens"},
+		{"o1", "max_completion_tokens"},
+		{"o1-preview", "max_completion_tokens"},
+		{"o3-mini", "max_completion_tokens"},
+		{"o4-mini", "max_completion_tokens"},
+		{"openai/o3-mini", "max_completion_tokens"},
+		{"omni-moderation", "max_tokens"},
+		{"llama-3.1-70b", "max_tokens"},
+	}
+	for _, tc := range tests {
+		if got := chatMaxTokensField(tc.model); got != tc.want {
+			t.Errorf("chatMaxTokensField(%q) = %q, want %q", tc.model, got, tc.want)
+		}
+	}
+}
+
+func TestDropUnsupportedSampling(t *testing.T) {
+	tests := []struct {
+		model string
+		drop  bool
+	}{
+		{"o3-mini", true},
+		{"o1", true},
+		{"openai/o4-mini", true},
+		{"gpt-5-mini", true},
+		{"gpt-4o-mini", false},
+		{"gpt-4.1", false},
+	}
+	for _, tc := range tests {
+		var stderr bytes.Buffer
+		final := 0.2
+		cfg := config.Config{Provider: "openai", Model: tc.model, Temperature: 0.7, FinalTemperature: &final, TopP: 0.9, Debug: true}
+		got := dropUnsupportedSampling(cfg, &stderr)
+		dropped := got.Temperature == 0 && got.FinalTemperature == nil && got.TopP == 0
+		if dropped != tc.drop {
+			t.Errorf("%s: got temperature=%v final=%v top_p=%v, want dropped=%v", tc.model, got.Temperature, got.FinalTemperature, got.TopP, tc.drop)
+		}
+		if noted := strings.Contains(stderr.String(), "does not support temperature"); noted != tc.drop {
+			t.Errorf("%s: debug note = %q", tc.model, stderr.String())
+		}
+	}
+}
@@ -2,41 +2,501 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"gogo/internal/config"
+	"gogo/internal/history"
+	"gogo/internal/keyring"
 	"gogo/internal/plugin"
+	"gogo/internal/stream"
 )
 
+// ErrIncomplete is wrapped by errors for responses the provider blocked or
+// cut short (safety filters, token limits), so partial output is not
+// mistaken for a complete answer.
+var ErrIncomplete = errors.New("response incomplete")
+
+// ErrEmptyResponse is wrapped by the error for a response that completed
+// without any text or tool calls, even after a retry.
+var ErrEmptyResponse = errors.New("provider returned empty response")
+
+// emptyRetryDelay is how long to wait before retrying an empty response.
+var emptyRetryDelay = time.Second
+
+// ToolError is returned when StrictTools is set and a tool returns an
+// error result.
+type ToolError struct {
+	Tool    string
+	Message string
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("tool %s failed: %s", e.Tool, e.Message)
+}
+
+// ErrorKind classifies a ProviderError.
+type ErrorKind string
+
+const (
+	// KindAuth means the provider rejected the credentials.
+	KindAuth ErrorKind = "auth"
+	// KindRateLimit means the request was throttled.
+	KindRateLimit ErrorKind = "rate_limit"
+	// KindServer means the provider failed or was overloaded.
+	KindServer ErrorKind = "server"
+	// KindBadRequest means the provider refused the request as sent.
+	KindBadRequest ErrorKind = "bad_request"
+	// KindNetwork means the provider could not be reached or the
+	// connection failed mid-response.
+	KindNetwork ErrorKind = "network"
+)
+
+// ProviderError is returned when a provider call fails: a non-2xx status,
+// an error event in the stream, or a network failure. Err holds the
+// underlying error for network failures.
+type ProviderError struct {
+	Kind       ErrorKind
+	StatusCode int
+	Message    string
+	RequestID  string
+	Err        error
+}
+
+func (e *ProviderError) Error() string {
+	if e.RequestID == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (request id %s)", e.Message, e.RequestID)
+}
+
+func (e *ProviderError) Unwrap() error {
+	return e.Err
+}
+
+// Auth reports whether the provider rejected the credentials, which a
+// retry will not fix.
+func (e *ProviderError) Auth() bool {
+	return e.Kind == KindAuth
+}
+
+// Retryable reports whether the failure was rate limiting, a server error
+// or a network failure, which may succeed on a later attempt.
+func (e *ProviderError) Retryable() bool {
+	return e.Kind == KindRateLimit || e.Kind == KindServer || e.Kind == KindNetwork
+}
+
+// statusKind classifies a non-2xx HTTP status.
+func statusKind(code int) ErrorKind {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return KindAuth
+	case code == http.StatusTooManyRequests:
+		return KindRateLimit
+	case code >= 500:
+		return KindServer
+	default:
+		return KindBadRequest
+	}
+}
+
+// codeKind classifies the error type, code or status of an in-stream error
+// event, such as "rate_limit_exceeded", "overloaded_error" or gemini's
+// "RESOURCE_EXHAUSTED".
+func codeKind(code string) ErrorKind {
+	code = strings.ToLower(code)
+	switch {
+	case strings.Contains(code, "rate_limit") || code == "resource_exhausted":
+		return KindRateLimit
+	case strings.Contains(code, "auth") || strings.Contains(code, "permission"):
+		return KindAuth
+	case strings.Contains(code, "server") || strings.Contains(code, "overloaded") ||
+		code == "api_error" || code == "internal" || code == "unavailable":
+		return KindServer
+	default:
+		return KindBadRequest
+	}
+}
+
+// errRequestTimeout is the cause of a request context that hit
+// cfg.RequestTimeout, telling it apart from the overall --timeout. It
+// wraps context.DeadlineExceeded so callers can still match either.
+var errRequestTimeout = fmt.Errorf("request timeout: %w", context.DeadlineExceeded)
+
+// networkError marks transport failures (a failed round trip, a dropped
+// or idle stream, a per-request deadline) as KindNetwork and returns other
+// errors unchanged. ctx is the request context from requestContext.
+// Cancellation and the overall --timeout are left alone: they are the
+// caller stopping the run, not a failure worth retrying.
+func networkError(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return err
+	}
+	if errors.Is(err, context.DeadlineExceeded) && !errors.Is(context.Cause(ctx), errRequestTimeout) {
+		return err
+	}
+	var pe *ProviderError
+	if errors.As(err, &pe) {
+		return err
+	}
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, stream.ErrIdleTimeout) || errors.Is(err, context.DeadlineExceeded) {
+		return &ProviderError{Kind: KindNetwork, Message: err.Error(), Err: err}
+	}
+	return err
+}
+
+// retryEmpty calls once, which sends a request and returns how many tool
+// calls the response made and whether it ended with a terminal event
+// carrying a finish reason. Providers occasionally answer 200 with a
+// stream that just stops, which would otherwise exit 0 with no output, so
+// a response that wrote no text to out, made no tool calls and never
+// finished is retried once after emptyRetryDelay; a second such response
+// is a retryable ProviderError wrapping ErrEmptyResponse. An empty
+// response with a real finish reason, such as a stop sequence or a
+// content filter, is passed through as is.
+func retryEmpty(ctx context.Context, cfg config.Config, out, stderr io.Writer, once func() (int, bool, error)) error {
+	before, counted := textWritten(out)
+	n, finished, err := once()
+	if err != nil || n > 0 || finished || !counted {
+		return err
+	}
+	if after, _ := textWritten(out); after > before {
+		return nil
+	}
+	if cfg.Debug {
+		fmt.Fprintf(stderr, "%s: empty response, retrying in %v\n", cfg.Provider, emptyRetryDelay)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(emptyRetryDelay):
+	}
+	if n, finished, err = once(); err != nil || n > 0 || finished {
+		return err
+	}
+	if after, _ := textWritten(out); after > before {
+		return nil
+	}
+	return &ProviderError{Kind: KindServer, Message: cfg.Provider + ": " + ErrEmptyResponse.Error(), Err: ErrEmptyResponse}
+}
+
+// strictToolError returns a *ToolError for a failed result under
+// StrictTools, and nil otherwise.
+func strictToolError(cfg config.Config, name string, res plugin.Result) error {
+	if !cfg.StrictTools || res.OK {
+		return nil
+	}
+	return &ToolError{Tool: name, Message: res.Error}
+}
+
+// Usage is the token usage reported by provider responses.
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
 type Client struct {
-	cfg    config.Config
-	stderr io.Writer
-	tools  *plugin.Registry
+	cfg     config.Config
+	stderr  io.Writer
+	tools   *plugin.Registry
+	history []history.Message
+	events  io.Writer
+
+	// recording and rec hold the transcript of the last Stream call; see
+	// RecordTranscript.
+	recording bool
+	rec       *recorder
+
+	// usage is the token usage of the last Stream call.
+	usage Usage
 }
 
 func NewClient(cfg config.Config, stderr io.Writer, tools *plugin.Registry) *Client {
 	return &Client{cfg: cfg, stderr: stderr, tools: tools}
 }
 
+// SetHistory sets prior conversation turns sent ahead of the prompt.
+func (c *Client) SetHistory(msgs []history.Message) {
+	c.history = msgs
+}
+
+// SetEvents switches the client to typed output: text deltas, tool calls,
+// tool results and a final done event with usage are written to w as JSON
+// lines. The out writer passed to Stream still receives the plain text.
+func (c *Client) SetEvents(w io.Writer) {
+	c.events = w
+}
+
+// RecordTranscript makes Stream record the prompt, the model's responses,
+// and its tool calls and results, for Transcript.
+func (c *Client) RecordTranscript() {
+	c.recording = true
+}
+
+// Transcript returns the history followed by the turns of the last Stream
+// call. It is only complete when RecordTranscript was called first.
+func (c *Client) Transcript() []history.Message {
+	msgs := append([]history.Message{}, c.history...)
+	if c.rec != nil {
+		msgs = append(msgs, c.rec.msgs...)
+	}
+	return msgs
+}
+
+// Usage returns the token usage of the last Stream call, summed over its
+// tool rounds.
+func (c *Client) Usage() Usage {
+	return c.usage
+}
+
 func (c *Client) Stream(ctx context.Context, prompt string, out io.Writer) error {
+	ew := &eventWriter{w: c.events, text: out}
+	if c.recording {
+		c.rec = newRecorder(prompt)
+		ew.rec = c.rec
+	}
+	err := c.stream(ctx, prompt, ew)
+	c.usage = ew.usage
+	if err != nil {
+		return err
+	}
+	if c.events == nil {
+		return nil
+	}
+	return ew.emit(Event{Type: "done", Usage: &ew.usage})
+}
+
+func (c *Client) stream(ctx context.Context, prompt string, out io.Writer) error {
+	tools := c.tools
+	// Tool calls are only parsed from streamed responses, so a
+	// non-streaming request is sent without tools.
+	if c.cfg.NoStream {
+		tools = plugin.NewRegistry()
+	}
+	noteIgnored(c.cfg, c.stderr)
 	switch c.cfg.Provider {
 	case "openai":
-		return streamOpenAI(ctx, c.cfg, prompt, out, c.stderr, c.tools)
+		return streamOpenAI(ctx, c.cfg, c.history, prompt, out, c.stderr, tools)
 	case "anthropic":
-		return streamAnthropic(ctx, c.cfg, prompt, out, c.stderr, c.tools)
+		return streamAnthropic(ctx, c.cfg, c.history, prompt, out, c.stderr, tools)
 	case "gemini":
-		return streamGemini(ctx, c.cfg, prompt, out, c.stderr, c.tools)
+		return streamGemini(ctx, c.cfg, c.history, prompt, out, c.stderr, tools)
+	case "openai-compatible":
+		return streamOpenAICompatible(ctx, c.cfg, c.history, prompt, out, c.stderr, tools)
 	default:
 		return errors.New("unknown provider: " + c.cfg.Provider)
 	}
 }
 
-func apiKey(env string) (string, error) {
-	v := os.Getenv(env)
-	if v == "" {
-		return "", errors.New("missing " + env)
+// noteIgnored notes under Debug each set option that only another
+// provider understands, since the active provider skips it silently.
+func noteIgnored(cfg config.Config, stderr io.Writer) {
+	if !cfg.Debug || stderr == nil {
+		return
+	}
+	for _, o := range []struct {
+		flag, provider string
+		set            bool
+	}{
+		{"--thinking-budget", "gemini", cfg.ThinkingBudget != nil},
+		{"--reasoning-effort", "openai", cfg.ReasoningEffort != ""},
+	} {
+		if o.set && cfg.Provider != o.provider {
+			fmt.Fprintf(stderr, "%s: %s only applies to %s; ignored\n", cfg.Provider, o.flag, o.provider)
+		}
+	}
+}
+
+// maxToolRounds returns how many rounds of tool calls a loop may execute.
+func maxToolRounds(cfg config.Config) int {
+	if cfg.MaxToolRounds > 0 {
+		return cfg.MaxToolRounds
+	}
+	return config.DefaultMaxToolRounds
+}
+
+// roundConfig returns the config for the request that follows tool round
+// round (counted from 0). The request after the last permitted round is
+// the one that must produce the final answer, so it uses FinalTemperature
+// when set, sending it even when it is 0.
+func roundConfig(cfg config.Config, round int) config.Config {
+	if cfg.FinalTemperature != nil && round+1 == maxToolRounds(cfg) {
+		cfg.Temperature = *cfg.FinalTemperature
+		cfg.ExplicitTemperature = true
+	}
+	return cfg
+}
+
+// requestTemperature returns the temperature to send, or nil to leave the
+// provider default: a zero Temperature is only sent when it was set
+// explicitly.
+func requestTemperature(cfg config.Config) *float64 {
+	if cfg.Temperature == 0 && !cfg.ExplicitTemperature {
+		return nil
+	}
+	t := cfg.Temperature
+	return &t
+}
+
+// requestContext bounds a single provider call by cfg.RequestTimeout. The
+// overall --timeout is already on ctx and still covers the whole run.
+func requestContext(ctx context.Context, cfg config.Config) (context.Context, context.CancelFunc) {
+	if cfg.RequestTimeout > 0 {
+		return context.WithTimeoutCause(ctx, cfg.RequestTimeout, errRequestTimeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// noteToolRounds tells the user the loop hit the round cap while the model
+// was still asking for tools, so the answer may be unfinished.
+func noteToolRounds(stderr io.Writer, rounds int) {
+	if stderr != nil {
+		fmt.Fprintf(stderr, "note: stopped after %d tool rounds\n", rounds)
+	}
+}
+
+// setHeaders adds the configured custom headers to req. They are set last,
+// so a custom header replaces a built-in one of the same name.
+func setHeaders(req *http.Request, cfg config.Config) {
+	for k, v := range cfg.Headers {
+		req.Header.Set(k, v)
+	}
+}
+
+// requestIDHeaders are the response headers providers put request IDs in:
+// x-request-id (OpenAI and most compatible servers), request-id
+// (Anthropic), x-goog-request-id (Google).
+var requestIDHeaders = []string{"x-request-id", "request-id", "x-goog-request-id"}
+
+// requestID returns the provider's ID for the request behind h, if any.
+func requestID(h http.Header) string {
+	for _, name := range requestIDHeaders {
+		if v := h.Get(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// checkResponse returns resp's request ID, logging it under Debug, and an
+// error carrying the provider's message and the ID when the status is not
+// 2xx.
+func checkResponse(cfg config.Config, resp *http.Response, stderr io.Writer) (string, error) {
+	id := requestID(resp.Header)
+	if cfg.Debug && id != "" && stderr != nil {
+		fmt.Fprintf(stderr, "%s request id: %s\n", cfg.Provider, id)
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return id, &ProviderError{
+			Kind:       statusKind(resp.StatusCode),
+			StatusCode: resp.StatusCode,
+			Message:    apiErrorMessage(cfg.Provider, body),
+			RequestID:  id,
+		}
+	}
+	return id, nil
+}
+
+// withRequestID appends the provider request ID to err so it can be quoted
+// to the provider's support.
+func withRequestID(err error, id string) error {
+	if err == nil || id == "" {
+		return err
+	}
+	var pe *ProviderError
+	if errors.As(err, &pe) && pe.RequestID == "" {
+		pe.RequestID = id
+		return err
+	}
+	return fmt.Errorf("%w (request id %s)", err, id)
+}
+
+// keyExamples are the placeholder values shown in missing key hints.
+var keyExamples = map[string]string{
+	"OPENAI_API_KEY":    "sk-...",
+	"ANTHROPIC_API_KEY": "sk-ant-...",
+	"GEMINI_API_KEY":    "AIza...",
+}
+
+// keyringGet is replaced in tests so they never touch the real keyring.
+var keyringGet = keyring.Get
+
+// apiKey returns the first of envs that is set, then the config file's
+// api_keys entry for the provider, then the key stored in the OS keyring by
+// --set-key, or an error explaining how to provide the key. The keyring
+// comes last because asking it launches a helper process; one that is
+// missing, locked or slow counts as holding no key.
+func apiKey(cfg config.Config, envs ...string) (string, error) {
+	for _, env := range envs {
+		if v := os.Getenv(env); v != "" {
+			return v, nil
+		}
+	}
+	if v := cfg.APIKeys[cfg.Provider]; v != "" {
+		return v, nil
+	}
+	if v, err := keyringGet(cfg.Provider); err == nil {
+		return v, nil
+	}
+	return "", missingKeyError(cfg.Provider, envs)
+}
+
+func missingKeyError(provider string, envs []string) error {
+	example := keyExamples[envs[0]]
+	if example == "" {
+		example = "..."
+	}
+	var b strings.Builder
+	cfgPath := "~/.config/gogo/config.json"
+	if dir, err := config.Dir(); err == nil {
+		cfgPath = filepath.Join(dir, "config.json")
+	}
+	fmt.Fprintf(&b, "missing %s: the %s provider needs an API key", strings.Join(envs, " or "), provider)
+	fmt.Fprintf(&b, "\n  export %s=%s", envs[0], example)
+	fmt.Fprintf(&b, "\nor store it in the OS keyring with gogo --set-key %s", provider)
+	fmt.Fprintf(&b, "\nor add it to %s as \"api_keys\": {\"%s\": \"%s\"}", cfgPath, provider, example)
+	fmt.Fprintf(&b, "\nto use another provider, pass -P or set \"provider\" in the same file")
+	return errors.New(b.String())
+}
+
+// marshalRequest encodes a provider request body and applies the configured
+// parameter remapping.
+func marshalRequest(cfg config.Config, v any) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.ParamMap) == 0 {
+		return b, nil
+	}
+	return remapParams(b, cfg.ParamMap)
+}
+
+// remapParams renames top-level keys of a JSON object according to m.
+func remapParams(b []byte, m map[string]string) ([]byte, error) {
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(b, &body); err != nil {
+		return nil, err
+	}
+	for from, to := range m {
+		if from == to || to == "" {
+			continue
+		}
+		if v, ok := body[from]; ok {
+			delete(body, from)
+			body[to] = v
+		}
 	}
-	return v, nil
+	return json.Marshal(body)
 }
@@ -0,0 +1,1727 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gogo/internal/budget"
+	"gogo/internal/config"
+	"gogo/internal/history"
+	"gogo/internal/keyring"
+	"gogo/internal/plugin"
+)
+
+// TestMain keeps the tests away from the developer's real keyring.
+func TestMain(m *testing.M) {
+	keyringGet = func(string) (string, error) { return "", keyring.ErrNotFound }
+	os.Exit(m.Run())
+}
+
+func TestMarshalRequestParamMap(t *testing.T) {
+	cfg := config.Config{
+		ParamMap: map[string]string{"max_output_tokens": "max_completion_tokens"},
+	}
+	b, err := marshalRequest(cfg, openAIRequest{Model: "m", MaxOutputTokens: 100})
+	if err != nil {
+		t.Fatalf("marshalRequest returned error: %v", err)
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(b, &body); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := body["max_output_tokens"]; ok {
+		t.Fatal("original key should have been removed")
+	}
+	if body["max_completion_tokens"] != float64(100) {
+		t.Fatalf("expected remapped key with value 100, got %v", body["max_completion_tokens"])
+	}
+	if body["model"] != "m" {
+		t.Fatalf("unrelated keys should be preserved, got model=%v", body["model"])
+	}
+}
+
+func TestMarshalRequestNoParamMap(t *testing.T) {
+	b, err := marshalRequest(config.Config{}, openAIRequest{Model: "m", MaxOutputTokens: 100})
+	if err != nil {
+		t.Fatalf("marshalRequest returned error: %v", err)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(b, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["max_output_tokens"] != float64(100) {
+		t.Fatalf("expected original key, got %v", body)
+	}
+}
+
+func TestSystemInstruction(t *testing.T) {
+	empty := plugin.NewRegistry()
+	tools := plugin.NewRegistry()
+	tools.Register(&plugin.Tool{Name: "t", Description: "d", Type: "http", URL: "http://x"})
+
+	if got := systemInstruction(config.Config{}, empty); got != "" {
+		t.Fatalf("expected empty instruction, got %q", got)
+	}
+	if got := systemInstruction(config.Config{System: "be brief"}, empty); got != "be brief" {
+		t.Fatalf("expected custom prompt only, got %q", got)
+	}
+	got := systemInstruction(config.Config{System: "be brief"}, tools)
+	if !strings.HasPrefix(got, "be brief\n\n") || !strings.Contains(got, tools.GenerateInstruction()) {
+		t.Fatalf("expected custom prompt followed by tool instruction, got %q", got)
+	}
+	got = systemInstruction(config.Config{AppendSystem: "never delete files"}, tools)
+	if got != tools.GenerateInstruction()+"\n\nnever delete files" {
+		t.Fatalf("expected tool instruction followed by appended prompt, got %q", got)
+	}
+	if got := systemInstruction(config.Config{AppendSystem: "never delete files"}, empty); got != "never delete files" {
+		t.Fatalf("expected appended prompt only, got %q", got)
+	}
+}
+
+func TestHistoryConversion(t *testing.T) {
+	hist := []history.Message{
+		{Role: "user", Content: "hi"},
+		{Role: "assistant", Content: "hello"},
+	}
+
+	openai, _ := json.Marshal(openAIHistory(hist))
+	wantOpenAI := `[{"content":[{"text":"hi","type":"input_text"}],"role":"user"},{"content":[{"text":"hello","type":"output_text"}],"role":"assistant"}]`
+	if string(openai) != wantOpenAI {
+		t.Errorf("openai history:\n got %s\nwant %s", openai, wantOpenAI)
+	}
+
+	anthropic, _ := json.Marshal(anthropicHistory(hist))
+	wantAnthropic := `[{"content":[{"text":"hi","type":"text"}],"role":"user"},{"content":[{"text":"hello","type":"text"}],"role":"assistant"}]`
+	if string(anthropic) != wantAnthropic {
+		t.Errorf("anthropic history:\n got %s\nwant %s", anthropic, wantAnthropic)
+	}
+
+	gemini, _ := json.Marshal(geminiHistory(hist))
+	wantGemini := `[{"role":"user","parts":[{"text":"hi"}]},{"role":"model","parts":[{"text":"hello"}]}]`
+	if string(gemini) != wantGemini {
+		t.Errorf("gemini history:\n got %s\nwant %s", gemini, wantGemini)
+	}
+}
+
+func TestToolHistoryConversion(t *testing.T) {
+	hist := []history.Message{
+		{Role: "user", Content: "weather?"},
+		{Role: "assistant", ToolCalls: []history.ToolCall{{ID: "call_1", Name: "weather", Input: json.RawMessage(`{"city":"Oslo"}`)}}},
+		{Role: "tool", ToolCallID: "call_1", Name: "weather", Content: `{"ok":true}`},
+		{Role: "assistant", Content: "sunny"},
+	}
+
+	openai, _ := json.Marshal(openAIHistory(hist))
+	for _, want := range []string{
+		`{"arguments":"{\"city\":\"Oslo\"}","call_id":"call_1","name":"weather","type":"function_call"}`,
+		`{"call_id":"call_1","output":"{\"ok\":true}","type":"function_call_output"}`,
+	} {
+		if !strings.Contains(string(openai), want) {
+			t.Errorf("openai history missing %s: %s", want, openai)
+		}
+	}
+
+	anthropic, _ := json.Marshal(anthropicHistory(hist))
+	for _, want := range []string{
+		`{"content":[{"id":"call_1","input":{"city":"Oslo"},"name":"weather","type":"tool_use"}],"role":"assistant"}`,
+		`{"content":[{"content":[{"text":"{\"ok\":true}","type":"text"}],"tool_use_id":"call_1","type":"tool_result"}],"role":"user"}`,
+	} {
+		if !strings.Contains(string(anthropic), want) {
+			t.Errorf("anthropic history missing %s: %s", want, anthropic)
+		}
+	}
+
+	gemini, _ := json.Marshal(geminiHistory(hist))
+	for _, want := range []string{
+		`{"role":"model","parts":[{"functionCall":{"name":"weather","args":{"city":"Oslo"}}}]}`,
+		`{"role":"function","parts":[{"functionResponse":{"name":"weather","response":{"result":{"ok":true}}}}]}`,
+	} {
+		if !strings.Contains(string(gemini), want) {
+			t.Errorf("gemini history missing %s: %s", want, gemini)
+		}
+	}
+
+	chat, _ := json.Marshal(chatHistory(hist))
+	for _, want := range []string{
+		`{"role":"assistant","content":"","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Oslo\"}"}}]}`,
+		`{"role":"tool","content":"{\"ok\":true}","tool_call_id":"call_1"}`,
+	} {
+		if !strings.Contains(string(chat), want) {
+			t.Errorf("chat history missing %s: %s", want, chat)
+		}
+	}
+}
+
+func TestOpenAIInstructions(t *testing.T) {
+	var bodies [][]byte
+	srv := sseServer(t, &bodies, `{"type":"response.output_text.delta","delta":"ok"}`)
+	orig := openAIURL
+	openAIURL = srv.URL
+	defer func() { openAIURL = orig }()
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	cfg := config.Config{Provider: "openai", Model: "gpt-4o-mini", System: "be brief"}
+	if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	var req struct {
+		Instructions string           `json:"instructions"`
+		Input        []map[string]any `json:"input"`
+	}
+	if err := json.Unmarshal(bodies[0], &req); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(req.Instructions, "be brief") {
+		t.Errorf("instructions not set: %q", req.Instructions)
+	}
+	if len(req.Input) != 1 || req.Input[0]["role"] != "user" {
+		t.Errorf("expected the prompt as the only input item, got %v", req.Input)
+	}
+	if strings.Contains(string(bodies[0]), `"role":"system"`) {
+		t.Errorf("request still has a system input item: %s", bodies[0])
+	}
+}
+
+func TestOpenAIContinue(t *testing.T) {
+	var bodies [][]byte
+	srv := sseServer(t, &bodies,
+		`{"type":"response.created","response":{"id":"resp_2"}}`,
+		`{"type":"response.output_text.delta","delta":"more"}`,
+	)
+	orig := openAIURL
+	openAIURL = srv.URL
+	defer func() { openAIURL = orig }()
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	cfg := config.Config{Provider: "openai", Model: "gpt-4o-mini", ContinueID: "resp_1"}
+	var stdout, stderr bytes.Buffer
+	if err := NewClient(cfg, &stderr, plugin.NewRegistry()).Stream(context.Background(), "more", &stdout); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if !strings.Contains(string(bodies[0]), `"previous_response_id":"resp_1"`) {
+		t.Errorf("continue ID not sent: %s", bodies[0])
+	}
+	if stderr.String() != "response id: resp_2\n" {
+		t.Errorf("unexpected stderr: %q", stderr.String())
+	}
+	if stdout.String() != "more" {
+		t.Errorf("response ID leaked into stdout: %q", stdout.String())
+	}
+}
+
+func TestOpenAIReasoningEffort(t *testing.T) {
+	var bodies [][]byte
+	srv := sseServer(t, &bodies, `{"type":"response.output_text.delta","delta":"ok"}`)
+	orig := openAIURL
+	openAIURL = srv.URL
+	defer func() { openAIURL = orig }()
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	cfg := config.Config{Provider: "openai", Model: "o3-mini", ReasoningEffort: "high"}
+	if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if !strings.Contains(string(bodies[0]), `"reasoning":{"effort":"high"}`) {
+		t.Errorf("reasoning effort not sent: %s", bodies[0])
+	}
+
+	cfg.ReasoningEffort = ""
+	if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if strings.Contains(string(bodies[1]), `"reasoning"`) {
+		t.Errorf("unset reasoning effort sent: %s", bodies[1])
+	}
+}
+
+func TestOpenAIReasoningModelOmitsTemperature(t *testing.T) {
+	var bodies [][]byte
+	srv := sseServer(t, &bodies, `{"type":"response.output_text.delta","delta":"ok"}`)
+	orig := openAIURL
+	openAIURL = srv.URL
+	defer func() { openAIURL = orig }()
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	cfg := config.Config{Provider: "openai", Model: "o3-mini", Temperature: 0.7, TopP: 0.9}
+	if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if b := string(bodies[0]); strings.Contains(b, "temperature") || strings.Contains(b, "top_p") {
+		t.Errorf("sampling params sent to a reasoning model: %s", b)
+	}
+}
+
+// sseServer returns a test server that records each request body and replies
+// with the given SSE data payloads.
+func sseServer(t *testing.T, bodies *[][]byte, events ...string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if bodies != nil {
+			*bodies = append(*bodies, b)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, e := range events {
+			fmt.Fprintf(w, "data: %s\n\n", e)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDumpMessages(t *testing.T) {
+	srv := sseServer(t, nil, `{"type":"response.output_text.delta","delta":"ok"}`)
+	orig := openAIURL
+	openAIURL = srv.URL
+	defer func() { openAIURL = orig }()
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	cfg := config.Config{Provider: "openai", Model: "gpt-4o-mini", System: "be brief", DumpMessages: true}
+	var stdout, stderr bytes.Buffer
+	client := NewClient(cfg, &stderr, plugin.NewRegistry())
+	client.SetHistory([]history.Message{
+		{Role: "user", Content: "earlier"},
+		{Role: "assistant", Content: "reply"},
+	})
+	if err := client.Stream(context.Background(), "now", &stdout); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+
+	var dump struct {
+		Instructions string `json:"instructions"`
+		Input        []struct {
+			Role    string `json:"role"`
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"input"`
+	}
+	if err := json.Unmarshal(stderr.Bytes(), &dump); err != nil {
+		t.Fatalf("stderr is not a JSON request dump: %v\n%s", err, stderr.String())
+	}
+	if !strings.HasPrefix(dump.Instructions, "be brief") {
+		t.Errorf("unexpected instructions: %q", dump.Instructions)
+	}
+	dumped := dump.Input
+	want := [][2]string{{"user", "earlier"}, {"assistant", "reply"}, {"user", "now"}}
+	if len(dumped) != len(want) {
+		t.Fatalf("expected %d messages, got %d", len(want), len(dumped))
+	}
+	for i, w := range want {
+		if dumped[i].Role != w[0] || dumped[i].Content[0].Text != w[1] {
+			t.Errorf("message %d = %s %q, want %s %q", i, dumped[i].Role, dumped[i].Content[0].Text, w[0], w[1])
+		}
+	}
+	if stdout.String() != "ok" {
+		t.Fatalf("unexpected stdout: %q", stdout.String())
+	}
+}
+
+func TestBudgetStopsToolLoop(t *testing.T) {
+	toolSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer toolSrv.Close()
+
+	var bodies [][]byte
+	srv := sseServer(t, &bodies,
+		`{"type":"response.created","response":{"id":"r1"}}`,
+		`{"type":"response.output_item.added","item":{"id":"fc1","type":"function_call","call_id":"c1","name":"lookup","arguments":"{}"}}`,
+		`{"type":"response.completed","response":{"usage":{"input_tokens":10,"output_tokens":5}}}`,
+	)
+	orig := openAIURL
+	openAIURL = srv.URL
+	defer func() { openAIURL = orig }()
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	tools := plugin.NewRegistry()
+	tools.Register(&plugin.Tool{Name: "lookup", Description: "d", Type: "http", URL: toolSrv.URL})
+
+	cfg := config.Config{Provider: "openai", Model: "gpt-4o-mini", Budget: budget.New(1, 0)}
+	var stdout bytes.Buffer
+	err := NewClient(cfg, io.Discard, tools).Stream(context.Background(), "hi", &stdout)
+	if !errors.Is(err, budget.ErrExceeded) {
+		t.Fatalf("expected budget.ErrExceeded, got %v", err)
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("expected exactly 1 API call, got %d", len(bodies))
+	}
+	calls, usd := cfg.Budget.Spent()
+	if calls != 1 || usd <= 0 {
+		t.Fatalf("expected usage recorded for 1 call, got calls=%d usd=%v", calls, usd)
+	}
+}
+
+func TestLogToolResultJSON(t *testing.T) {
+	var buf bytes.Buffer
+	res := plugin.Result{OK: false, Error: "boom"}
+	logToolResult(&buf, "json", "openai", "fs", `{"op":"read"}`, res, 1500*time.Millisecond)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %q", buf.String())
+	}
+	if entry["tool"] != "fs" || entry["provider"] != "openai" || entry["ok"] != false || entry["error"] != "boom" {
+		t.Fatalf("unexpected entry: %v", entry)
+	}
+	if entry["duration_ms"] != float64(1500) {
+		t.Fatalf("unexpected duration: %v", entry["duration_ms"])
+	}
+	if input, ok := entry["input"].(map[string]any); !ok || input["op"] != "read" {
+		t.Fatalf("input should be embedded as JSON: %v", entry["input"])
+	}
+
+	buf.Reset()
+	logToolResult(&buf, "json", "gemini", "x", "not json", plugin.Result{OK: true}, 0)
+	if !strings.Contains(buf.String(), `"input":"not json"`) {
+		t.Fatalf("non-JSON input should be quoted: %q", buf.String())
+	}
+}
+
+func TestStreamEvents(t *testing.T) {
+	toolSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer toolSrv.Close()
+
+	srv := sseServer(t, nil,
+		`{"type":"response.output_text.delta","delta":"Hi"}`,
+		`{"type":"response.output_item.added","item":{"id":"fc1","type":"function_call","call_id":"c1","name":"lookup","arguments":"{\"q\":1}"}}`,
+		`{"type":"response.completed","response":{"usage":{"input_tokens":10,"output_tokens":5}}}`,
+	)
+	orig := openAIURL
+	openAIURL = srv.URL
+	defer func() { openAIURL = orig }()
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	tools := plugin.NewRegistry()
+	tools.Register(&plugin.Tool{Name: "lookup", Description: "d", Type: "http", URL: toolSrv.URL})
+
+	cfg := config.Config{Provider: "openai", Model: "gpt-4o-mini"}
+	var events, text bytes.Buffer
+	client := NewClient(cfg, io.Discard, tools)
+	client.SetEvents(&events)
+	if err := client.Stream(context.Background(), "hi", &text); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+
+	var got []Event
+	for _, line := range strings.Split(strings.TrimSpace(events.String()), "\n") {
+		var ev Event
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("invalid event line %q: %v", line, err)
+		}
+		got = append(got, ev)
+	}
+
+	var types []string
+	for _, ev := range got {
+		types = append(types, ev.Type)
+	}
+	want := "text,tool_call,tool_result,text,done"
+	if strings.Join(types, ",") != want {
+		t.Fatalf("event types = %v, want %s", types, want)
+	}
+	if string(got[1].Input) != `{"q":1}` || got[1].Tool != "lookup" {
+		t.Fatalf("unexpected tool_call event: %+v", got[1])
+	}
+	if got[2].Result == nil || !got[2].Result.OK {
+		t.Fatalf("unexpected tool_result event: %+v", got[2])
+	}
+	if u := got[4].Usage; u == nil || u.InputTokens != 20 || u.OutputTokens != 10 {
+		t.Fatalf("done usage should total both calls: %+v", got[4].Usage)
+	}
+	if text.String() != "HiHi" {
+		t.Fatalf("plain text should still reach out: %q", text.String())
+	}
+}
+
+func TestOpenAICompatibleStream(t *testing.T) {
+	toolSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer toolSrv.Close()
+
+	var bodies [][]byte
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, b)
+		paths = append(paths, r.URL.Path)
+		w.Header().Set("Content-Type", "text/event-stream")
+		if len(bodies) == 1 {
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"function\":{\"name\":\"lookup\",\"arguments\":\"{\\\"q\\\"\"}}]}}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\":1}\"}}]}}]}\n\n")
+		} else {
+			fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n")
+		}
+		fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+	t.Setenv("GROQ_API_KEY", "test")
+
+	tools := plugin.NewRegistry()
+	tools.Register(&plugin.Tool{Name: "lookup", Description: "d", Type: "http", URL: toolSrv.URL})
+
+	cfg := config.Config{
+		Provider:    "openai-compatible",
+		Model:       "o3-mini",
+		MaxTokens:   100,
+		BaseURL:     srv.URL + "/v1/",
+		APIKeyEnv:   "GROQ_API_KEY",
+		CompatTools: true,
+	}
+	var stdout bytes.Buffer
+	if err := NewClient(cfg, io.Discard, tools).Stream(context.Background(), "hi", &stdout); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if stdout.String() != "Hello" {
+		t.Fatalf("unexpected output: %q", stdout.String())
+	}
+	if len(paths) != 2 || paths[0] != "/v1/chat/completions" {
+		t.Fatalf("unexpected request paths: %v", paths)
+	}
+
+	var first map[string]any
+	json.Unmarshal(bodies[0], &first)
+	if _, ok := first["max_completion_tokens"]; !ok {
+		t.Fatalf("o3 models should use max_completion_tokens: %s", bodies[0])
+	}
+	if _, ok := first["tools"]; !ok {
+		t.Fatalf("tools should be sent when supported: %s", bodies[0])
+	}
+	if !strings.Contains(string(bodies[1]), `"tool_call_id":"call_1"`) || !strings.Contains(string(bodies[1]), `"arguments":"{\"q\":1}"`) {
+		t.Fatalf("second request missing assembled tool call: %s", bodies[1])
+	}
+}
+
+func TestOpenAICompatibleNoToolsByDefault(t *testing.T) {
+	var bodies [][]byte
+	srv := sseServer(t, &bodies, `{"choices":[{"delta":{"content":"ok"}}]}`, "[DONE]")
+	t.Setenv(DefaultCompatKeyEnv, "test")
+
+	tools := plugin.NewRegistry()
+	tools.Register(&plugin.Tool{Name: "lookup", Description: "d", Type: "http", URL: "http://example.com"})
+
+	cfg := config.Config{Provider: "openai-compatible", Model: "llama", BaseURL: srv.URL}
+	if err := NewClient(cfg, io.Discard, tools).Stream(context.Background(), "hi", io.Discard); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if strings.Contains(string(bodies[0]), `"tools"`) || strings.Contains(string(bodies[0]), "lookup") {
+		t.Fatalf("tools sent without supports_tools: %s", bodies[0])
+	}
+}
+
+func TestGeminiBlockedResponse(t *testing.T) {
+	tests := []struct {
+		name  string
+		event string
+		want  string
+	}{
+		{"prompt blocked", `{"promptFeedback":{"blockReason":"SAFETY"}}`, "blocked the prompt (blockReason SAFETY)"},
+		{"response blocked", `{"candidates":[{"content":{"parts":[{"text":"par"}]},"finishReason":"SAFETY"}]}`, "blocked the response (finishReason SAFETY)"},
+		{"truncated", `{"candidates":[{"content":{"parts":[{"text":"par"}]},"finishReason":"MAX_TOKENS"}]}`, "output token limit"},
+	}
+	t.Setenv("GEMINI_API_KEY", "test")
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := sseServer(t, nil, tc.event)
+			orig := geminiBase
+			geminiBase = srv.URL + "/"
+			defer func() { geminiBase = orig }()
+
+			cfg := config.Config{Provider: "gemini", Model: "gemini-1.5-flash"}
+			err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard)
+			if !errors.Is(err, ErrIncomplete) || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected ErrIncomplete containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+
+	srv := sseServer(t, nil, `{"candidates":[{"content":{"parts":[{"text":"done"}]},"finishReason":"STOP"}]}`)
+	orig := geminiBase
+	geminiBase = srv.URL + "/"
+	defer func() { geminiBase = orig }()
+	cfg := config.Config{Provider: "gemini", Model: "gemini-1.5-flash"}
+	if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+		t.Fatalf("STOP should succeed, got %v", err)
+	}
+}
+
+func TestOpenAIStreamErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		event      string
+		want       string
+		incomplete bool
+	}{
+		{"incomplete", `{"type":"response.incomplete","response":{"status":"incomplete","incomplete_details":{"reason":"max_output_tokens"}}}`, "reason max_output_tokens", true},
+		{"error event", `{"type":"error","code":"server_error","message":"boom"}`, "boom (server_error)", false},
+		{"failed", `{"type":"response.failed","response":{"status":"failed","error":{"code":"rate_limit_exceeded","message":"slow down"}}}`, "slow down (rate_limit_exceeded)", false},
+	}
+	t.Setenv("OPENAI_API_KEY", "test")
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := sseServer(t, nil, `{"type":"response.output_text.delta","delta":"part"}`, tc.event)
+			orig := openAIURL
+			openAIURL = srv.URL
+			defer func() { openAIURL = orig }()
+
+			cfg := config.Config{Provider: "openai", Model: "gpt-4o-mini"}
+			var stdout bytes.Buffer
+			err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", &stdout)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected error containing %q, got %v", tc.want, err)
+			}
+			if errors.Is(err, ErrIncomplete) != tc.incomplete {
+				t.Fatalf("errors.Is(err, ErrIncomplete) = %v, want %v", !tc.incomplete, tc.incomplete)
+			}
+			if stdout.String() != "part" {
+				t.Fatalf("partial output should still be written: %q", stdout.String())
+			}
+		})
+	}
+}
+
+func TestEmptyResponse(t *testing.T) {
+	tests := []struct {
+		provider string
+		setup    func(url string) func()
+		text     string
+		// finish ends a response without text, e.g. on a stop sequence.
+		finish string
+	}{
+		{"openai", func(u string) func() {
+			orig := openAIURL
+			openAIURL = u
+			return func() { openAIURL = orig }
+		}, `{"type":"response.output_text.delta","delta":"ok"}`,
+			`{"type":"response.completed","response":{"usage":{"input_tokens":1,"output_tokens":0}}}`},
+		{"anthropic", func(u string) func() {
+			orig := anthropicURL
+			anthropicURL = u
+			return func() { anthropicURL = orig }
+		}, `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ok"}}`,
+			`{"type":"message_delta","delta":{"stop_reason":"stop_sequence"},"usage":{"output_tokens":0}}`},
+		{"gemini", func(u string) func() {
+			orig := geminiBase
+			geminiBase = u + "/"
+			return func() { geminiBase = orig }
+		}, `{"candidates":[{"content":{"parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`,
+			`{"candidates":[{"content":{"parts":[]},"finishReason":"STOP"}]}`},
+		{"openai-compatible", func(string) func() { return func() {} }, `{"choices":[{"delta":{"content":"ok"}}]}`,
+			`{"choices":[{"delta":{},"finish_reason":"content_filter"}]}`},
+	}
+	t.Setenv("OPENAI_API_KEY", "test")
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+	t.Setenv("GEMINI_API_KEY", "test")
+	t.Setenv(DefaultCompatKeyEnv, "test")
+	origDelay := emptyRetryDelay
+	emptyRetryDelay = 0
+	defer func() { emptyRetryDelay = origDelay }()
+
+	for _, tc := range tests {
+		t.Run(tc.provider, func(t *testing.T) {
+			// The first emptyReplies requests get a 200 with an empty stream,
+			// or with only a terminal event when finished is set.
+			var requests, emptyReplies int
+			var finished bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				w.Header().Set("Content-Type", "text/event-stream")
+				switch {
+				case requests > emptyReplies:
+					fmt.Fprintf(w, "data: %s\n\n", tc.text)
+				case finished:
+					fmt.Fprintf(w, "data: %s\n\n", tc.finish)
+				}
+			}))
+			defer srv.Close()
+			defer tc.setup(srv.URL)()
+			cfg := config.Config{Provider: tc.provider, Model: "m", BaseURL: srv.URL}
+
+			emptyReplies = 1
+			var out bytes.Buffer
+			if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", &out); err != nil {
+				t.Fatalf("Stream returned error: %v", err)
+			}
+			if requests != 2 || out.String() != "ok" {
+				t.Fatalf("expected one retry ending in %q, got %d requests and %q", "ok", requests, out.String())
+			}
+
+			requests, emptyReplies = 0, 2
+			err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard)
+			var pe *ProviderError
+			if !errors.Is(err, ErrEmptyResponse) || !errors.As(err, &pe) || !pe.Retryable() {
+				t.Fatalf("expected a retryable empty response error, got %v", err)
+			}
+			if requests != 2 {
+				t.Fatalf("expected exactly one retry, got %d requests", requests)
+			}
+
+			requests, emptyReplies, finished = 0, 1, true
+			out.Reset()
+			if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", &out); err != nil {
+				t.Fatalf("a finished empty response should not fail, got %v", err)
+			}
+			if requests != 1 || out.Len() != 0 {
+				t.Fatalf("a finished empty response should not be retried, got %d requests and %q", requests, out.String())
+			}
+		})
+	}
+}
+
+func TestStopSequences(t *testing.T) {
+	stop := []string{"END", "\n\n"}
+	tests := []struct {
+		provider string
+		setup    func(url string) func()
+		event    string
+		want     string
+	}{
+		{"anthropic", func(u string) func() {
+			orig := anthropicURL
+			anthropicURL = u
+			return func() { anthropicURL = orig }
+		}, `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ok"}}`, `"stop_sequences":["END","\n\n"]`},
+		{"gemini", func(u string) func() {
+			orig := geminiBase
+			geminiBase = u + "/"
+			return func() { geminiBase = orig }
+		}, `{"candidates":[{"content":{"parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`, `"stopSequences":["END","\n\n"]`},
+		{"openai-compatible", func(string) func() { return func() {} }, `{"choices":[{"delta":{"content":"ok"}}]}`, `"stop":["END","\n\n"]`},
+	}
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+	t.Setenv("GEMINI_API_KEY", "test")
+	t.Setenv(DefaultCompatKeyEnv, "test")
+
+	for _, tc := range tests {
+		t.Run(tc.provider, func(t *testing.T) {
+			var bodies [][]byte
+			srv := sseServer(t, &bodies, tc.event)
+			defer tc.setup(srv.URL)()
+
+			cfg := config.Config{Provider: tc.provider, Model: "m", BaseURL: srv.URL, Stop: stop}
+			if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+				t.Fatalf("Stream returned error: %v", err)
+			}
+			if len(bodies) != 1 || !strings.Contains(string(bodies[0]), tc.want) {
+				t.Fatalf("request body missing %s: %s", tc.want, bodies)
+			}
+		})
+	}
+
+	t.Setenv("OPENAI_API_KEY", "test")
+	cfg := config.Config{Provider: "openai", Model: "gpt-4o-mini", Stop: stop}
+	if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err == nil {
+		t.Fatal("expected openai to reject stop sequences")
+	}
+}
+
+func TestSamplingParams(t *testing.T) {
+	tests := []struct {
+		provider string
+		setup    func(url string) func()
+		event    string
+		want     []string
+	}{
+		{"openai", func(u string) func() {
+			orig := openAIURL
+			openAIURL = u
+			return func() { openAIURL = orig }
+		}, `{"type":"response.output_text.delta","delta":"ok"}`, []string{`"top_p":0.9`}},
+		{"anthropic", func(u string) func() {
+			orig := anthropicURL
+			anthropicURL = u
+			return func() { anthropicURL = orig }
+		}, `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ok"}}`, []string{`"top_p":0.9`, `"top_k":40`}},
+		{"gemini", func(u string) func() {
+			orig := geminiBase
+			geminiBase = u + "/"
+			return func() { geminiBase = orig }
+		}, `{"candidates":[{"content":{"parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`, []string{`"topP":0.9`, `"topK":40`}},
+		{"openai-compatible", func(string) func() { return func() {} }, `{"choices":[{"delta":{"content":"ok"}}]}`, []string{`"top_p":0.9`, `"top_k":40`}},
+	}
+	t.Setenv("OPENAI_API_KEY", "test")
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+	t.Setenv("GEMINI_API_KEY", "test")
+	t.Setenv(DefaultCompatKeyEnv, "test")
+
+	for _, tc := range tests {
+		t.Run(tc.provider, func(t *testing.T) {
+			var bodies [][]byte
+			srv := sseServer(t, &bodies, tc.event)
+			defer tc.setup(srv.URL)()
+
+			topK := 40
+			if tc.provider == "openai" {
+				topK = 0
+			}
+			cfg := config.Config{Provider: tc.provider, Model: "m", BaseURL: srv.URL, TopP: 0.9, TopK: topK}
+			if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+				t.Fatalf("Stream returned error: %v", err)
+			}
+			for _, want := range tc.want {
+				if !strings.Contains(string(bodies[0]), want) {
+					t.Errorf("request body missing %s: %s", want, bodies[0])
+				}
+			}
+
+			// Unset values must not be sent so provider defaults apply.
+			cfg.TopP, cfg.TopK = 0, 0
+			if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+				t.Fatalf("Stream returned error: %v", err)
+			}
+			if b := strings.ToLower(string(bodies[1])); strings.Contains(b, "top_") || strings.Contains(b, "topp") || strings.Contains(b, "topk") {
+				t.Errorf("unset sampling params sent: %s", bodies[1])
+			}
+		})
+	}
+}
+
+func TestRawRequest(t *testing.T) {
+	tests := []struct {
+		provider string
+		setup    func(url string) func()
+		event    string
+	}{
+		{"openai", func(u string) func() {
+			orig := openAIURL
+			openAIURL = u
+			return func() { openAIURL = orig }
+		}, `{"type":"response.output_text.delta","delta":"ok"}`},
+		{"anthropic", func(u string) func() {
+			orig := anthropicURL
+			anthropicURL = u
+			return func() { anthropicURL = orig }
+		}, `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ok"}}`},
+		{"gemini", func(u string) func() {
+			orig := geminiBase
+			geminiBase = u + "/"
+			return func() { geminiBase = orig }
+		}, `{"candidates":[{"content":{"parts":[{"text":"ok"}]},"finishReason":"STOP"}]}`},
+		{"openai-compatible", func(string) func() { return func() {} }, `{"choices":[{"delta":{"content":"ok"}}]}`},
+	}
+	t.Setenv("OPENAI_API_KEY", "test")
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+	t.Setenv("GEMINI_API_KEY", "test")
+	t.Setenv(DefaultCompatKeyEnv, "test")
+
+	for _, tc := range tests {
+		t.Run(tc.provider, func(t *testing.T) {
+			var bodies [][]byte
+			srv := sseServer(t, &bodies, tc.event)
+			defer tc.setup(srv.URL)()
+
+			// --raw leaves no system prompt and an empty registry.
+			cfg := config.Config{Provider: tc.provider, Model: "m", BaseURL: srv.URL, Raw: true}
+			if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "just this", io.Discard); err != nil {
+				t.Fatalf("Stream returned error: %v", err)
+			}
+			b := string(bodies[0])
+			if !strings.Contains(b, `"just this"`) {
+				t.Errorf("request body missing the prompt: %s", b)
+			}
+			for _, unwanted := range []string{"system", "instruction", "tool"} {
+				if strings.Contains(strings.ToLower(b), unwanted) {
+					t.Errorf("raw request contains %q: %s", unwanted, b)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxToolRounds(t *testing.T) {
+	toolSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer toolSrv.Close()
+
+	// The model asks for the tool on every turn, so only the cap ends the loop.
+	var bodies [][]byte
+	srv := sseServer(t, &bodies, `{"candidates":[{"content":{"parts":[{"functionCall":{"name":"lookup","args":{"q":1}}}]}}]}`)
+	orig := geminiBase
+	geminiBase = srv.URL + "/"
+	defer func() { geminiBase = orig }()
+	t.Setenv("GEMINI_API_KEY", "test")
+
+	tools := plugin.NewRegistry()
+	tools.Register(&plugin.Tool{Name: "lookup", Description: "d", Type: "http", URL: toolSrv.URL})
+
+	for _, rounds := range []int{1, 2} {
+		bodies = nil
+		cfg := config.Config{Provider: "gemini", Model: "gemini-1.5-flash", MaxToolRounds: rounds}
+		var stderr bytes.Buffer
+		if err := NewClient(cfg, &stderr, tools).Stream(context.Background(), "hi", io.Discard); err != nil {
+			t.Fatalf("Stream returned error: %v", err)
+		}
+		if len(bodies) != rounds+1 {
+			t.Fatalf("rounds=%d: expected %d API calls, got %d", rounds, rounds+1, len(bodies))
+		}
+		if want := fmt.Sprintf("note: stopped after %d tool rounds\n", rounds); stderr.String() != want {
+			t.Fatalf("rounds=%d: expected %q on stderr, got %q", rounds, want, stderr.String())
+		}
+		last := string(bodies[len(bodies)-1])
+		if n := strings.Count(last, `"functionResponse"`); n != rounds {
+			t.Fatalf("rounds=%d: last request carries %d function responses: %s", rounds, n, last)
+		}
+		if n := strings.Count(last, `"role":"model"`); n != rounds {
+			t.Fatalf("rounds=%d: last request carries %d model turns: %s", rounds, n, last)
+		}
+	}
+}
+
+func TestFinalTemperature(t *testing.T) {
+	toolSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer toolSrv.Close()
+
+	orig := geminiBase
+	defer func() { geminiBase = orig }()
+	t.Setenv("GEMINI_API_KEY", "test")
+
+	tools := plugin.NewRegistry()
+	tools.Register(&plugin.Tool{Name: "lookup", Description: "d", Type: "http", URL: toolSrv.URL})
+
+	// A final temperature of 0 must still be sent, not dropped as unset.
+	for _, final := range []float64{0.1, 0} {
+		var bodies [][]byte
+		srv := sseServer(t, &bodies, `{"candidates":[{"content":{"parts":[{"functionCall":{"name":"lookup","args":{}}}]}}]}`)
+		geminiBase = srv.URL + "/"
+
+		cfg := config.Config{Provider: "gemini", Model: "gemini-1.5-flash", Temperature: 0.9, FinalTemperature: &final, MaxToolRounds: 2}
+		if err := NewClient(cfg, io.Discard, tools).Stream(context.Background(), "hi", io.Discard); err != nil {
+			t.Fatalf("Stream returned error: %v", err)
+		}
+		want := []string{`"temperature":0.9`, `"temperature":0.9`, fmt.Sprintf(`"temperature":%v`, final)}
+		if len(bodies) != len(want) {
+			t.Fatalf("expected %d API calls, got %d", len(want), len(bodies))
+		}
+		for i, w := range want {
+			if !strings.Contains(string(bodies[i]), w) {
+				t.Errorf("final %v, request %d: expected %s: %s", final, i, w, bodies[i])
+			}
+		}
+	}
+}
+
+func TestAnthropicCustomTool(t *testing.T) {
+	var toolInput string
+	toolSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		toolInput = string(b)
+		w.Write([]byte(`{"temp":21}`))
+	}))
+	defer toolSrv.Close()
+
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, b)
+		w.Header().Set("Content-Type", "text/event-stream")
+		if len(bodies) == 1 {
+			fmt.Fprint(w, "data: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"tool_use\",\"id\":\"tu_1\",\"name\":\"weather\"}}\n\n")
+			fmt.Fprint(w, "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"city\\\":\"}}\n\n")
+			fmt.Fprint(w, "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"\\\"Oslo\\\"}\"}}\n\n")
+			return
+		}
+		fmt.Fprint(w, "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"21 degrees\"}}\n\n")
+	}))
+	defer srv.Close()
+	orig := anthropicURL
+	anthropicURL = srv.URL
+	defer func() { anthropicURL = orig }()
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+
+	tools := plugin.NewRegistry()
+	tools.Register(&plugin.Tool{Name: "weather", Description: "Current weather", Type: "http", URL: toolSrv.URL})
+
+	cfg := config.Config{Provider: "anthropic", Model: "claude-3-5-haiku-latest", MaxTokens: 100}
+	var stdout bytes.Buffer
+	if err := NewClient(cfg, io.Discard, tools).Stream(context.Background(), "weather in Oslo?", &stdout); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if stdout.String() != "21 degrees" {
+		t.Fatalf("unexpected output: %q", stdout.String())
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 API calls, got %d", len(bodies))
+	}
+	if !strings.Contains(string(bodies[0]), `"name":"weather"`) {
+		t.Fatalf("custom tool missing from request: %s", bodies[0])
+	}
+	if toolInput != `{"city":"Oslo"}` {
+		t.Fatalf("tool received %q", toolInput)
+	}
+	for _, want := range []string{`{"id":"tu_1","input":{"city":"Oslo"},"name":"weather","type":"tool_use"}`, `"tool_use_id":"tu_1"`, `\"temp\":21`} {
+		if !strings.Contains(string(bodies[1]), want) {
+			t.Errorf("follow-up request missing %s: %s", want, bodies[1])
+		}
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	toolSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"temp":21}`))
+	}))
+	defer toolSrv.Close()
+
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, b)
+		w.Header().Set("Content-Type", "text/event-stream")
+		if len(bodies) == 1 {
+			fmt.Fprint(w, "data: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"tool_use\",\"id\":\"tu_1\",\"name\":\"weather\"}}\n\n")
+			fmt.Fprint(w, "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"city\\\":\\\"Oslo\\\"}\"}}\n\n")
+			return
+		}
+		fmt.Fprint(w, "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"21 degrees\"}}\n\n")
+	}))
+	defer srv.Close()
+	orig := anthropicURL
+	anthropicURL = srv.URL
+	defer func() { anthropicURL = orig }()
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+
+	tools := plugin.NewRegistry()
+	tools.Register(&plugin.Tool{Name: "weather", Description: "Current weather", Type: "http", URL: toolSrv.URL})
+	cfg := config.Config{Provider: "anthropic", Model: "claude-3-5-haiku-latest", MaxTokens: 100}
+
+	client := NewClient(cfg, io.Discard, tools)
+	client.SetHistory([]history.Message{{Role: "user", Content: "hi"}, {Role: "assistant", Content: "hello"}})
+	client.RecordTranscript()
+	var stdout bytes.Buffer
+	if err := client.Stream(context.Background(), "weather in Oslo?", &stdout); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if stdout.String() != "21 degrees" {
+		t.Fatalf("unexpected output: %q", stdout.String())
+	}
+
+	got := client.Transcript()
+	var roles []string
+	for _, m := range got {
+		roles = append(roles, m.Role)
+	}
+	if strings.Join(roles, ",") != "user,assistant,user,assistant,tool,assistant" {
+		t.Fatalf("unexpected transcript roles: %v", roles)
+	}
+	call := got[3].ToolCalls
+	if len(call) != 1 || call[0].Name != "weather" || string(call[0].Input) != `{"city":"Oslo"}` {
+		t.Fatalf("unexpected tool calls: %+v", call)
+	}
+	if got[4].ToolCallID != call[0].ID || !strings.Contains(got[4].Content, `"temp":21`) {
+		t.Fatalf("unexpected tool turn: %+v", got[4])
+	}
+	if got[5].Content != "21 degrees" {
+		t.Fatalf("unexpected final turn: %+v", got[5])
+	}
+
+	// A saved transcript continues in a later run.
+	path := filepath.Join(t.TempDir(), "t.json")
+	if err := history.SaveTranscript(path, got); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := history.LoadTranscript(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies = bodies[:1]
+	next := NewClient(cfg, io.Discard, tools)
+	next.SetHistory(loaded)
+	if err := next.Stream(context.Background(), "and tomorrow?", io.Discard); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	for _, want := range []string{`"input":{"city":"Oslo"},"name":"weather","type":"tool_use"`, `"tool_use_id":"call_1"`, `\"temp\":21`, `"text":"and tomorrow?"`} {
+		if !strings.Contains(string(bodies[1]), want) {
+			t.Errorf("continued request missing %s: %s", want, bodies[1])
+		}
+	}
+}
+
+func TestGeminiCustomTool(t *testing.T) {
+	toolSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"temp":21}`))
+	}))
+	defer toolSrv.Close()
+
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, b)
+		w.Header().Set("Content-Type", "text/event-stream")
+		if len(bodies) == 1 {
+			fmt.Fprint(w, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"functionCall\":{\"name\":\"weather\",\"args\":{\"city\":\"Oslo\"}}}]}}]}\n\n")
+			return
+		}
+		fmt.Fprint(w, "data: {\"candidates\":[{\"content\":{\"parts\":[{\"text\":\"21 degrees\"}]},\"finishReason\":\"STOP\"}]}\n\n")
+	}))
+	defer srv.Close()
+	orig := geminiBase
+	geminiBase = srv.URL + "/"
+	defer func() { geminiBase = orig }()
+	t.Setenv("GEMINI_API_KEY", "test")
+
+	tools := plugin.NewRegistry()
+	tools.Register(&plugin.Tool{Name: "weather", Description: "Current weather", Type: "http", URL: toolSrv.URL})
+
+	cfg := config.Config{Provider: "gemini", Model: "gemini-1.5-flash"}
+	var stdout bytes.Buffer
+	if err := NewClient(cfg, io.Discard, tools).Stream(context.Background(), "weather in Oslo?", &stdout); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if stdout.String() != "21 degrees" {
+		t.Fatalf("unexpected output: %q", stdout.String())
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 API calls, got %d", len(bodies))
+	}
+	if !strings.Contains(string(bodies[0]), `"functionDeclarations":[{"description":"Current weather","name":"weather","parameters":{"properties":{},"type":"object"}}]`) {
+		t.Fatalf("custom tool missing from request: %s", bodies[0])
+	}
+
+	var follow struct {
+		Contents []geminiContent `json:"contents"`
+	}
+	if err := json.Unmarshal(bodies[1], &follow); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(follow.Contents); n != 3 {
+		t.Fatalf("expected user, model and function turns, got %d: %s", n, bodies[1])
+	}
+	call, resp := follow.Contents[1].Parts[0].FunctionCall, follow.Contents[2].Parts[0].FunctionResponse
+	if call == nil || call.Name != "weather" || resp == nil || resp.Name != "weather" {
+		t.Fatalf("follow-up lost the call name: %s", bodies[1])
+	}
+}
+
+func TestGeminiOmitsEmptyTools(t *testing.T) {
+	var bodies [][]byte
+	srv := sseServer(t, &bodies, `{"candidates":[{"content":{"parts":[{"text":"hi"}]},"finishReason":"STOP"}]}`)
+	orig := geminiBase
+	geminiBase = srv.URL + "/"
+	defer func() { geminiBase = orig }()
+	t.Setenv("GEMINI_API_KEY", "test")
+
+	cfg := config.Config{Provider: "gemini", Model: "gemini-1.5-flash"}
+	if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if strings.Contains(string(bodies[0]), "functionDeclarations") {
+		t.Fatalf("empty tool list sent: %s", bodies[0])
+	}
+}
+
+func TestGeminiThinkingBudget(t *testing.T) {
+	var bodies [][]byte
+	srv := sseServer(t, &bodies, `{"candidates":[{"content":{"parts":[{"text":"hi"}]},"finishReason":"STOP"}]}`)
+	orig := geminiBase
+	geminiBase = srv.URL + "/"
+	defer func() { geminiBase = orig }()
+	t.Setenv("GEMINI_API_KEY", "test")
+
+	for _, budget := range []int{1024, 0} {
+		cfg := config.Config{Provider: "gemini", Model: "gemini-2.5-flash", ThinkingBudget: &budget}
+		if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+			t.Fatalf("Stream returned error: %v", err)
+		}
+		want := fmt.Sprintf(`"generationConfig":{"thinkingConfig":{"thinkingBudget":%d}}`, budget)
+		if body := string(bodies[len(bodies)-1]); !strings.Contains(body, want) {
+			t.Errorf("request body missing %s: %s", want, body)
+		}
+	}
+
+	cfg := config.Config{Provider: "gemini", Model: "gemini-2.5-flash"}
+	if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if body := string(bodies[len(bodies)-1]); strings.Contains(body, "thinkingConfig") {
+		t.Errorf("unset thinking budget sent: %s", body)
+	}
+}
+
+func TestThinkingBudgetIgnoredElsewhere(t *testing.T) {
+	var bodies [][]byte
+	srv := sseServer(t, &bodies, `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ok"}}`)
+	orig := anthropicURL
+	anthropicURL = srv.URL
+	defer func() { anthropicURL = orig }()
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+
+	budget := 1024
+	cfg := config.Config{Provider: "anthropic", Model: "m", MaxTokens: 100, ThinkingBudget: &budget, Debug: true}
+	var stderr bytes.Buffer
+	if err := NewClient(cfg, &stderr, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if strings.Contains(strings.ToLower(string(bodies[0])), "thinking") {
+		t.Errorf("thinking budget sent to anthropic: %s", bodies[0])
+	}
+	if !strings.Contains(stderr.String(), "--thinking-budget only applies to gemini") {
+		t.Errorf("missing debug note: %q", stderr.String())
+	}
+}
+
+func TestRequestIDInErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("request-id", "req_123")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"overloaded"}`))
+	}))
+	defer srv.Close()
+	orig := anthropicURL
+	anthropicURL = srv.URL
+	defer func() { anthropicURL = orig }()
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+
+	cfg := config.Config{Provider: "anthropic", Model: "claude-3-5-haiku-latest", MaxTokens: 10, Debug: true}
+	var stderr bytes.Buffer
+	err := NewClient(cfg, &stderr, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "overloaded") || !strings.Contains(err.Error(), "(request id req_123)") {
+		t.Fatalf("expected error with body and request id, got %v", err)
+	}
+	if !strings.Contains(stderr.String(), "anthropic request id: req_123") {
+		t.Fatalf("request id not logged under debug: %q", stderr.String())
+	}
+
+	// Errors reported inside a 2xx stream carry the ID too.
+	srv2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-request-id", "req_456")
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"type\":\"error\",\"code\":\"server_error\",\"message\":\"server busy\"}\n\n")
+	}))
+	defer srv2.Close()
+	origOpenAI := openAIURL
+	openAIURL = srv2.URL
+	defer func() { openAIURL = origOpenAI }()
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	cfg = config.Config{Provider: "openai", Model: "gpt-4o-mini"}
+	err = NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "server busy") || !strings.Contains(err.Error(), "(request id req_456)") {
+		t.Fatalf("expected stream error with request id, got %v", err)
+	}
+}
+
+func TestAnthropicCacheSystem(t *testing.T) {
+	var bodies [][]byte
+	var beta []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, b)
+		beta = append(beta, r.Header.Get("anthropic-beta"))
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"ok\"}}\n\n")
+	}))
+	defer srv.Close()
+	orig := anthropicURL
+	anthropicURL = srv.URL
+	defer func() { anthropicURL = orig }()
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+
+	for _, cacheSystem := range []bool{false, true} {
+		cfg := config.Config{Provider: "anthropic", Model: "claude-3-5-haiku-latest", MaxTokens: 10, System: "long prompt", CacheSystem: cacheSystem}
+		if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+			t.Fatalf("Stream returned error: %v", err)
+		}
+	}
+
+	if strings.Contains(string(bodies[0]), "cache_control") || beta[0] != "" {
+		t.Fatalf("cache_control sent without --cache-system: %s", bodies[0])
+	}
+	var req struct {
+		System []struct {
+			Type         string            `json:"type"`
+			Text         string            `json:"text"`
+			CacheControl map[string]string `json:"cache_control"`
+		} `json:"system"`
+	}
+	if err := json.Unmarshal(bodies[1], &req); err != nil {
+		t.Fatalf("system is not a block list: %v: %s", err, bodies[1])
+	}
+	if len(req.System) != 1 || req.System[0].Text != "long prompt" || req.System[0].CacheControl["type"] != "ephemeral" {
+		t.Fatalf("unexpected system blocks: %s", bodies[1])
+	}
+	if beta[1] != anthropicCacheBeta {
+		t.Fatalf("expected anthropic-beta %q, got %q", anthropicCacheBeta, beta[1])
+	}
+}
+
+func TestAnthropicCacheSystemStablePrefix(t *testing.T) {
+	var bodies [][]byte
+	srv := sseServer(t, &bodies, `{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ok"}}`)
+	orig := anthropicURL
+	anthropicURL = srv.URL
+	defer func() { anthropicURL = orig }()
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+
+	names := []string{"fs", "fetch", "weather", "search", "calc", "notes"}
+	for i := 0; i < 4; i++ {
+		tools := plugin.NewRegistry()
+		for j := range names {
+			// Register in a different order each time.
+			n := names[(i+j)%len(names)]
+			tools.Register(&plugin.Tool{Name: n, Description: "tool " + n, Type: "http", URL: "http://example.com"})
+		}
+		cfg := config.Config{Provider: "anthropic", Model: "m", MaxTokens: 10, System: "long prompt", CacheSystem: true}
+		if err := NewClient(cfg, io.Discard, tools).Stream(context.Background(), "hi", io.Discard); err != nil {
+			t.Fatalf("Stream returned error: %v", err)
+		}
+	}
+	for i := 1; i < len(bodies); i++ {
+		if !bytes.Equal(bodies[i], bodies[0]) {
+			t.Fatalf("request body changed between registries:\n%s\n%s", bodies[0], bodies[i])
+		}
+	}
+}
+
+func TestCustomHeaders(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"ok\"}}\n\n")
+	}))
+	defer srv.Close()
+	orig := anthropicURL
+	anthropicURL = srv.URL
+	defer func() { anthropicURL = orig }()
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+
+	cfg := config.Config{
+		Provider:  "anthropic",
+		Model:     "claude-3-5-haiku-latest",
+		MaxTokens: 10,
+		Headers:   map[string]string{"X-Org-ID": "acme", "anthropic-version": "2099-01-01"},
+	}
+	if err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if got.Get("X-Org-ID") != "acme" {
+		t.Fatalf("custom header missing: %v", got)
+	}
+	if got.Get("anthropic-version") != "2099-01-01" {
+		t.Fatalf("custom header should replace the built-in one, got %q", got.Get("anthropic-version"))
+	}
+	if got.Get("x-api-key") != "test" {
+		t.Fatalf("built-in headers lost: %v", got)
+	}
+}
+
+func TestMissingKeyHint(t *testing.T) {
+	t.Setenv("GEMINI_API_KEY", "")
+	t.Setenv("GOOGLE_API_KEY", "")
+	cfg := config.Config{Provider: "gemini", Model: "gemini-1.5-flash"}
+	err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard)
+	if err == nil {
+		t.Fatal("expected missing key error")
+	}
+	for _, want := range []string{"GEMINI_API_KEY or GOOGLE_API_KEY", "export GEMINI_API_KEY=", "config.json"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error missing %q: %v", want, err)
+		}
+	}
+
+	t.Setenv("GOOGLE_API_KEY", "fallback")
+	if key, err := apiKey(cfg, "GEMINI_API_KEY", "GOOGLE_API_KEY"); err != nil || key != "fallback" {
+		t.Fatalf("expected GOOGLE_API_KEY fallback, got %q, %v", key, err)
+	}
+}
+
+func TestAPIKeyFromConfig(t *testing.T) {
+	cfg := config.Config{Provider: "openai", APIKeys: map[string]string{"openai": "sk-file"}}
+
+	t.Setenv("OPENAI_API_KEY", "")
+	if key, err := apiKey(cfg, "OPENAI_API_KEY"); err != nil || key != "sk-file" {
+		t.Fatalf("expected config key, got %q, %v", key, err)
+	}
+	t.Setenv("OPENAI_API_KEY", "sk-env")
+	if key, err := apiKey(cfg, "OPENAI_API_KEY"); err != nil || key != "sk-env" {
+		t.Fatalf("environment should win over config, got %q, %v", key, err)
+	}
+}
+
+func TestAPIKeyFromKeyring(t *testing.T) {
+	old := keyringGet
+	defer func() { keyringGet = old }()
+	keyringGet = func(provider string) (string, error) {
+		if provider == "openai" {
+			return "sk-keyring", nil
+		}
+		return "", keyring.ErrNotFound
+	}
+	cfg := config.Config{Provider: "openai", APIKeys: map[string]string{"openai": "sk-file"}}
+
+	t.Setenv("OPENAI_API_KEY", "")
+	if key, err := apiKey(cfg, "OPENAI_API_KEY"); err != nil || key != "sk-file" {
+		t.Fatalf("config should win over keyring, got %q, %v", key, err)
+	}
+	cfg.APIKeys = nil
+	if key, err := apiKey(cfg, "OPENAI_API_KEY"); err != nil || key != "sk-keyring" {
+		t.Fatalf("expected keyring key, got %q, %v", key, err)
+	}
+	t.Setenv("OPENAI_API_KEY", "sk-env")
+	if key, err := apiKey(cfg, "OPENAI_API_KEY"); err != nil || key != "sk-env" {
+		t.Fatalf("environment should win over keyring, got %q, %v", key, err)
+	}
+	cfg.Provider = "anthropic"
+	t.Setenv("ANTHROPIC_API_KEY", "")
+	if _, err := apiKey(cfg, "ANTHROPIC_API_KEY"); err == nil || !strings.Contains(err.Error(), "--set-key anthropic") {
+		t.Fatalf("expected missing key hint mentioning --set-key, got %v", err)
+	}
+}
+
+func TestNoStream(t *testing.T) {
+	tests := []struct {
+		provider string
+		body     string
+	}{
+		{"openai", `{"status":"completed","output":[{"type":"reasoning"},{"type":"message","content":[{"type":"output_text","text":"Hello"}]}],"usage":{"input_tokens":3,"output_tokens":2}}`},
+		{"anthropic", `{"content":[{"type":"text","text":"Hello"}],"stop_reason":"end_turn","usage":{"input_tokens":3,"output_tokens":2}}`},
+		{"gemini", `{"candidates":[{"content":{"parts":[{"text":"Hello"}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":3,"candidatesTokenCount":2}}`},
+		{"openai-compatible", `{"choices":[{"message":{"role":"assistant","content":"Hello"}}],"usage":{"prompt_tokens":3,"completion_tokens":2}}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.provider, func(t *testing.T) {
+			var reqBody []byte
+			var reqPath, reqQuery string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reqBody, _ = io.ReadAll(r.Body)
+				reqPath, reqQuery = r.URL.Path, r.URL.RawQuery
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+			origOpenAI, origAnthropic, origGemini := openAIURL, anthropicURL, geminiBase
+			openAIURL, anthropicURL, geminiBase = srv.URL, srv.URL, srv.URL+"/"
+			defer func() { openAIURL, anthropicURL, geminiBase = origOpenAI, origAnthropic, origGemini }()
+			for _, env := range []string{"OPENAI_API_KEY", "ANTHROPIC_API_KEY", "GEMINI_API_KEY", DefaultCompatKeyEnv} {
+				t.Setenv(env, "test")
+			}
+
+			tools := plugin.NewRegistry()
+			tools.Register(&plugin.Tool{Name: "lookup", Description: "d", Type: "http", URL: "http://example.com"})
+
+			cfg := config.Config{Provider: tc.provider, Model: "m", MaxTokens: 10, BaseURL: srv.URL, CompatTools: true, NoStream: true}
+			client := NewClient(cfg, io.Discard, tools)
+			var events bytes.Buffer
+			client.SetEvents(&events)
+			var stdout bytes.Buffer
+			if err := client.Stream(context.Background(), "hi", &stdout); err != nil {
+				t.Fatalf("Stream returned error: %v", err)
+			}
+			if stdout.String() != "Hello" {
+				t.Fatalf("unexpected output: %q", stdout.String())
+			}
+			if !strings.Contains(events.String(), `"usage":{"input_tokens":3,"output_tokens":2}`) {
+				t.Fatalf("usage not recorded: %s", events.String())
+			}
+			if strings.Contains(string(reqBody), `"stream":true`) || strings.Contains(string(reqBody), "lookup") {
+				t.Fatalf("request should be non-streaming without tools: %s", reqBody)
+			}
+			if tc.provider == "gemini" && (!strings.HasSuffix(reqPath, ":generateContent") || strings.Contains(reqQuery, "alt=sse")) {
+				t.Fatalf("gemini should call generateContent, got %s?%s", reqPath, reqQuery)
+			}
+		})
+	}
+}
+
+func TestNoStreamIncomplete(t *testing.T) {
+	tests := []struct {
+		provider string
+		body     string
+	}{
+		{"openai", `{"status":"incomplete","incomplete_details":{"reason":"max_output_tokens"},"output":[{"type":"message","content":[{"type":"output_text","text":"Hel"}]}]}`},
+		{"anthropic", `{"content":[{"type":"text","text":"Hel"}],"stop_reason":"max_tokens","usage":{"input_tokens":3,"output_tokens":10}}`},
+		{"gemini", `{"candidates":[{"content":{"parts":[{"text":"Hel"}]},"finishReason":"MAX_TOKENS"}]}`},
+		{"openai-compatible", `{"choices":[{"message":{"role":"assistant","content":"Hel"},"finish_reason":"length"}]}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.provider, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tc.body))
+			}))
+			defer srv.Close()
+			origOpenAI, origAnthropic, origGemini := openAIURL, anthropicURL, geminiBase
+			openAIURL, anthropicURL, geminiBase = srv.URL, srv.URL, srv.URL+"/"
+			defer func() { openAIURL, anthropicURL, geminiBase = origOpenAI, origAnthropic, origGemini }()
+			for _, env := range []string{"OPENAI_API_KEY", "ANTHROPIC_API_KEY", "GEMINI_API_KEY", DefaultCompatKeyEnv} {
+				t.Setenv(env, "test")
+			}
+
+			cfg := config.Config{Provider: tc.provider, Model: "m", MaxTokens: 10, BaseURL: srv.URL, NoStream: true}
+			var stdout bytes.Buffer
+			err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", &stdout)
+			if !errors.Is(err, ErrIncomplete) {
+				t.Fatalf("expected ErrIncomplete, got %v", err)
+			}
+			if stdout.String() != "Hel" {
+				t.Fatalf("partial text should still be written, got %q", stdout.String())
+			}
+		})
+	}
+}
+
+func TestStreamIncomplete(t *testing.T) {
+	tests := []struct {
+		provider string
+		events   []string
+	}{
+		{"anthropic", []string{
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}`,
+			`{"type":"message_delta","delta":{"stop_reason":"max_tokens"},"usage":{"output_tokens":10}}`,
+		}},
+		{"openai-compatible", []string{
+			`{"choices":[{"delta":{"content":"Hel"}}]}`,
+			`{"choices":[{"delta":{},"finish_reason":"length"}]}`,
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.provider, func(t *testing.T) {
+			srv := sseServer(t, nil, tc.events...)
+			orig := anthropicURL
+			anthropicURL = srv.URL
+			defer func() { anthropicURL = orig }()
+			t.Setenv("ANTHROPIC_API_KEY", "test")
+			t.Setenv(DefaultCompatKeyEnv, "test")
+
+			cfg := config.Config{Provider: tc.provider, Model: "m", MaxTokens: 10, BaseURL: srv.URL}
+			var stdout bytes.Buffer
+			err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", &stdout)
+			if !errors.Is(err, ErrIncomplete) {
+				t.Fatalf("expected ErrIncomplete, got %v", err)
+			}
+			if stdout.String() != "Hel" {
+				t.Fatalf("partial text should still be written, got %q", stdout.String())
+			}
+		})
+	}
+}
+
+func TestStrictTools(t *testing.T) {
+	toolSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such city", http.StatusNotFound)
+	}))
+	defer toolSrv.Close()
+
+	var bodies [][]byte
+	srv := sseServer(t, &bodies,
+		`{"type":"response.created","response":{"id":"r1"}}`,
+		`{"type":"response.output_item.added","item":{"id":"fc1","type":"function_call","call_id":"c1","name":"lookup","arguments":"{}"}}`,
+		`{"type":"response.completed","response":{"usage":{"input_tokens":1,"output_tokens":1}}}`,
+	)
+	orig := openAIURL
+	openAIURL = srv.URL
+	defer func() { openAIURL = orig }()
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	tools := plugin.NewRegistry()
+	tools.Register(&plugin.Tool{Name: "lookup", Description: "d", Type: "http", URL: toolSrv.URL})
+
+	cfg := config.Config{Provider: "openai", Model: "gpt-4o-mini", StrictTools: true}
+	err := NewClient(cfg, io.Discard, tools).Stream(context.Background(), "hi", io.Discard)
+	var toolErr *ToolError
+	if !errors.As(err, &toolErr) || toolErr.Tool != "lookup" || !strings.Contains(toolErr.Message, "no such city") {
+		t.Fatalf("expected ToolError for lookup, got %v", err)
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("failed tool result should not be sent back, got %d API calls", len(bodies))
+	}
+
+	bodies = nil
+	cfg.StrictTools = false
+	cfg.MaxToolRounds = 1
+	if err := NewClient(cfg, io.Discard, tools).Stream(context.Background(), "hi", io.Discard); err != nil {
+		t.Fatalf("Stream returned error: %v", err)
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("expected the error to be passed back to the model, got %d API calls", len(bodies))
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if strings.Contains(string(b), "slow") {
+			select {
+			case <-time.After(500 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprintf(w, "data: %s\n\n", `{"choices":[{"delta":{"content":"late"}}]}`)
+	}))
+	defer srv.Close()
+	t.Setenv(DefaultCompatKeyEnv, "test")
+
+	cfg := config.Config{Provider: "openai-compatible", Model: "m", BaseURL: srv.URL, RequestTimeout: 50 * time.Millisecond}
+	client := NewClient(cfg, io.Discard, plugin.NewRegistry())
+	err := client.Stream(context.Background(), "slow", io.Discard)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := client.Stream(context.Background(), "hi", &out); err != nil || out.String() != "late" {
+		t.Fatalf("fast request failed: %q, %v", out.String(), err)
+	}
+}
+
+func TestProviderErrorKinds(t *testing.T) {
+	tests := []struct {
+		status int
+		kind   ErrorKind
+	}{
+		{http.StatusUnauthorized, KindAuth},
+		{http.StatusForbidden, KindAuth},
+		{http.StatusTooManyRequests, KindRateLimit},
+		{http.StatusInternalServerError, KindServer},
+		{http.StatusServiceUnavailable, KindServer},
+		{529, KindServer},
+		{http.StatusBadRequest, KindBadRequest},
+		{http.StatusNotFound, KindBadRequest},
+	}
+	t.Setenv("OPENAI_API_KEY", "test")
+	for _, tc := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("x-request-id", "req_1")
+			w.WriteHeader(tc.status)
+			w.Write([]byte(`{"error":{"message":"nope","type":"some_error"}}`))
+		}))
+		orig := openAIURL
+		openAIURL = srv.URL
+		client := NewClient(config.Config{Provider: "openai", Model: "gpt-4o-mini"}, io.Discard, plugin.NewRegistry())
+		err := client.Stream(context.Background(), "hi", io.Discard)
+		openAIURL = orig
+		srv.Close()
+
+		var pe *ProviderError
+		if !errors.As(err, &pe) {
+			t.Fatalf("%d: expected *ProviderError, got %T: %v", tc.status, err, err)
+		}
+		if pe.Kind != tc.kind || pe.StatusCode != tc.status || pe.RequestID != "req_1" {
+			t.Errorf("%d: got kind=%s status=%d request id=%q", tc.status, pe.Kind, pe.StatusCode, pe.RequestID)
+		}
+		if pe.Auth() != (tc.kind == KindAuth) || pe.Retryable() != (tc.kind == KindRateLimit || tc.kind == KindServer) {
+			t.Errorf("%d: auth=%v retryable=%v", tc.status, pe.Auth(), pe.Retryable())
+		}
+		if err.Error() != "openai error (some_error): nope (request id req_1)" {
+			t.Errorf("%d: unexpected message %q", tc.status, err.Error())
+		}
+	}
+}
+
+func TestProviderErrorNetwork(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+	orig := openAIURL
+	openAIURL = url
+	defer func() { openAIURL = orig }()
+	t.Setenv("OPENAI_API_KEY", "test")
+
+	client := NewClient(config.Config{Provider: "openai", Model: "gpt-4o-mini"}, io.Discard, plugin.NewRegistry())
+	err := client.Stream(context.Background(), "hi", io.Discard)
+	var pe *ProviderError
+	if !errors.As(err, &pe) || pe.Kind != KindNetwork || !pe.Retryable() || pe.Err == nil {
+		t.Fatalf("expected network ProviderError, got %T: %v", err, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = client.Stream(ctx, "hi", io.Discard)
+	if !errors.Is(err, context.Canceled) || errors.As(err, &pe) {
+		t.Fatalf("cancellation should not be a ProviderError, got %T: %v", err, err)
+	}
+}
+
+func TestProviderErrorStreamEvent(t *testing.T) {
+	tests := []struct {
+		provider string
+		event    string
+		kind     ErrorKind
+	}{
+		{"openai", `{"type":"error","code":"rate_limit_exceeded","message":"slow down"}`, KindRateLimit},
+		{"anthropic", `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`, KindServer},
+		{"gemini", `{"error":{"code":429,"message":"quota","status":"RESOURCE_EXHAUSTED"}}`, KindRateLimit},
+		{"gemini", `{"error":{"code":503,"message":"try later"}}`, KindServer},
+		{"openai-compatible", `{"error":{"message":"boom","type":"server_error"}}`, KindServer},
+		{"openai-compatible", `{"error":{"message":"upstream","code":502}}`, KindServer},
+	}
+	for _, tc := range tests {
+		srv := sseServer(t, nil, tc.event)
+		cfg := config.Config{Provider: tc.provider, Model: "m"}
+		switch tc.provider {
+		case "openai":
+			orig := openAIURL
+			openAIURL = srv.URL
+			defer func() { openAIURL = orig }()
+			t.Setenv("OPENAI_API_KEY", "test")
+		case "anthropic":
+			orig := anthropicURL
+			anthropicURL = srv.URL
+			defer func() { anthropicURL = orig }()
+			t.Setenv("ANTHROPIC_API_KEY", "test")
+		case "gemini":
+			orig := geminiBase
+			geminiBase = srv.URL + "/"
+			defer func() { geminiBase = orig }()
+			t.Setenv("GEMINI_API_KEY", "test")
+		case "openai-compatible":
+			cfg.BaseURL = srv.URL
+			t.Setenv(DefaultCompatKeyEnv, "test")
+		}
+
+		client := NewClient(cfg, io.Discard, plugin.NewRegistry())
+		err := client.Stream(context.Background(), "hi", io.Discard)
+		var pe *ProviderError
+		if !errors.As(err, &pe) || pe.Kind != tc.kind {
+			t.Errorf("%s %s: expected %s ProviderError, got %T: %v", tc.provider, tc.event, tc.kind, err, err)
+		}
+	}
+}
+
+func TestProviderErrorTimeouts(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.ReadAll(r.Body)
+		select {
+		case <-time.After(500 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	t.Setenv(DefaultCompatKeyEnv, "test")
+
+	cfg := config.Config{Provider: "openai-compatible", Model: "m", BaseURL: srv.URL, RequestTimeout: 50 * time.Millisecond}
+	err := NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(context.Background(), "hi", io.Discard)
+	var pe *ProviderError
+	if !errors.As(err, &pe) || pe.Kind != KindNetwork || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("request timeout should be a network ProviderError, got %T: %v", err, err)
+	}
+
+	cfg.RequestTimeout = 0
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = NewClient(cfg, io.Discard, plugin.NewRegistry()).Stream(ctx, "hi", io.Discard)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.As(err, &pe) {
+		t.Fatalf("overall timeout should not be a ProviderError, got %T: %v", err, err)
+	}
+}
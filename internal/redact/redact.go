@@ -0,0 +1,122 @@
+// Package redact masks sensitive text in model output before it is written.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+)
+
+// Mask replaces every redacted match.
+const Mask = "***"
+
+// SecretPatterns are the built-in patterns enabled by --redact-secrets.
+var SecretPatterns = []string{
+	// Provider and platform API keys
+	`sk-(?:ant-|proj-)?[A-Za-z0-9_-]{16,}`,
+	`AKIA[0-9A-Z]{16}`,
+	`AIza[0-9A-Za-z_-]{35}`,
+	`gh[pousr]_[A-Za-z0-9]{36,}`,
+	`xox[abprs]-[A-Za-z0-9-]{10,}`,
+	// Bearer tokens in headers or logs
+	`(?i)bearer\s+[A-Za-z0-9._~+/-]+=*`,
+	// PEM private key headers
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----`,
+	// Email addresses
+	`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	// Phone numbers (international or North American formats)
+	`\+?\d{1,3}[\s.-]?\(?\d{3}\)?[\s.-]?\d{3}[\s.-]?\d{4}\b`,
+}
+
+// Compile compiles the given patterns, returning the first error.
+func Compile(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// String replaces all matches of patterns in s with Mask.
+func String(s string, patterns []*regexp.Regexp) string {
+	for _, re := range patterns {
+		s = re.ReplaceAllString(s, Mask)
+	}
+	return s
+}
+
+// JSON masks matches inside the string values of a JSON document, such as
+// a tool call's input, so the result stays valid JSON. Input that does not
+// parse is masked as plain text.
+func JSON(raw []byte, patterns []*regexp.Regexp) []byte {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return []byte(String(string(raw), patterns))
+	}
+	b, err := json.Marshal(jsonStrings(v, patterns))
+	if err != nil {
+		return raw
+	}
+	return b
+}
+
+func jsonStrings(v any, patterns []*regexp.Regexp) any {
+	switch v := v.(type) {
+	case string:
+		return String(v, patterns)
+	case []any:
+		for i := range v {
+			v[i] = jsonStrings(v[i], patterns)
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = jsonStrings(v[k], patterns)
+		}
+	}
+	return v
+}
+
+// Writer redacts output line by line. Partial lines are held until a
+// newline arrives or Flush is called, so matches split across writes are
+// still caught.
+type Writer struct {
+	w        io.Writer
+	patterns []*regexp.Regexp
+	buf      bytes.Buffer
+}
+
+// NewWriter returns a Writer that redacts patterns before writing to w.
+func NewWriter(w io.Writer, patterns []*regexp.Regexp) *Writer {
+	return &Writer{w: w, patterns: patterns}
+}
+
+func (rw *Writer) Write(p []byte) (int, error) {
+	rw.buf.Write(p)
+	for {
+		i := bytes.IndexByte(rw.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := string(rw.buf.Next(i + 1))
+		if _, err := io.WriteString(rw.w, String(line, rw.patterns)); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush redacts and writes any buffered partial line.
+func (rw *Writer) Flush() error {
+	if rw.buf.Len() == 0 {
+		return nil
+	}
+	line := rw.buf.String()
+	rw.buf.Reset()
+	_, err := io.WriteString(rw.w, String(line, rw.patterns))
+	return err
+}
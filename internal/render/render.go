@@ -0,0 +1,110 @@
+// Package render formats markdown responses with ANSI styling for terminals.
+package render
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	reset   = "\x1b[0m"
+	bold    = "\x1b[1m"
+	italic  = "\x1b[3m"
+	heading = "\x1b[1;35m"
+	code    = "\x1b[36m"
+	fence   = "\x1b[2m"
+	bullet  = "\x1b[33m"
+)
+
+var (
+	headingRe    = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	bulletRe     = regexp.MustCompile(`^(\s*)[-*+]\s+(.*)$`)
+	inlineCodeRe = regexp.MustCompile("`([^`]+)`")
+	boldRe       = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	italicRe     = regexp.MustCompile(`(^|[^*])\*([^*\s][^*]*)\*`)
+)
+
+// IsTerminal reports whether f is attached to a terminal.
+func IsTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+// Markdown renders headings, bullets, bold, italic, inline code and fenced
+// code blocks with ANSI escapes. Anything else passes through unchanged.
+func Markdown(s string) string {
+	var b strings.Builder
+	lines := strings.SplitAfter(s, "\n")
+	inFence := false
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		text := strings.TrimRight(line, "\n")
+		nl := line[len(text):]
+
+		if strings.HasPrefix(strings.TrimSpace(text), "```") {
+			inFence = !inFence
+			b.WriteString(fence + text + reset + nl)
+			continue
+		}
+		if inFence {
+			b.WriteString(code + text + reset + nl)
+			continue
+		}
+		if m := headingRe.FindStringSubmatch(text); m != nil {
+			b.WriteString(heading + inline(m[2], heading) + reset + nl)
+			continue
+		}
+		if m := bulletRe.FindStringSubmatch(text); m != nil {
+			b.WriteString(m[1] + bullet + "•" + reset + " " + inline(m[2], "") + nl)
+			continue
+		}
+		b.WriteString(inline(text, "") + nl)
+	}
+	return b.String()
+}
+
+// inline styles spans within a single line. base is re-applied after each
+// span so a styled heading keeps its color past inline markup.
+func inline(s, base string) string {
+	restore := reset + base
+	s = inlineCodeRe.ReplaceAllString(s, code+"$1"+restore)
+	s = boldRe.ReplaceAllString(s, bold+"$1$2"+restore)
+	s = italicRe.ReplaceAllString(s, "$1"+italic+"$2"+restore)
+	return s
+}
+
+// Writer buffers the full response and writes it rendered on Flush.
+// Markdown constructs such as fences span many streamed deltas, so nothing
+// is written until the stream is done.
+type Writer struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// NewWriter returns a Writer that renders markdown to w on Flush.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (rw *Writer) Write(p []byte) (int, error) {
+	return rw.buf.Write(p)
+}
+
+// Flush renders and writes everything buffered so far.
+func (rw *Writer) Flush() error {
+	if rw.buf.Len() == 0 {
+		return nil
+	}
+	s := rw.buf.String()
+	rw.buf.Reset()
+	_, err := io.WriteString(rw.w, Markdown(s))
+	return err
+}
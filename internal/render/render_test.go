@@ -0,0 +1,60 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMarkdown(t *testing.T) {
+	in := "# Title\n\nSome **bold** and `code` here.\n- item\n```go\nx := 1\n```\n"
+	got := Markdown(in)
+
+	for _, want := range []string{
+		heading + "Title" + reset + "\n",
+		bold + "bold" + reset,
+		code + "code" + reset,
+		bullet + "•" + reset + " item",
+		code + "x := 1" + reset + "\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output missing %q:\n%q", want, got)
+		}
+	}
+	if strings.Contains(got, "**") || strings.Contains(got, "# Title") {
+		t.Errorf("markdown syntax left in output: %q", got)
+	}
+}
+
+func TestMarkdownFenceIsLiteral(t *testing.T) {
+	got := Markdown("```\n**not bold** # nope\n```\n")
+	if !strings.Contains(got, code+"**not bold** # nope"+reset) {
+		t.Fatalf("fenced content was styled: %q", got)
+	}
+}
+
+func TestMarkdownPlainTextUnchanged(t *testing.T) {
+	in := "just some text\nwith two lines"
+	if got := Markdown(in); got != in {
+		t.Fatalf("plain text changed: %q", got)
+	}
+}
+
+func TestWriterBuffersUntilFlush(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	for _, chunk := range []string{"**bo", "ld**\n"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expected nothing before Flush, got %q", out.String())
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != bold+"bold"+reset+"\n" {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
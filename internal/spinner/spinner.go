@@ -0,0 +1,83 @@
+// Package spinner shows an elapsed-time indicator on a terminal while gogo
+// waits for the first token of a response.
+package spinner
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// Delay is how long a request may take before the spinner appears, so
+	// fast responses never flicker.
+	Delay = 300 * time.Millisecond
+	// Interval is how often the spinner redraws.
+	Interval = 100 * time.Millisecond
+)
+
+var frames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// Spinner draws on one line of w until stopped.
+type Spinner struct {
+	w    io.Writer
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Start begins drawing "<frame> <elapsed>s" on w after delay, redrawing
+// every interval. w should be a terminal; the line is redrawn in place.
+func Start(w io.Writer, delay, interval time.Duration) *Spinner {
+	s := &Spinner{w: w, stop: make(chan struct{}), done: make(chan struct{})}
+	go s.run(delay, interval)
+	return s
+}
+
+func (s *Spinner) run(delay, interval time.Duration) {
+	defer close(s.done)
+	start := time.Now()
+	select {
+	case <-s.stop:
+		return
+	case <-time.After(delay):
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		fmt.Fprintf(s.w, "\r%s %.1fs", frames[i%len(frames)], time.Since(start).Seconds())
+		select {
+		case <-s.stop:
+			// Clear the line so the response starts at column 0.
+			fmt.Fprint(s.w, "\r\x1b[K")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop removes the spinner and waits until it no longer writes to w. It is
+// safe to call more than once.
+func (s *Spinner) Stop() {
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// Writer returns a writer that stops s before its first write reaches w.
+func (s *Spinner) Writer(w io.Writer) io.Writer {
+	return &stopWriter{s: s, w: w}
+}
+
+type stopWriter struct {
+	s *Spinner
+	w io.Writer
+}
+
+func (sw *stopWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		sw.s.Stop()
+	}
+	return sw.w.Write(p)
+}
@@ -0,0 +1,41 @@
+package spinner
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStopsOnFirstWrite(t *testing.T) {
+	var term, out bytes.Buffer
+	s := Start(&term, 0, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	w := s.Writer(&out)
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	drawn := term.String()
+	if !strings.Contains(drawn, frames[0]+" ") || !strings.HasSuffix(drawn, "\r\x1b[K") {
+		t.Fatalf("expected spinner frames then a cleared line, got %q", drawn)
+	}
+	if out.String() != "hello" {
+		t.Fatalf("output not passed through: %q", out.String())
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if term.String() != drawn {
+		t.Fatal("spinner kept drawing after the first write")
+	}
+	s.Stop()
+}
+
+func TestNothingDrawnBeforeDelay(t *testing.T) {
+	var term bytes.Buffer
+	s := Start(&term, time.Hour, time.Millisecond)
+	s.Stop()
+	if term.Len() != 0 {
+		t.Fatalf("spinner drew before its delay: %q", term.String())
+	}
+}
@@ -0,0 +1,65 @@
+package stream
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrIdleTimeout is returned when no event arrives within the idle window.
+var ErrIdleTimeout = errors.New("stream idle timeout: no event received")
+
+// ReadEventsIdle is ReadEvents with an idle timeout: if no complete event
+// arrives within idle, the body is closed and ErrIdleTimeout is returned.
+// The timer resets per event rather than per byte, so a connection that
+// trickles keep-alive bytes without events still times out. A zero idle
+// disables the timeout.
+func ReadEventsIdle(body io.ReadCloser, idle time.Duration, onData func(string) error) error {
+	return ReadNamedEventsIdle(body, idle, func(_ string, data string) error {
+		return onData(data)
+	})
+}
+
+// ReadNamedEventsIdle is ReadNamedEvents with an idle timeout; see
+// ReadEventsIdle.
+func ReadNamedEventsIdle(body io.ReadCloser, idle time.Duration, onEvent func(event, data string) error) error {
+	if idle <= 0 {
+		return ReadNamedEvents(body, onEvent)
+	}
+
+	w := &idleWatch{body: body}
+	w.timer = time.AfterFunc(idle, w.fire)
+	defer w.timer.Stop()
+
+	err := ReadNamedEvents(body, func(event, data string) error {
+		w.timer.Reset(idle)
+		return onEvent(event, data)
+	})
+	if w.didFire() {
+		return ErrIdleTimeout
+	}
+	return err
+}
+
+type idleWatch struct {
+	body  io.ReadCloser
+	timer *time.Timer
+
+	mu    sync.Mutex
+	fired bool
+}
+
+func (w *idleWatch) fire() {
+	w.mu.Lock()
+	w.fired = true
+	w.mu.Unlock()
+	// Closing the body unblocks the pending Read.
+	w.body.Close()
+}
+
+func (w *idleWatch) didFire() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.fired
+}
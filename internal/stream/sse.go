@@ -7,19 +7,41 @@ import (
 	"strings"
 )
 
+// InitialBufferSize is the starting capacity of the line buffer.
+var InitialBufferSize = 64 * 1024
+
+// MaxLineSize is the longest single line the reader accepts. Providers can
+// emit very large single-line JSON chunks (e.g. big function-call args), so
+// this is well above bufio's 64KB default.
+var MaxLineSize = 4 * 1024 * 1024
+
 // ReadEvents reads text/event-stream events and yields data payloads.
 // It returns when the stream ends or an error occurs.
 func ReadEvents(r io.Reader, onData func(string) error) error {
+	return ReadNamedEvents(r, func(_ string, data string) error {
+		return onData(data)
+	})
+}
+
+// ReadNamedEvents reads text/event-stream events and yields the event name
+// (from the "event:" field, empty if absent) along with the data payload.
+// It returns when the stream ends or an error occurs.
+func ReadNamedEvents(r io.Reader, onEvent func(event, data string) error) error {
 	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, InitialBufferSize), MaxLineSize)
 	var buf bytes.Buffer
+	var name string
 
 	flush := func() error {
 		if buf.Len() == 0 {
+			name = ""
 			return nil
 		}
 		data := buf.String()
+		event := name
 		buf.Reset()
-		return onData(data)
+		name = ""
+		return onEvent(event, data)
 	}
 
 	for scanner.Scan() {
@@ -30,6 +52,10 @@ func ReadEvents(r io.Reader, onData func(string) error) error {
 			}
 			continue
 		}
+		if strings.HasPrefix(line, "event:") {
+			name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+			continue
+		}
 		if strings.HasPrefix(line, "data:") {
 			payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
 			if buf.Len() > 0 {
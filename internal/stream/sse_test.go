@@ -0,0 +1,173 @@
+package stream
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type namedEvent struct {
+	event string
+	data  string
+}
+
+func TestReadNamedEvents(t *testing.T) {
+	input := "event: message_start\n" +
+		"data: {\"a\":1}\n" +
+		"\n" +
+		"event: content_block_delta\n" +
+		"data: line1\n" +
+		"data: line2\n" +
+		"\n" +
+		"data: unnamed\n" +
+		"\n"
+
+	var got []namedEvent
+	err := ReadNamedEvents(strings.NewReader(input), func(event, data string) error {
+		got = append(got, namedEvent{event, data})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadNamedEvents returned error: %v", err)
+	}
+
+	want := []namedEvent{
+		{"message_start", `{"a":1}`},
+		{"content_block_delta", "line1\nline2"},
+		{"", "unnamed"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d events, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("event %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReadNamedEventsNameResetWithoutData(t *testing.T) {
+	input := "event: ping\n\ndata: after\n"
+
+	var got []namedEvent
+	err := ReadNamedEvents(strings.NewReader(input), func(event, data string) error {
+		got = append(got, namedEvent{event, data})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadNamedEvents returned error: %v", err)
+	}
+	if len(got) != 1 || got[0] != (namedEvent{"", "after"}) {
+		t.Fatalf("unexpected events: %v", got)
+	}
+}
+
+func TestReadEventsIgnoresNames(t *testing.T) {
+	input := "event: foo\ndata: a\ndata: b\n\nevent: bar\ndata: c"
+
+	var got []string
+	err := ReadEvents(strings.NewReader(input), func(data string) error {
+		got = append(got, data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadEvents returned error: %v", err)
+	}
+	if len(got) != 2 || got[0] != "a\nb" || got[1] != "c" {
+		t.Fatalf("unexpected data: %q", got)
+	}
+}
+
+func TestReadEventsLargeLine(t *testing.T) {
+	payload := strings.Repeat("x", 200*1024)
+	input := "data: " + payload + "\n\n"
+
+	var got string
+	err := ReadEvents(strings.NewReader(input), func(data string) error {
+		got = data
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadEvents returned error: %v", err)
+	}
+	if got != payload {
+		t.Fatalf("payload not delivered intact: got %d bytes, want %d", len(got), len(payload))
+	}
+}
+
+func TestReadEventsMaxLineSize(t *testing.T) {
+	origInit, origMax := InitialBufferSize, MaxLineSize
+	defer func() { InitialBufferSize, MaxLineSize = origInit, origMax }()
+	InitialBufferSize, MaxLineSize = 512, 1024
+
+	input := "data: " + strings.Repeat("x", 2048) + "\n\n"
+	err := ReadEvents(strings.NewReader(input), func(string) error { return nil })
+	if !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("expected ErrTooLong, got %v", err)
+	}
+}
+
+func TestReadEventsIdleTimeout(t *testing.T) {
+	stop := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		fmt.Fprint(w, "data: first\n\n")
+		flusher.Flush()
+		// Stall: trickle comment bytes but never complete another event.
+		for {
+			select {
+			case <-stop:
+				return
+			case <-r.Context().Done():
+				return
+			case <-time.After(20 * time.Millisecond):
+				fmt.Fprint(w, ": keepalive\n")
+				flusher.Flush()
+			}
+		}
+	}))
+	defer server.Close()
+	defer close(stop)
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	start := time.Now()
+	err = ReadEventsIdle(resp.Body, 150*time.Millisecond, func(data string) error {
+		got = append(got, data)
+		return nil
+	})
+	if !errors.Is(err, ErrIdleTimeout) {
+		t.Fatalf("expected ErrIdleTimeout, got %v", err)
+	}
+	if len(got) != 1 || got[0] != "first" {
+		t.Fatalf("expected the first event before stalling, got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("idle timeout took too long: %v", elapsed)
+	}
+}
+
+func TestReadEventsIdleCompletes(t *testing.T) {
+	body := io.NopCloser(strings.NewReader("data: a\n\ndata: b\n\n"))
+	var got []string
+	err := ReadEventsIdle(body, time.Second, func(data string) error {
+		got = append(got, data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadEventsIdle returned error: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("unexpected events: %q", got)
+	}
+}
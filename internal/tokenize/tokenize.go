@@ -0,0 +1,101 @@
+// Package tokenize estimates how many tokens a text costs without calling a
+// provider. Exact counts need each vendor's vocabulary, so the estimates
+// follow how BPE tokenizers split text rather than reproducing them.
+package tokenize
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Methods reported by Count.
+const (
+	// MethodBPE splits text the way BPE tokenizers pre-tokenize it and
+	// estimates the tokens in each piece.
+	MethodBPE = "bpe-estimate"
+	// MethodChars is the chars/4 fallback for unknown model families.
+	MethodChars = "chars/4"
+)
+
+// bpeFamilies are model id prefixes whose tokenizers are BPE vocabularies
+// trained mostly on English and code, where MethodBPE tracks real counts
+// closely.
+var bpeFamilies = []string{"gpt-", "o1", "o3", "o4", "claude", "gemini", "llama", "mistral"}
+
+// pieceRe approximates the cl100k pre-tokenizer: contractions, words with
+// one leading non-letter, numbers in groups of up to three digits,
+// punctuation runs and whitespace.
+var pieceRe = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\pL\pN]?\pL+|\pN{1,3}| ?[^\s\pL\pN]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// Count returns the estimated number of tokens in text for model and the
+// method used.
+func Count(model, text string) (int, string) {
+	if text == "" {
+		return 0, methodFor(model)
+	}
+	if methodFor(model) == MethodChars {
+		return Chars(text), MethodChars
+	}
+	return BPE(text), MethodBPE
+}
+
+func methodFor(model string) string {
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	model = strings.ToLower(model)
+	for _, prefix := range bpeFamilies {
+		if strings.HasPrefix(model, prefix) {
+			return MethodBPE
+		}
+	}
+	return MethodChars
+}
+
+// Chars estimates one token per four characters, rounding up.
+func Chars(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// BPE estimates tokens by pre-tokenizing text and costing each piece.
+func BPE(text string) int {
+	n := 0
+	for _, piece := range pieceRe.FindAllString(text, -1) {
+		n += pieceTokens(piece)
+	}
+	return n
+}
+
+// pieceTokens estimates one pre-tokenized piece. Common words, including a
+// leading space, are a single token; longer ASCII words split into chunks
+// of about six letters. Letters outside ASCII (CJK, Cyrillic, ...) are
+// poorly covered by English-heavy vocabularies and cost about one token
+// per rune.
+func pieceTokens(piece string) int {
+	letters, other, digits := 0, 0, 0
+	for _, r := range piece {
+		switch {
+		case r < utf8.RuneSelf && unicode.IsLetter(r):
+			letters++
+		case unicode.IsLetter(r):
+			other++
+		case unicode.IsDigit(r):
+			digits++
+		}
+	}
+	switch {
+	case digits > 0 && digits == utf8.RuneCountInString(piece):
+		// Numbers are split into groups of at most three digits.
+		return 1
+	case other > 0:
+		return other + (letters+5)/6
+	case letters > 0:
+		return (letters + 5) / 6
+	default:
+		// Whitespace and punctuation: runs of the same kind merge
+		// well, so count about one token per two characters.
+		return (utf8.RuneCountInString(strings.TrimLeft(piece, " ")) + 1) / 2
+	}
+}
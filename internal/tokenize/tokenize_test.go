@@ -0,0 +1,54 @@
+package tokenize
+
+import "testing"
+
+func TestCountMethod(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"gpt-4o-mini", MethodBPE},
+		{"claude-3-5-haiku-latest", MethodBPE},
+		{"gemini-1.5-flash", MethodBPE},
+		{"meta-llama/Llama-3.1-70B", MethodBPE},
+		{"some-local-model", MethodChars},
+		{"", MethodChars},
+	}
+	for _, tc := range tests {
+		if _, got := Count(tc.model, "hello"); got != tc.want {
+			t.Errorf("Count(%q) method = %q, want %q", tc.model, got, tc.want)
+		}
+	}
+}
+
+func TestBPE(t *testing.T) {
+	// Reference counts are from cl100k_base; the estimate should land
+	// within 25% of each.
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"Hello, world!", 4},
+		{"The quick brown fox jumps over the lazy dog.", 10},
+		{"func main() {\n\tfmt.Println(\"hi\")\n}\n", 12},
+		{"Summarize the following document in three bullet points.", 10},
+		{"1234567", 3},
+	}
+	for _, tc := range tests {
+		got := BPE(tc.text)
+		if d := got - tc.want; d*4 > tc.want || -d*4 > tc.want {
+			t.Errorf("BPE(%q) = %d, want about %d", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestChars(t *testing.T) {
+	for in, want := range map[string]int{"": 0, "abc": 1, "abcd": 1, "abcde": 2, "héllo wörld": 3} {
+		if got := Chars(in); got != want {
+			t.Errorf("Chars(%q) = %d, want %d", in, got, want)
+		}
+	}
+	if n, method := Count("unknown", "abcdefgh"); n != 2 || method != MethodChars {
+		t.Errorf("Count fallback = %d %s, want 2 %s", n, method, MethodChars)
+	}
+}
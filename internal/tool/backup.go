@@ -0,0 +1,142 @@
+package tool
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// backupTarget returns the path req would overwrite or remove, or "" when
+// req destroys nothing. follow is set when the op writes through a symlink
+// to its target rather than replacing the link, and tree when a whole
+// directory may be lost.
+func backupTarget(req FSRequest) (path string, follow, tree bool) {
+	switch req.Op {
+	case "write", "append":
+		return req.Path, true, false
+	case "delete":
+		return req.Path, false, true
+	case "move":
+		return req.Dest, false, false
+	case "copy":
+		return req.Dest, true, false
+	}
+	return "", false, false
+}
+
+// backup copies the file at path, or with tree a directory, to
+// dir/<path>.<timestamp>.bak and returns the copy's path. With follow, a
+// symlink is backed up as the file it points to. Anything else, including
+// a missing path, needs no backup and returns "".
+func backup(dir, path string, follow, tree bool) (string, error) {
+	stat := os.Lstat
+	if follow {
+		stat = os.Stat
+	}
+	info, err := stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() && !(tree && info.IsDir()) {
+		return "", nil
+	}
+	// Deleting the backups along with the directory would defeat them.
+	if info.IsDir() && within(path, dir) {
+		return "", fmt.Errorf("%s contains the backup directory %s", path, dir)
+	}
+	dest, err := backupPath(dir, path)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return dest, copyTree(path, dest)
+	}
+	return dest, copyFile(path, dest, info.Mode().Perm())
+}
+
+// backupPath mirrors path under dir: a path below the working directory
+// keeps its relative form, anything else its absolute one. A timestamp
+// keeps earlier backups of the same path.
+func backupPath(dir, path string) (string, error) {
+	name := filepath.Clean(path)
+	if !filepath.IsLocal(name) {
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return "", err
+		}
+		name = strings.TrimLeft(strings.TrimPrefix(abs, filepath.VolumeName(abs)), `/\`)
+	}
+	base := filepath.Join(dir, name) + "." + time.Now().Format("20060102-150405.000000")
+	dest := base + ".bak"
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(dest); errors.Is(err, fs.ErrNotExist) {
+			return dest, nil
+		}
+		dest = fmt.Sprintf("%s-%d.bak", base, i)
+	}
+}
+
+// within reports whether path is parent or below it.
+func within(parent, path string) bool {
+	p, err1 := filepath.Abs(parent)
+	c, err2 := filepath.Abs(path)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	rel, err := filepath.Rel(p, c)
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+}
+
+func copyFile(src, dst string, perm fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyTree copies the directories and regular files under src to dst.
+// Symlinks and other special files are skipped.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
@@ -0,0 +1,184 @@
+package tool
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// maxDiffCells caps the LCS table; beyond it the changed region is shown
+// as a plain removal followed by an addition.
+const maxDiffCells = 4 << 20
+
+// edit is one line of an edit script: ' ' kept, '-' removed, '+' added.
+type edit struct {
+	op   byte
+	line string
+}
+
+// unifiedDiff returns a unified diff turning old into new, labelled with
+// path, or "" when they are equal.
+func unifiedDiff(path, old, new string) string {
+	if old == new {
+		return ""
+	}
+	edits := diffLines(splitLines(old), splitLines(new))
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", path, path)
+	oldLine, newLine := 0, 0 // lines consumed before edits[pos]
+	pos := 0
+	for i := 0; i < len(edits); i++ {
+		if edits[i].op == ' ' {
+			continue
+		}
+		// A hunk runs from diffContext lines before its first change to
+		// diffContext lines after its last, absorbing any change whose gap
+		// to the previous one is too small to split.
+		start := max(i-diffContext, pos)
+		last := i
+		for j := i + 1; j < len(edits); j++ {
+			if edits[j].op == ' ' {
+				continue
+			}
+			if j-last-1 > 2*diffContext {
+				break
+			}
+			last = j
+		}
+		end := min(last+diffContext+1, len(edits))
+
+		for _, e := range edits[pos:start] {
+			oldLine, newLine = advance(e, oldLine, newLine)
+		}
+		oldCount, newCount := 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				oldCount++
+			}
+			if e.op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+		for _, e := range edits[start:end] {
+			b.WriteByte(e.op)
+			b.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+			oldLine, newLine = advance(e, oldLine, newLine)
+		}
+		pos = end
+		i = end - 1
+	}
+	return b.String()
+}
+
+func advance(e edit, oldLine, newLine int) (int, int) {
+	if e.op != '+' {
+		oldLine++
+	}
+	if e.op != '-' {
+		newLine++
+	}
+	return oldLine, newLine
+}
+
+// hunkRange formats one side of a hunk header given the lines before the
+// hunk and its length; an empty side names the line it follows.
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprint(before + 1)
+	default:
+		return fmt.Sprintf("%d,%d", before+1, count)
+	}
+}
+
+// splitLines splits s after each newline and keeps them, so a missing
+// final newline shows up as a change.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns an edit script turning a into b. The common prefix
+// and suffix are kept as is and the rest is diffed by longest common
+// subsequence.
+func diffLines(a, b []string) []edit {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	edits := make([]edit, 0, len(a)+len(b))
+	for _, l := range a[:pre] {
+		edits = append(edits, edit{' ', l})
+	}
+	edits = append(edits, diffMiddle(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, l := range a[len(a)-suf:] {
+		edits = append(edits, edit{' ', l})
+	}
+	return edits
+}
+
+func diffMiddle(a, b []string) []edit {
+	n, m := len(a), len(b)
+	var edits []edit
+	if n*m > maxDiffCells {
+		for _, l := range a {
+			edits = append(edits, edit{'-', l})
+		}
+		for _, l := range b {
+			edits = append(edits, edit{'+', l})
+		}
+		return edits
+	}
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int32, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			edits = append(edits, edit{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			edits = append(edits, edit{'-', a[i]})
+			i++
+		default:
+			edits = append(edits, edit{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		edits = append(edits, edit{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		edits = append(edits, edit{'+', b[j]})
+	}
+	return edits
+}
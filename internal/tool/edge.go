@@ -0,0 +1,122 @@
+package tool
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// DefaultEdgeLines is how many lines head and tail return by default.
+const DefaultEdgeLines = 10
+
+// tailChunk is how much tailFile reads per step backwards from the end.
+const tailChunk = 4096
+
+// headFile returns the first n lines of path, reading no further.
+func headFile(path string, n int) FSResult {
+	f, size, n, res := openEdge(path, n)
+	if f == nil {
+		return res
+	}
+	defer f.Close()
+
+	var sb strings.Builder
+	r := bufio.NewReader(f)
+	lines := 0
+	for lines < n {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			sb.WriteString(line)
+			lines++
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return FSResult{OK: false, Error: err.Error()}
+		}
+	}
+	return FSResult{OK: true, Data: edgeLines{Content: sb.String(), Lines: lines, TotalSize: size}}
+}
+
+// tailFile returns the last n lines of path. It reads backwards from the
+// end in chunks, so only the tail of a large file is loaded.
+func tailFile(path string, n int) FSResult {
+	f, size, n, res := openEdge(path, n)
+	if f == nil {
+		return res
+	}
+	defer f.Close()
+
+	var buf []byte
+	offset := size
+	for offset > 0 {
+		// A trailing newline ends the last line, so n lines need n+1
+		// newlines unless the start of the file is reached first.
+		if countLines(buf) > n {
+			break
+		}
+		step := int64(tailChunk)
+		if step > offset {
+			step = offset
+		}
+		offset -= step
+		chunk := make([]byte, step)
+		if _, err := f.ReadAt(chunk, offset); err != nil && err != io.EOF {
+			return FSResult{OK: false, Error: err.Error()}
+		}
+		buf = append(chunk, buf...)
+	}
+
+	lines := bytes.SplitAfter(buf, []byte("\n"))
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return FSResult{OK: true, Data: edgeLines{Content: string(bytes.Join(lines, nil)), Lines: len(lines), TotalSize: size}}
+}
+
+// countLines counts the lines in b, where a final line without a trailing
+// newline still counts.
+func countLines(b []byte) int {
+	if len(b) == 0 {
+		return 0
+	}
+	n := bytes.Count(b, []byte("\n"))
+	if b[len(b)-1] != '\n' {
+		n++
+	}
+	return n
+}
+
+// openEdge validates a head or tail request and opens the file. On failure
+// the file is nil and res holds the error.
+func openEdge(path string, n int) (f *os.File, size int64, lines int, res FSResult) {
+	if path == "" {
+		return nil, 0, 0, FSResult{OK: false, Error: "path is required"}
+	}
+	if n < 0 {
+		return nil, 0, 0, FSResult{OK: false, Error: "lines must not be negative"}
+	}
+	if n == 0 {
+		n = DefaultEdgeLines
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, FSResult{OK: false, Error: err.Error()}
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, 0, FSResult{OK: false, Error: err.Error()}
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, 0, 0, FSResult{OK: false, Error: path + " is a directory"}
+	}
+	return f, info.Size(), n, FSResult{}
+}
@@ -0,0 +1,137 @@
+package tool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultFetchMaxBytes caps the response body returned by the fetch tool.
+const DefaultFetchMaxBytes = 1 << 20
+
+// DefaultFetchTimeout bounds a single fetch request.
+const DefaultFetchTimeout = 30 * time.Second
+
+type FetchRequest struct {
+	URL     string            `json:"url"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+}
+
+type FetchResponse struct {
+	Status    int               `json:"status"`
+	Headers   map[string]string `json:"headers"`
+	Body      string            `json:"body"`
+	Truncated bool              `json:"truncated,omitempty"`
+}
+
+// FetchOptions controls limits and network policy for Fetch.
+type FetchOptions struct {
+	// AllowPrivate permits requests to loopback, private, and link-local
+	// addresses. It is off by default to prevent SSRF.
+	AllowPrivate bool
+	MaxBytes     int64
+	Timeout      time.Duration
+}
+
+// Fetch performs an HTTP request and returns its status, headers, and body
+// capped at opts.MaxBytes.
+func Fetch(ctx context.Context, req FetchRequest, opts FetchOptions) (FetchResponse, error) {
+	if req.URL == "" {
+		return FetchResponse{}, errors.New("url is required")
+	}
+	if !strings.HasPrefix(req.URL, "http://") && !strings.HasPrefix(req.URL, "https://") {
+		return FetchResponse{}, errors.New("url must be http or https")
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = DefaultFetchMaxBytes
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultFetchTimeout
+	}
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if req.Body != "" {
+		body = strings.NewReader(req.Body)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, req.URL, body)
+	if err != nil {
+		return FetchResponse{}, err
+	}
+	for k, v := range req.Headers {
+		httpReq.Header.Set(k, v)
+	}
+
+	dialer := &net.Dialer{Timeout: opts.Timeout}
+	if !opts.AllowPrivate {
+		dialer.Control = blockPrivate
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+	// Through a proxy the dial check would see the proxy's address rather
+	// than the target's, so proxies are only used when private addresses
+	// are allowed anyway.
+	if opts.AllowPrivate {
+		transport.Proxy = http.ProxyFromEnvironment
+	}
+	client := &http.Client{Timeout: opts.Timeout, Transport: transport}
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return FetchResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, opts.MaxBytes+1))
+	if err != nil {
+		return FetchResponse{}, err
+	}
+	truncated := int64(len(b)) > opts.MaxBytes
+	if truncated {
+		b = b[:opts.MaxBytes]
+	}
+
+	headers := make(map[string]string, len(resp.Header))
+	for k, v := range resp.Header {
+		headers[k] = strings.Join(v, ", ")
+	}
+
+	return FetchResponse{
+		Status:    resp.StatusCode,
+		Headers:   headers,
+		Body:      string(b),
+		Truncated: truncated,
+	}, nil
+}
+
+// blockPrivate rejects connections to non-public addresses. It runs after
+// DNS resolution, so it also covers redirects and rebinding.
+func blockPrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid address %q", host)
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return errors.New("fetch to private or loopback address " + host + " is not allowed")
+	}
+	return nil
+}
@@ -1,18 +1,47 @@
 package tool
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Ops lists the operations FS supports.
+var Ops = []string{"read", "write", "append", "delete", "mkdir", "rmdir", "list", "stat", "move", "copy", "chmod", "touch", "head", "tail"}
+
 type FSRequest struct {
 	Op   string `json:"op"`
 	Path string `json:"path"`
 	Data string `json:"data,omitempty"`
 	Dest string `json:"dest,omitempty"`
+
+	// Offset and Length select a byte range for read. Both zero reads the
+	// whole file; a zero Length reads from Offset to the end.
+	Offset int64 `json:"offset,omitempty"`
+	Length int64 `json:"length,omitempty"`
+
+	// StartLine and EndLine select a 1-indexed, inclusive line range for
+	// read. A zero EndLine reads to the last line.
+	StartLine int `json:"start_line,omitempty"`
+	EndLine   int `json:"end_line,omitempty"`
+
+	// Atomic makes write go through a temp file and rename, so readers
+	// see either the old or the new content, never a partial file.
+	Atomic bool `json:"atomic,omitempty"`
+
+	// Lines is how many lines head and tail return; zero means
+	// DefaultEdgeLines.
+	Lines int `json:"lines,omitempty"`
+
+	// Pattern makes list return the entries under Path matching a glob
+	// such as "*.go" or "**/*.txt", named relative to Path.
+	Pattern string `json:"pattern,omitempty"`
 }
 
 type FSResult struct {
@@ -37,11 +66,132 @@ type statInfo struct {
 	ModTime time.Time `json:"mod_time"`
 }
 
+// rangeInfo is the result of a ranged read. BytesRead and TotalSize let the
+// model tell whether more of the file remains.
+type rangeInfo struct {
+	Data      string `json:"data"`
+	Offset    int64  `json:"offset"`
+	BytesRead int64  `json:"bytes_read"`
+	TotalSize int64  `json:"total_size"`
+}
+
+// lineRange is the result of a line-range read. Content has one
+// "N\tline" entry per line so the model can cite file:line.
+type lineRange struct {
+	Content    string `json:"content"`
+	StartLine  int    `json:"start_line"`
+	EndLine    int    `json:"end_line"`
+	TotalLines int    `json:"total_lines"`
+}
+
+// edgeLines is the result of head and tail: the first or last Lines lines
+// of a file, plus its size so the model knows how much it did not see.
+type edgeLines struct {
+	Content   string `json:"content"`
+	Lines     int    `json:"lines"`
+	TotalSize int64  `json:"total_size"`
+}
+
+// changeInfo describes the result of a mutating op so the model has a
+// concrete record of what was created or changed.
+type changeInfo struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Mode string `json:"mode"`
+}
+
+// FSOptions adds safeguards around FS's destructive ops.
+type FSOptions struct {
+	// Confirm, when set, is asked before an op deletes or replaces
+	// something: write over an existing file, delete, rmdir, and move or
+	// copy onto an existing destination. action describes the change, e.g.
+	// "overwrite notes.txt". An error cancels the op and becomes its error
+	// result.
+	Confirm func(action string) error
+
+	// Diff, when set, receives a unified diff of the old and new content
+	// before write replaces an existing file, ahead of any Confirm.
+	Diff io.Writer
+
+	// BackupDir, when set, receives a copy of each file that write,
+	// append, delete, move or copy is about to change or remove, as
+	// BackupDir/<path>.<timestamp>.bak; delete backs up whole directories.
+	// The op fails if its backup does.
+	BackupDir string
+}
+
+// FSWithOptions runs FS with the safeguards in opts.
+func FSWithOptions(req FSRequest, opts FSOptions) FSResult {
+	if opts.Diff != nil && req.Op == "write" {
+		writeDiff(opts.Diff, req.Path, req.Data)
+	}
+	if opts.Confirm != nil {
+		if action := destructiveAction(req); action != "" {
+			if err := opts.Confirm(action); err != nil {
+				return FSResult{OK: false, Error: err.Error()}
+			}
+		}
+	}
+	if opts.BackupDir != "" {
+		if path, follow, tree := backupTarget(req); path != "" {
+			if _, err := backup(opts.BackupDir, path, follow, tree); err != nil {
+				return FSResult{OK: false, Error: "backup failed: " + err.Error()}
+			}
+		}
+	}
+	return FS(req)
+}
+
+// writeDiff writes the diff from the file at path to data, if the file
+// exists. Binary content is only reported as changed.
+func writeDiff(w io.Writer, path, data string) {
+	old, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if bytes.IndexByte(old, 0) >= 0 || strings.IndexByte(data, 0) >= 0 {
+		if string(old) != data {
+			fmt.Fprintf(w, "binary file %s changed\n", path)
+		}
+		return
+	}
+	io.WriteString(w, unifiedDiff(path, string(old), data))
+}
+
+// destructiveAction describes what req would delete or replace, or returns
+// "" when it only reads or creates.
+func destructiveAction(req FSRequest) string {
+	switch req.Op {
+	case "write":
+		if info, err := os.Stat(req.Path); err == nil && !info.IsDir() {
+			return "overwrite " + req.Path
+		}
+	case "delete", "rmdir":
+		if _, err := os.Lstat(req.Path); err == nil {
+			return req.Op + " " + req.Path
+		}
+	case "move", "copy":
+		if _, err := os.Lstat(req.Dest); err == nil && req.Path != "" {
+			return req.Op + " " + req.Path + " over " + req.Dest
+		}
+	}
+	return ""
+}
+
 func FS(req FSRequest) FSResult {
 	switch req.Op {
 	case "read":
+		if req.StartLine != 0 || req.EndLine != 0 {
+			return readLines(req.Path, req.StartLine, req.EndLine)
+		}
+		if req.Offset != 0 || req.Length != 0 {
+			return readRange(req.Path, req.Offset, req.Length)
+		}
 		return readFile(req.Path)
 	case "write":
+		if req.Atomic {
+			return writeFileAtomic(req.Path, req.Data)
+		}
 		return writeFile(req.Path, req.Data)
 	case "append":
 		return appendFile(req.Path, req.Data)
@@ -52,6 +202,9 @@ func FS(req FSRequest) FSResult {
 	case "rmdir":
 		return removeDir(req.Path)
 	case "list":
+		if req.Pattern != "" {
+			return listGlob(req.Path, req.Pattern)
+		}
 		return listDir(req.Path)
 	case "stat":
 		return statPath(req.Path)
@@ -59,6 +212,14 @@ func FS(req FSRequest) FSResult {
 		return movePath(req.Path, req.Dest)
 	case "copy":
 		return copyPath(req.Path, req.Dest)
+	case "chmod":
+		return chmodPath(req.Path, req.Data)
+	case "touch":
+		return touchPath(req.Path)
+	case "head":
+		return headFile(req.Path, req.Lines)
+	case "tail":
+		return tailFile(req.Path, req.Lines)
 	default:
 		return FSResult{OK: false, Error: "unknown op"}
 	}
@@ -75,14 +236,139 @@ func readFile(path string) FSResult {
 	return FSResult{OK: true, Data: string(b)}
 }
 
+func readRange(path string, offset, length int64) FSResult {
+	if path == "" {
+		return FSResult{OK: false, Error: "path is required"}
+	}
+	if offset < 0 || length < 0 {
+		return FSResult{OK: false, Error: "offset and length must not be negative"}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return FSResult{OK: false, Error: err.Error()}
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return FSResult{OK: false, Error: err.Error()}
+	}
+	size := info.Size()
+	if offset > size {
+		return FSResult{OK: false, Error: fmt.Sprintf("offset %d is beyond end of file (size %d)", offset, size)}
+	}
+	// offset <= size here, so size-offset cannot overflow the way
+	// offset+length can for a huge length.
+	if length == 0 || length > size-offset {
+		length = size - offset
+	}
+	buf := make([]byte, length)
+	n, err := f.ReadAt(buf, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return FSResult{OK: false, Error: err.Error()}
+	}
+	return FSResult{OK: true, Data: rangeInfo{
+		Data:      string(buf[:n]),
+		Offset:    offset,
+		BytesRead: int64(n),
+		TotalSize: size,
+	}}
+}
+
+func readLines(path string, start, end int) FSResult {
+	if path == "" {
+		return FSResult{OK: false, Error: "path is required"}
+	}
+	if start < 0 || end < 0 {
+		return FSResult{OK: false, Error: "start_line and end_line must not be negative"}
+	}
+	if end != 0 && start > end {
+		return FSResult{OK: false, Error: "start_line is after end_line"}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return FSResult{OK: false, Error: err.Error()}
+	}
+	text := string(b)
+	// A trailing newline ends the last line rather than starting an empty one.
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if text == "" {
+		lines = nil
+	}
+	total := len(lines)
+	if start == 0 {
+		start = 1
+	}
+	if end == 0 || end > total {
+		end = total
+	}
+
+	var sb strings.Builder
+	for i := start; i <= end; i++ {
+		fmt.Fprintf(&sb, "%d\t%s\n", i, strings.TrimSuffix(lines[i-1], "\r"))
+	}
+	res := lineRange{Content: sb.String(), StartLine: start, EndLine: end, TotalLines: total}
+	if start > end {
+		// Range starts past the end of the file: nothing to return.
+		res.EndLine = start - 1
+	}
+	return FSResult{OK: true, Data: res}
+}
+
 func writeFile(path, data string) FSResult {
 	if path == "" {
 		return FSResult{OK: false, Error: "path is required"}
 	}
+	// The 0644 only applies to new files: WriteFile truncates an existing
+	// file in place, so its mode (e.g. +x on scripts) is kept.
 	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
 		return FSResult{OK: false, Error: err.Error()}
 	}
-	return FSResult{OK: true}
+	return changed(path)
+}
+
+// writeFileAtomic writes data to a temp file in the target's directory and
+// renames it into place. An existing file's permissions are kept. A
+// symlink is followed so the write replaces the file it points to rather
+// than the link itself.
+func writeFileAtomic(path, data string) FSResult {
+	if path == "" {
+		return FSResult{OK: false, Error: "path is required"}
+	}
+	target := path
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		target = resolved
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(target); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".tmp-*")
+	if err != nil {
+		return FSResult{OK: false, Error: err.Error()}
+	}
+	cleanup := func(err error) FSResult {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return FSResult{OK: false, Error: err.Error()}
+	}
+	if _, err := tmp.WriteString(data); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return FSResult{OK: false, Error: err.Error()}
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		os.Remove(tmp.Name())
+		return FSResult{OK: false, Error: err.Error()}
+	}
+	return changed(path)
 }
 
 func appendFile(path, data string) FSResult {
@@ -97,7 +383,7 @@ func appendFile(path, data string) FSResult {
 	if _, err := f.WriteString(data); err != nil {
 		return FSResult{OK: false, Error: err.Error()}
 	}
-	return FSResult{OK: true}
+	return changed(path)
 }
 
 func removeAll(path string) FSResult {
@@ -117,7 +403,7 @@ func makeDir(path string) FSResult {
 	if err := os.MkdirAll(path, 0755); err != nil {
 		return FSResult{OK: false, Error: err.Error()}
 	}
-	return FSResult{OK: true}
+	return changed(path)
 }
 
 func removeDir(path string) FSResult {
@@ -179,7 +465,7 @@ func movePath(src, dst string) FSResult {
 	if err := os.Rename(src, dst); err != nil {
 		return FSResult{OK: false, Error: err.Error()}
 	}
-	return FSResult{OK: true}
+	return changed(dst)
 }
 
 func copyPath(src, dst string) FSResult {
@@ -212,5 +498,62 @@ func copyPath(src, dst string) FSResult {
 	if err := out.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
 		return FSResult{OK: false, Error: err.Error()}
 	}
-	return FSResult{OK: true}
+	return changed(dst)
+}
+
+// chmodPath applies an octal permission string such as "0755" or "644".
+func chmodPath(path, mode string) FSResult {
+	if path == "" {
+		return FSResult{OK: false, Error: "path is required"}
+	}
+	if mode == "" {
+		return FSResult{OK: false, Error: "data must hold an octal mode such as \"0755\""}
+	}
+	n, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || n > 0777 {
+		return FSResult{OK: false, Error: fmt.Sprintf("invalid mode %q: must be octal between 0000 and 0777", mode)}
+	}
+	if err := os.Chmod(path, os.FileMode(n)); err != nil {
+		return FSResult{OK: false, Error: err.Error()}
+	}
+	return changed(path)
+}
+
+// touchPath creates an empty file at path, or sets an existing file's
+// access and modification times to now, like touch(1).
+func touchPath(path string) FSResult {
+	if path == "" {
+		return FSResult{OK: false, Error: "path is required"}
+	}
+	now := time.Now()
+	err := os.Chtimes(path, now, now)
+	if errors.Is(err, os.ErrNotExist) {
+		var f *os.File
+		f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+		if err == nil {
+			err = f.Close()
+		}
+	}
+	if err != nil {
+		return FSResult{OK: false, Error: err.Error()}
+	}
+	return changed(path)
+}
+
+// changed returns a successful result describing path after a mutating op.
+// The op already succeeded, so stat failures only drop the details.
+func changed(path string) FSResult {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return FSResult{OK: true, Data: changeInfo{Path: abs}}
+	}
+	return FSResult{OK: true, Data: changeInfo{
+		Path: abs,
+		Size: info.Size(),
+		Mode: info.Mode().String(),
+	}}
 }
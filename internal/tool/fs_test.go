@@ -0,0 +1,685 @@
+package tool
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMutatingOpsReportChange(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+
+	tests := []struct {
+		name string
+		req  FSRequest
+		path string
+		size int64
+	}{
+		{"write", FSRequest{Op: "write", Path: file, Data: "hello"}, file, 5},
+		{"append", FSRequest{Op: "append", Path: file, Data: "!!"}, file, 7},
+		{"mkdir", FSRequest{Op: "mkdir", Path: filepath.Join(dir, "sub")}, filepath.Join(dir, "sub"), -1},
+		{"copy", FSRequest{Op: "copy", Path: file, Dest: filepath.Join(dir, "b.txt")}, filepath.Join(dir, "b.txt"), 7},
+		{"move", FSRequest{Op: "move", Path: filepath.Join(dir, "b.txt"), Dest: filepath.Join(dir, "c.txt")}, filepath.Join(dir, "c.txt"), 7},
+	}
+	for _, tc := range tests {
+		res := FS(tc.req)
+		if !res.OK {
+			t.Fatalf("%s failed: %s", tc.name, res.Error)
+		}
+		info, ok := res.Data.(changeInfo)
+		if !ok {
+			t.Fatalf("%s: expected changeInfo, got %T", tc.name, res.Data)
+		}
+		if info.Path != tc.path || !filepath.IsAbs(info.Path) {
+			t.Errorf("%s: path = %q, want absolute %q", tc.name, info.Path, tc.path)
+		}
+		if tc.size >= 0 && info.Size != tc.size {
+			t.Errorf("%s: size = %d, want %d", tc.name, info.Size, tc.size)
+		}
+		if info.Mode == "" {
+			t.Errorf("%s: mode is empty", tc.name)
+		}
+	}
+}
+
+func TestChangeInfoRelativePath(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	res := FS(FSRequest{Op: "write", Path: "rel.txt", Data: "x"})
+	if !res.OK {
+		t.Fatalf("write failed: %s", res.Error)
+	}
+	want, _ := filepath.Abs("rel.txt")
+	if got := res.Data.(changeInfo).Path; got != want {
+		t.Fatalf("expected absolute path %q, got %q", want, got)
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(file, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		offset, length int64
+		data           string
+	}{
+		{"middle", 2, 3, "234"},
+		{"to end", 7, 0, "789"},
+		{"length past end", 8, 100, "89"},
+		{"at end", 10, 5, ""},
+		{"huge length", 4, math.MaxInt64, "456789"},
+	}
+	for _, tc := range tests {
+		res := FS(FSRequest{Op: "read", Path: file, Offset: tc.offset, Length: tc.length})
+		if !res.OK {
+			t.Fatalf("%s: read failed: %s", tc.name, res.Error)
+		}
+		info, ok := res.Data.(rangeInfo)
+		if !ok {
+			t.Fatalf("%s: expected rangeInfo, got %T", tc.name, res.Data)
+		}
+		if info.Data != tc.data || info.BytesRead != int64(len(tc.data)) || info.TotalSize != 10 || info.Offset != tc.offset {
+			t.Errorf("%s: got %+v, want data %q", tc.name, info, tc.data)
+		}
+	}
+
+	if res := FS(FSRequest{Op: "read", Path: file, Offset: 11}); res.OK {
+		t.Error("offset past end of file should fail")
+	}
+	if res := FS(FSRequest{Op: "read", Path: file, Offset: -1}); res.OK {
+		t.Error("negative offset should fail")
+	}
+
+	// No range keeps the plain whole-file result.
+	if res := FS(FSRequest{Op: "read", Path: file}); res.Data != "0123456789" {
+		t.Errorf("whole-file read changed: %#v", res.Data)
+	}
+}
+
+func TestReadLines(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "code.go")
+	if err := os.WriteFile(file, []byte("one\ntwo\nthree\nfour\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	noNewline := filepath.Join(dir, "tail.txt")
+	if err := os.WriteFile(noNewline, []byte("a\nb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		start, end int
+		content    string
+		total      int
+	}{
+		{"range", file, 2, 3, "2\ttwo\n3\tthree\n", 4},
+		{"to end", file, 3, 0, "3\tthree\n4\tfour\n", 4},
+		{"past end", file, 3, 99, "3\tthree\n4\tfour\n", 4},
+		{"start past end", file, 10, 12, "", 4},
+		{"no trailing newline", noNewline, 1, 5, "1\ta\n2\tb\n", 2},
+	}
+	for _, tc := range tests {
+		res := FS(FSRequest{Op: "read", Path: tc.path, StartLine: tc.start, EndLine: tc.end})
+		if !res.OK {
+			t.Fatalf("%s: read failed: %s", tc.name, res.Error)
+		}
+		info, ok := res.Data.(lineRange)
+		if !ok {
+			t.Fatalf("%s: expected lineRange, got %T", tc.name, res.Data)
+		}
+		if info.Content != tc.content || info.TotalLines != tc.total {
+			t.Errorf("%s: got %+v, want content %q total %d", tc.name, info, tc.content, tc.total)
+		}
+	}
+
+	if res := FS(FSRequest{Op: "read", Path: file, StartLine: 3, EndLine: 2}); res.OK {
+		t.Error("start_line after end_line should fail")
+	}
+}
+
+func TestAtomicWrite(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.txt")
+	oldContent := strings.Repeat("old ", 64*1024)
+	newContent := strings.Repeat("new ", 64*1024)
+	if err := os.WriteFile(file, []byte(oldContent), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A concurrent reader must only ever observe one full version.
+	stop := make(chan struct{})
+	bad := make(chan string, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			b, err := os.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			if s := string(b); s != oldContent && s != newContent {
+				select {
+				case bad <- fmt.Sprintf("partial read of %d bytes", len(s)):
+				default:
+				}
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 20; i++ {
+		content := newContent
+		if i%2 == 1 {
+			content = oldContent
+		}
+		if res := FS(FSRequest{Op: "write", Path: file, Data: content, Atomic: true}); !res.OK {
+			t.Fatalf("atomic write failed: %s", res.Error)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	select {
+	case msg := <-bad:
+		t.Fatal(msg)
+	default:
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600 preserved", info.Mode().Perm())
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temp files left behind: %v", entries)
+	}
+}
+
+func TestWritePreservesMode(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "run.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(script, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, atomic := range []bool{false, true} {
+		if res := FS(FSRequest{Op: "write", Path: script, Data: "#!/bin/sh\necho hi\n", Atomic: atomic}); !res.OK {
+			t.Fatalf("write (atomic=%v) failed: %s", atomic, res.Error)
+		}
+		info, err := os.Stat(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0755 {
+			t.Fatalf("write (atomic=%v): mode = %v, want 0755", atomic, info.Mode().Perm())
+		}
+	}
+
+	fresh := filepath.Join(dir, "new.txt")
+	if res := FS(FSRequest{Op: "write", Path: fresh, Data: "x"}); !res.OK {
+		t.Fatalf("write failed: %s", res.Error)
+	}
+	info, err := os.Stat(fresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0111 != 0 {
+		t.Fatalf("new file should not be executable: %v", info.Mode().Perm())
+	}
+}
+
+func TestAtomicWriteSymlink(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "real", "config.txt")
+	if err := os.WriteFile(target, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.txt")
+	if err := os.Symlink(filepath.Join("real", "config.txt"), link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+
+	if res := FS(FSRequest{Op: "write", Path: link, Data: "new", Atomic: true}); !res.OK {
+		t.Fatalf("atomic write failed: %s", res.Error)
+	}
+	info, err := os.Lstat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("symlink replaced by a regular file")
+	}
+	if b, _ := os.ReadFile(target); string(b) != "new" {
+		t.Fatalf("target content = %q, want new", b)
+	}
+	if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("target mode not preserved: %v, %v", info, err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "real"))
+	if len(entries) != 1 {
+		t.Errorf("temp files left behind: %v", entries)
+	}
+}
+
+func TestChmod(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "run.sh")
+	if err := os.WriteFile(file, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []string{"0755", "700"} {
+		res := FS(FSRequest{Op: "chmod", Path: file, Data: mode})
+		if !res.OK {
+			t.Fatalf("chmod %s failed: %s", mode, res.Error)
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := strconv.ParseUint(mode, 8, 32)
+		if info.Mode().Perm() != os.FileMode(want) {
+			t.Errorf("chmod %s: mode = %v", mode, info.Mode().Perm())
+		}
+	}
+
+	for _, mode := range []string{"", "rwx", "0999", "01777", "-1"} {
+		if res := FS(FSRequest{Op: "chmod", Path: file, Data: mode}); res.OK {
+			t.Errorf("chmod %q should be rejected", mode)
+		}
+	}
+	if res := FS(FSRequest{Op: "chmod", Path: filepath.Join(t.TempDir(), "missing"), Data: "0644"}); res.OK {
+		t.Error("chmod on a missing file should fail")
+	}
+}
+
+func TestListPattern(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"main.go", "notes.txt", "src/a.go", "src/b.txt", "src/deep/c.txt", "src/deep/d.go"} {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"*.go", []string{"main.go"}},
+		{"**/*.txt", []string{"notes.txt", "src/b.txt", "src/deep/c.txt"}},
+		{"src/**/*.go", []string{"src/a.go", "src/deep/d.go"}},
+		{"src/*", []string{"src/a.go", "src/b.txt", "src/deep"}},
+		{"*.rs", nil},
+		{"**/*.rs", nil},
+	}
+	for _, tc := range tests {
+		res := FS(FSRequest{Op: "list", Path: dir, Pattern: tc.pattern})
+		if !res.OK {
+			t.Fatalf("%s: list failed: %s", tc.pattern, res.Error)
+		}
+		var got []string
+		for _, e := range res.Data.([]entry) {
+			got = append(got, e.Name)
+		}
+		if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+			t.Errorf("%s: got %v, want %v", tc.pattern, got, tc.want)
+		}
+	}
+
+	if res := FS(FSRequest{Op: "list", Path: dir, Pattern: "[a-"}); res.OK {
+		t.Error("malformed pattern should be rejected")
+	}
+}
+
+func TestTouch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "new.txt")
+
+	res := FS(FSRequest{Op: "touch", Path: file})
+	if !res.OK {
+		t.Fatalf("touch failed: %s", res.Error)
+	}
+	info, err := os.Stat(file)
+	if err != nil || info.Size() != 0 {
+		t.Fatalf("expected an empty file, got %v, %v", info, err)
+	}
+
+	if err := os.WriteFile(file, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(file, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if res := FS(FSRequest{Op: "touch", Path: file}); !res.OK {
+		t.Fatalf("touch failed: %s", res.Error)
+	}
+	info, err = os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().After(old) {
+		t.Fatalf("mtime not updated: %v", info.ModTime())
+	}
+	if b, _ := os.ReadFile(file); string(b) != "keep" {
+		t.Fatalf("touch changed the content: %q", b)
+	}
+
+	if res := FS(FSRequest{Op: "touch", Path: filepath.Join(t.TempDir(), "missing", "x")}); res.OK {
+		t.Fatal("touch in a missing directory should fail")
+	}
+}
+
+func TestHeadTail(t *testing.T) {
+	dir := t.TempDir()
+	var sb strings.Builder
+	for i := 1; i <= 2000; i++ {
+		fmt.Fprintf(&sb, "line %d\n", i)
+	}
+	log := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(log, []byte(sb.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	short := filepath.Join(dir, "short.txt")
+	if err := os.WriteFile(short, []byte("one\ntwo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		req   FSRequest
+		want  string
+		lines int
+	}{
+		{FSRequest{Op: "head", Path: log, Lines: 3}, "line 1\nline 2\nline 3\n", 3},
+		{FSRequest{Op: "tail", Path: log, Lines: 3}, "line 1998\nline 1999\nline 2000\n", 3},
+		{FSRequest{Op: "tail", Path: log, Lines: 1000}, strings.SplitAfterN(sb.String(), "\n", 1001)[1000], 1000},
+		{FSRequest{Op: "head", Path: short, Lines: 5}, "one\ntwo", 2},
+		{FSRequest{Op: "tail", Path: short, Lines: 5}, "one\ntwo", 2},
+		{FSRequest{Op: "tail", Path: short, Lines: 1}, "two", 1},
+	}
+	for _, tc := range tests {
+		res := FS(tc.req)
+		if !res.OK {
+			t.Fatalf("%s %d: %s", tc.req.Op, tc.req.Lines, res.Error)
+		}
+		got := res.Data.(edgeLines)
+		if got.Content != tc.want || got.Lines != tc.lines {
+			t.Errorf("%s %d of %s: got %d lines %.40q, want %d lines %.40q", tc.req.Op, tc.req.Lines, filepath.Base(tc.req.Path), got.Lines, got.Content, tc.lines, tc.want)
+		}
+	}
+
+	res := FS(FSRequest{Op: "tail", Path: log})
+	if got := res.Data.(edgeLines); got.Lines != DefaultEdgeLines || got.TotalSize != int64(sb.Len()) {
+		t.Fatalf("default tail: got %d lines, size %d", got.Lines, got.TotalSize)
+	}
+	if res := FS(FSRequest{Op: "head", Path: dir}); res.OK {
+		t.Fatal("head on a directory should fail")
+	}
+}
+
+func TestFSConfirm(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "a.txt")
+	other := filepath.Join(dir, "b.txt")
+	sub := filepath.Join(dir, "sub")
+	for _, p := range []string{existing, other} {
+		if err := os.WriteFile(p, []byte("keep"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var asked []string
+	deny := FSOptions{Confirm: func(action string) error {
+		asked = append(asked, action)
+		return fmt.Errorf("%s declined", action)
+	}}
+
+	// Ops that delete or replace something are refused and leave it alone.
+	for _, req := range []FSRequest{
+		{Op: "write", Path: existing, Data: "new"},
+		{Op: "delete", Path: existing},
+		{Op: "rmdir", Path: sub},
+		{Op: "move", Path: other, Dest: existing},
+		{Op: "copy", Path: other, Dest: existing},
+	} {
+		if res := FSWithOptions(req, deny); res.OK || !strings.Contains(res.Error, "declined") {
+			t.Errorf("%s: expected refusal, got %+v", req.Op, res)
+		}
+	}
+	if b, _ := os.ReadFile(existing); string(b) != "keep" {
+		t.Fatalf("refused ops changed the file: %q", b)
+	}
+	if _, err := os.Stat(sub); err != nil {
+		t.Fatalf("refused rmdir removed the directory: %v", err)
+	}
+	want := []string{"overwrite " + existing, "delete " + existing, "rmdir " + sub, "move " + other + " over " + existing, "copy " + other + " over " + existing}
+	if strings.Join(asked, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("asked:\n%s\nwant:\n%s", strings.Join(asked, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Creating, appending, and moving or copying to a new path need no
+	// confirmation.
+	asked = nil
+	for _, req := range []FSRequest{
+		{Op: "write", Path: filepath.Join(dir, "new.txt"), Data: "x"},
+		{Op: "append", Path: existing, Data: "!"},
+		{Op: "copy", Path: other, Dest: filepath.Join(dir, "copied.txt")},
+		{Op: "move", Path: other, Dest: filepath.Join(dir, "moved.txt")},
+		{Op: "delete", Path: filepath.Join(dir, "missing")},
+	} {
+		FSWithOptions(req, deny)
+	}
+	if len(asked) != 0 {
+		t.Fatalf("asked for non-destructive ops: %q", asked)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	new := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn"
+	want := `--- f.txt
++++ f.txt
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -11,3 +11,4 @@
+ k
+ l
+ m
++n
+\ No newline at end of file
+`
+	if got := unifiedDiff("f.txt", old, new); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := unifiedDiff("f.txt", "x\n", "x\n"); got != "" {
+		t.Errorf("equal content should give no diff, got %q", got)
+	}
+	want = "--- f.txt\n+++ f.txt\n@@ -0,0 +1,2 @@\n+x\n+y\n"
+	if got := unifiedDiff("f.txt", "", "x\ny\n"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFSShowDiff(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("one\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var diff strings.Builder
+	opts := FSOptions{Diff: &diff}
+
+	if res := FSWithOptions(FSRequest{Op: "write", Path: path, Data: "one\n2\n"}, opts); !res.OK {
+		t.Fatalf("write failed: %s", res.Error)
+	}
+	if !strings.Contains(diff.String(), "-two\n+2\n") {
+		t.Fatalf("missing diff: %q", diff.String())
+	}
+
+	diff.Reset()
+	if res := FSWithOptions(FSRequest{Op: "write", Path: filepath.Join(dir, "new.txt"), Data: "x\n"}, opts); !res.OK {
+		t.Fatalf("write failed: %s", res.Error)
+	}
+	if diff.Len() != 0 {
+		t.Fatalf("new file should not be diffed: %q", diff.String())
+	}
+}
+
+func TestFSBackup(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	opts := FSOptions{BackupDir: "backups"}
+	write := func(path, data string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// backups returns the contents of the backups of path.
+	backups := func(path string) []string {
+		t.Helper()
+		matches, err := filepath.Glob(filepath.Join("backups", path) + ".*.bak")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var contents []string
+		for _, m := range matches {
+			b, err := os.ReadFile(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents = append(contents, string(b))
+		}
+		return contents
+	}
+
+	write("src/a.txt", "original")
+	for _, req := range []FSRequest{
+		{Op: "write", Path: "src/a.txt", Data: "rewritten"},
+		{Op: "append", Path: "src/a.txt", Data: "!"},
+	} {
+		if res := FSWithOptions(req, opts); !res.OK {
+			t.Fatalf("%s failed: %s", req.Op, res.Error)
+		}
+	}
+	got := backups("src/a.txt")
+	sort.Strings(got)
+	if len(got) != 2 || got[0] != "original" || got[1] != "rewritten" {
+		t.Fatalf("write and append backups = %q", got)
+	}
+
+	write("b.txt", "moved")
+	write("c.txt", "replaced")
+	if res := FSWithOptions(FSRequest{Op: "move", Path: "b.txt", Dest: "c.txt"}, opts); !res.OK {
+		t.Fatalf("move failed: %s", res.Error)
+	}
+	if got := backups("c.txt"); len(got) != 1 || got[0] != "replaced" {
+		t.Fatalf("move backup = %q", got)
+	}
+	if got := backups("b.txt"); len(got) != 0 {
+		t.Fatalf("move source needs no backup, got %q", got)
+	}
+
+	write("e.txt", "copied")
+	write("f.txt", "overwritten by copy")
+	if res := FSWithOptions(FSRequest{Op: "copy", Path: "e.txt", Dest: "f.txt"}, opts); !res.OK {
+		t.Fatalf("copy failed: %s", res.Error)
+	}
+	if got := backups("f.txt"); len(got) != 1 || got[0] != "overwritten by copy" {
+		t.Fatalf("copy backup = %q", got)
+	}
+
+	// Writing through a symlink changes its target, so that is backed up.
+	write("target.txt", "behind the link")
+	if err := os.Symlink("target.txt", "link.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if res := FSWithOptions(FSRequest{Op: "write", Path: "link.txt", Data: "new"}, opts); !res.OK {
+		t.Fatalf("write failed: %s", res.Error)
+	}
+	if got := backups("link.txt"); len(got) != 1 || got[0] != "behind the link" {
+		t.Fatalf("symlink write backup = %q", got)
+	}
+
+	write("tree/sub/d.txt", "deep")
+	if res := FSWithOptions(FSRequest{Op: "delete", Path: "tree"}, opts); !res.OK {
+		t.Fatalf("delete failed: %s", res.Error)
+	}
+	if got := backups("tree/sub/d.txt"); len(got) != 0 {
+		t.Fatalf("files inside a deleted tree are not backed up one by one: %q", got)
+	}
+	matches, _ := filepath.Glob(filepath.Join("backups", "tree.*.bak", "sub", "d.txt"))
+	if len(matches) != 1 {
+		t.Fatalf("deleted directory not backed up: %v", matches)
+	}
+	if b, _ := os.ReadFile(matches[0]); string(b) != "deep" {
+		t.Fatalf("tree backup content = %q", b)
+	}
+
+	if res := FSWithOptions(FSRequest{Op: "write", Path: "new.txt", Data: "x"}, opts); !res.OK {
+		t.Fatalf("write failed: %s", res.Error)
+	}
+	if got := backups("new.txt"); len(got) != 0 {
+		t.Fatalf("new file backed up: %q", got)
+	}
+
+	// Deleting the directory that holds the backups is refused.
+	if res := FSWithOptions(FSRequest{Op: "delete", Path: "."}, opts); res.OK || !strings.Contains(res.Error, "backup failed") {
+		t.Fatalf("expected refusal, got %+v", res)
+	}
+	if _, err := os.Stat("backups"); err != nil {
+		t.Fatalf("backups removed: %v", err)
+	}
+}
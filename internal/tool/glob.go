@@ -0,0 +1,80 @@
+package tool
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// listGlob lists the entries under root whose slash-separated path relative
+// to root matches pattern. Segments follow path.Match, and a "**" segment
+// matches any number of directories, including none. Patterns without "/"
+// or "**" only match root's direct children, as in a shell.
+func listGlob(root, pattern string) FSResult {
+	if root == "" {
+		root = "."
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return FSResult{OK: false, Error: "invalid pattern: " + err.Error()}
+	}
+	recursive := strings.Contains(pattern, "/") || strings.Contains(pattern, "**")
+	want := strings.Split(pattern, "/")
+
+	out := []entry{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if matchSegments(want, strings.Split(rel, "/")) {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			out = append(out, entry{
+				Name:    rel,
+				IsDir:   d.IsDir(),
+				Size:    info.Size(),
+				Mode:    info.Mode().String(),
+				ModTime: info.ModTime(),
+			})
+		}
+		if d.IsDir() && !recursive {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return FSResult{OK: false, Error: err.Error()}
+	}
+	return FSResult{OK: true, Data: out}
+}
+
+// matchSegments reports whether the path segments match the pattern
+// segments, where "**" stands for zero or more whole segments.
+func matchSegments(pattern, segs []string) bool {
+	if len(pattern) == 0 {
+		return len(segs) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if matchSegments(pattern[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], segs[0])
+	return ok && matchSegments(pattern[1:], segs[1:])
+}
@@ -0,0 +1,219 @@
+package update
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// releasesURL is the GitHub API endpoint for the latest release.
+var releasesURL = "https://api.github.com/repos/sirsjg/gogo/releases/latest"
+
+const (
+	binaryName    = "gogo"
+	checksumsName = "checksums.txt"
+)
+
+// release represents the relevant parts of the GitHub release API response
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+var httpClient = &http.Client{Timeout: 2 * time.Minute}
+
+// SelfUpdate downloads the latest release for the current OS/arch, verifies
+// its checksum, and atomically replaces the running binary. Homebrew installs
+// and binaries that aren't writable fall back to the Homebrew upgrade message.
+func SelfUpdate(w io.Writer, currentVersion string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate running binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	if strings.Contains(exe, "/Cellar/") || !dirWritable(filepath.Dir(exe)) {
+		return Check(w, currentVersion)
+	}
+
+	rel, err := latestRelease()
+	if err != nil {
+		return err
+	}
+
+	current := normalizeVersion(currentVersion)
+	available := normalizeVersion(rel.TagName)
+	if compareVersions(available, current) <= 0 {
+		fmt.Fprintf(w, "gogo %s → %s (up to date)\n", current, available)
+		return nil
+	}
+
+	archive := assetName(available, runtime.GOOS, runtime.GOARCH)
+	archiveURL, checksumsURL := "", ""
+	for _, a := range rel.Assets {
+		switch a.Name {
+		case archive:
+			archiveURL = a.URL
+		case checksumsName:
+			checksumsURL = a.URL
+		}
+	}
+	if archiveURL == "" {
+		return fmt.Errorf("no release asset %s for %s/%s", archive, runtime.GOOS, runtime.GOARCH)
+	}
+	if checksumsURL == "" {
+		return fmt.Errorf("release %s has no %s", rel.TagName, checksumsName)
+	}
+
+	sums, err := download(checksumsURL)
+	if err != nil {
+		return err
+	}
+	want, err := findChecksum(sums, archive)
+	if err != nil {
+		return err
+	}
+	data, err := download(archiveURL)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", archive, got, want)
+	}
+
+	bin, err := extractBinary(data)
+	if err != nil {
+		return err
+	}
+	if err := replaceBinary(exe, bin); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "gogo %s → %s%s%s (updated %s)\n", current, bold, available, reset, exe)
+	return nil
+}
+
+// assetName returns the goreleaser archive name for a version and platform.
+func assetName(version, goos, goarch string) string {
+	arch := goarch
+	if arch == "amd64" {
+		arch = "x86_64"
+	}
+	osName := goos
+	if osName != "" {
+		osName = strings.ToUpper(osName[:1]) + osName[1:]
+	}
+	return fmt.Sprintf("%s_%s_%s_%s.tar.gz", binaryName, version, osName, arch)
+}
+
+func latestRelease() (release, error) {
+	b, err := download(releasesURL)
+	if err != nil {
+		return release{}, fmt.Errorf("failed to fetch latest release: %w", err)
+	}
+	var rel release
+	if err := json.Unmarshal(b, &rel); err != nil {
+		return release{}, fmt.Errorf("failed to parse release info: %w", err)
+	}
+	if rel.TagName == "" {
+		return release{}, errors.New("latest release has no tag")
+	}
+	return rel, nil
+}
+
+func download(url string) ([]byte, error) {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: HTTP %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// findChecksum returns the sha256 for name from a goreleaser checksums file.
+func findChecksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+// extractBinary returns the gogo executable from a tar.gz archive.
+func extractBinary(archive []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s not found in archive", binaryName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeReg && filepath.Base(hdr.Name) == binaryName {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// replaceBinary writes bin next to exe and renames it into place, which is
+// atomic on the same filesystem.
+func replaceBinary(exe string, bin []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(exe), "."+binaryName+"-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(bin); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), exe)
+}
+
+func dirWritable(dir string) bool {
+	f, err := os.CreateTemp(dir, "."+binaryName+"-probe-*")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
@@ -0,0 +1,74 @@
+package update
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssetName(t *testing.T) {
+	tests := []struct {
+		goos, goarch, want string
+	}{
+		{"linux", "amd64", "gogo_1.2.3_Linux_x86_64.tar.gz"},
+		{"darwin", "arm64", "gogo_1.2.3_Darwin_arm64.tar.gz"},
+	}
+	for _, tc := range tests {
+		if got := assetName("1.2.3", tc.goos, tc.goarch); got != tc.want {
+			t.Errorf("assetName(%s, %s) = %q, want %q", tc.goos, tc.goarch, got, tc.want)
+		}
+	}
+}
+
+func TestFindChecksum(t *testing.T) {
+	sums := []byte("abc123  gogo_1.2.3_Linux_x86_64.tar.gz\nDEF456  gogo_1.2.3_Darwin_arm64.tar.gz\n")
+	got, err := findChecksum(sums, "gogo_1.2.3_Darwin_arm64.tar.gz")
+	if err != nil {
+		t.Fatalf("findChecksum returned error: %v", err)
+	}
+	if got != "def456" {
+		t.Fatalf("unexpected checksum: %s", got)
+	}
+	if _, err := findChecksum(sums, "missing.tar.gz"); err == nil {
+		t.Fatal("expected error for missing asset")
+	}
+}
+
+func TestExtractAndReplaceBinary(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range map[string]string{"README.md": "readme", "gogo": "new-binary"} {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+
+	bin, err := extractBinary(buf.Bytes())
+	if err != nil {
+		t.Fatalf("extractBinary returned error: %v", err)
+	}
+	if string(bin) != "new-binary" {
+		t.Fatalf("unexpected binary content: %q", bin)
+	}
+
+	exe := filepath.Join(t.TempDir(), "gogo")
+	if err := os.WriteFile(exe, []byte("old-binary"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceBinary(exe, bin); err != nil {
+		t.Fatalf("replaceBinary returned error: %v", err)
+	}
+	got, _ := os.ReadFile(exe)
+	if string(got) != "new-binary" {
+		t.Fatalf("binary not replaced: %q", got)
+	}
+	info, _ := os.Stat(exe)
+	if info.Mode().Perm() != 0755 {
+		t.Fatalf("unexpected mode: %v", info.Mode())
+	}
+}
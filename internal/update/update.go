@@ -85,11 +85,15 @@ func normalizeVersion(v string) string {
 	return strings.TrimPrefix(v, "v")
 }
 
-// compareVersions compares two semver strings
+// compareVersions compares two semver strings, including pre-release
+// precedence (1.2.0-rc1 < 1.2.0). Build metadata (+...) is ignored.
 // Returns: >0 if a > b, <0 if a < b, 0 if equal
 func compareVersions(a, b string) int {
-	partsA := strings.Split(a, ".")
-	partsB := strings.Split(b, ".")
+	coreA, preA := splitVersion(a)
+	coreB, preB := splitVersion(b)
+
+	partsA := strings.Split(coreA, ".")
+	partsB := strings.Split(coreB, ".")
 
 	maxLen := len(partsA)
 	if len(partsB) > maxLen {
@@ -113,5 +117,64 @@ func compareVersions(a, b string) int {
 		}
 	}
 
+	return comparePrerelease(preA, preB)
+}
+
+// splitVersion separates the core version from its pre-release, dropping
+// any build metadata.
+func splitVersion(v string) (core, pre string) {
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		return v[:i], v[i+1:]
+	}
+	return v, ""
+}
+
+// comparePrerelease orders pre-release strings per semver: a release (empty)
+// outranks any pre-release, numeric identifiers compare numerically and rank
+// below alphanumeric ones, and a shorter identifier list ranks lower.
+func comparePrerelease(a, b string) int {
+	if a == b {
+		return 0
+	}
+	if a == "" {
+		return 1
+	}
+	if b == "" {
+		return -1
+	}
+
+	idsA := strings.Split(a, ".")
+	idsB := strings.Split(b, ".")
+	for i := 0; i < len(idsA) && i < len(idsB); i++ {
+		numA, errA := strconv.Atoi(idsA[i])
+		numB, errB := strconv.Atoi(idsB[i])
+		switch {
+		case errA == nil && errB == nil:
+			if numA != numB {
+				if numA > numB {
+					return 1
+				}
+				return -1
+			}
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		default:
+			if c := strings.Compare(idsA[i], idsB[i]); c != 0 {
+				return c
+			}
+		}
+	}
+
+	switch {
+	case len(idsA) > len(idsB):
+		return 1
+	case len(idsA) < len(idsB):
+		return -1
+	}
 	return 0
 }
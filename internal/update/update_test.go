@@ -0,0 +1,55 @@
+package update
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.0", "1.2.0", 0},
+		{"1.2.1", "1.2.0", 1},
+		{"1.10.0", "1.9.0", 1},
+		{"0.9.9", "1.0.0", -1},
+
+		// Differing segment counts
+		{"1.2", "1.2.0", 0},
+		{"1.2.0.1", "1.2.0", 1},
+		{"2", "1.9.9", 1},
+
+		// Pre-release is lower than its release
+		{"1.2.0-rc1", "1.2.0", -1},
+		{"1.2.0", "1.2.0-rc1", 1},
+		{"1.2.0-rc1", "1.1.9", 1},
+
+		// Pre-release ordering
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta.2", "1.0.0-beta.11", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0-rc.1", "1.0.0-rc.1", 0},
+
+		// Build metadata is ignored
+		{"1.2.0+build5", "1.2.0", 0},
+		{"1.2.0+build5", "1.2.0+build9", 0},
+		{"1.2.0-rc1+build5", "1.2.0-rc1", 0},
+		{"1.2.1+build1", "1.2.0+build9", 1},
+	}
+
+	sign := func(n int) int {
+		switch {
+		case n > 0:
+			return 1
+		case n < 0:
+			return -1
+		}
+		return 0
+	}
+
+	for _, tc := range tests {
+		if got := sign(compareVersions(tc.a, tc.b)); got != tc.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
@@ -1,15 +1,33 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
+	"strconv"
+	"strings"
 
+	"gogo/internal/budget"
+	"gogo/internal/cache"
+	"gogo/internal/cancel"
+	"gogo/internal/compare"
 	"gogo/internal/config"
+	"gogo/internal/history"
+	"gogo/internal/keyring"
 	"gogo/internal/plugin"
 	"gogo/internal/prompt"
 	"gogo/internal/provider"
+	"gogo/internal/redact"
+	"gogo/internal/render"
+	"gogo/internal/spinner"
+	"gogo/internal/tokenize"
 	"gogo/internal/update"
 )
 
@@ -20,28 +38,282 @@ var (
 	date    = "unknown"
 )
 
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// commaList is a repeatable flag that also splits each value on commas.
+type commaList []string
+
+func (s *commaList) String() string { return strings.Join(*s, ",") }
+
+func (s *commaList) Set(v string) error {
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			*s = append(*s, part)
+		}
+	}
+	return nil
+}
+
+// summarizeFlag is --summarize, whose style is optional: a bare
+// --summarize picks the default style.
+type summarizeFlag string
+
+func (s *summarizeFlag) String() string { return string(*s) }
+
+func (s *summarizeFlag) IsBoolFlag() bool { return true }
+
+func (s *summarizeFlag) Set(v string) error {
+	if v == "true" {
+		v = prompt.DefaultSummaryStyle
+	}
+	*s = summarizeFlag(v)
+	return nil
+}
+
+// joinSummarizeStyle rewrites "--summarize <style>" as "--summarize=<style>".
+// The flag package never gives a boolean-style flag the next argument, so
+// without this the style would end flag parsing as the first positional
+// argument. Only known styles are joined; anything else is left as the
+// prompt.
+func joinSummarizeStyle(args []string) []string {
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		if (arg == "--summarize" || arg == "-summarize") && i+1 < len(args) && args[i+1] != "" {
+			if _, err := prompt.SummaryPrompt(args[i+1]); err == nil {
+				arg += "=" + args[i+1]
+				i++
+			}
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// Exit codes for provider failures that scripts may want to tell apart
+// from the generic 1.
+const (
+	exitAuth      = 3
+	exitRetryable = 4
+)
+
+// providerExitCode maps a provider error to the process exit code:
+// exitAuth for rejected credentials, exitRetryable for rate limits,
+// server errors and network failures, and 1 otherwise.
+func providerExitCode(err error) int {
+	var pe *provider.ProviderError
+	if errors.As(err, &pe) {
+		switch {
+		case pe.Auth():
+			return exitAuth
+		case pe.Retryable():
+			return exitRetryable
+		}
+	}
+	return 1
+}
+
+// loadTools loads the built-in and user tools and narrows them to what
+// --tools and --raw allow, exiting on errors. Skipped tool definitions are
+// printed when showWarnings is set.
+func loadTools(cfg config.Config, flags config.Flags, showWarnings bool) *plugin.Registry {
+	plugin.SetStripANSI(flags.StripANSI)
+	plugin.SetShellPolicy(flags.ConfirmShell, flags.Yes)
+	plugin.SetFSPolicy(flags.ConfirmFS, flags.Yes)
+	plugin.SetShowDiff(flags.ShowDiff)
+	plugin.SetBackupDir(flags.BackupDir)
+	tools, warnings, err := plugin.LoadWithBuiltins(cfg.Builtins)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "plugin error:", err)
+		os.Exit(1)
+	}
+	if showWarnings {
+		for _, w := range warnings {
+			fmt.Fprintln(os.Stderr, "plugin warning:", w)
+		}
+	}
+	for _, name := range flags.Tools {
+		if _, ok := tools.Get(name); !ok {
+			available := tools.Names()
+			fmt.Fprintf(os.Stderr, "plugin error: unknown tool %q (available: %s)\n", name, strings.Join(available, ", "))
+			os.Exit(1)
+		}
+	}
+	tools = tools.Filter(flags.Tools)
+	if cfg.Raw {
+		tools = plugin.NewRegistry()
+	}
+	return tools
+}
+
+// setKey reads an API key from stdin and stores it in the OS keyring for
+// provider. On a terminal it prompts and turns off echo while the key is
+// typed.
+func setKey(provider string) error {
+	switch provider {
+	case "openai", "anthropic", "gemini", "openai-compatible":
+	default:
+		return fmt.Errorf("unknown provider %q", provider)
+	}
+	if render.IsTerminal(os.Stdin) {
+		fmt.Fprintf(os.Stderr, "API key for %s: ", provider)
+		stty := func(arg string) {
+			cmd := exec.Command("stty", arg)
+			cmd.Stdin = os.Stdin
+			cmd.Run()
+		}
+		stty("-echo")
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(os.Stderr)
+		}()
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	key := strings.TrimSpace(line)
+	if key == "" {
+		return errors.New("no key on stdin")
+	}
+	return keyring.Set(provider, key)
+}
+
+// cacheParams returns, as JSON, every setting besides cache.Key's own
+// fields that can change the response, so runs differing in any of them
+// never share a cache entry.
+func cacheParams(cfg config.Config, tools *plugin.Registry) string {
+	schemas := make(map[string]any)
+	for _, t := range tools.All() {
+		schemas[t.Name] = t.InputSchema
+	}
+	b, _ := json.Marshal(struct {
+		MaxTokens        int               `json:"max_tokens,omitempty"`
+		TopP             float64           `json:"top_p,omitempty"`
+		TopK             int               `json:"top_k,omitempty"`
+		Stop             []string          `json:"stop,omitempty"`
+		FinalTemperature *float64          `json:"final_temperature,omitempty"`
+		MaxToolRounds    int               `json:"max_tool_rounds,omitempty"`
+		StrictTools      bool              `json:"strict_tools,omitempty"`
+		NoStream         bool              `json:"no_stream,omitempty"`
+		Raw              bool              `json:"raw,omitempty"`
+		BaseURL          string            `json:"base_url,omitempty"`
+		CompatTools      bool              `json:"compat_tools,omitempty"`
+		Headers          map[string]string `json:"headers,omitempty"`
+		ParamMap         map[string]string `json:"param_map,omitempty"`
+		ReasoningEffort  string            `json:"reasoning_effort,omitempty"`
+		ThinkingBudget   *int              `json:"thinking_budget,omitempty"`
+		ToolSchemas      map[string]any    `json:"tool_schemas,omitempty"`
+	}{
+		MaxTokens:        cfg.MaxTokens,
+		TopP:             cfg.TopP,
+		TopK:             cfg.TopK,
+		Stop:             cfg.Stop,
+		FinalTemperature: cfg.FinalTemperature,
+		MaxToolRounds:    cfg.MaxToolRounds,
+		StrictTools:      cfg.StrictTools,
+		NoStream:         cfg.NoStream,
+		Raw:              cfg.Raw,
+		BaseURL:          cfg.BaseURL,
+		CompatTools:      cfg.CompatTools,
+		Headers:          cfg.Headers,
+		ParamMap:         cfg.ParamMap,
+		ReasoningEffort:  cfg.ReasoningEffort,
+		ThinkingBudget:   cfg.ThinkingBudget,
+		ToolSchemas:      schemas,
+	})
+	return string(b)
+}
+
 func printUsage() {
 	fmt.Fprintf(os.Stderr, `gogo %s - streaming LLM CLI
 
-Usage: gogo [options] [-p prompt | < input]
+Usage: gogo [options] [-p prompt | --prompt-file path | < input] [--file path...]
 
 Options:
-  -p, --prompt <text>       Inline prompt (if empty, reads from stdin)
-  -P, --provider <name>     Provider: openai | anthropic | gemini
+  -p, --prompt <text>       Inline prompt; piped stdin is appended after a blank line
+      --prompt-file <path>  Read the prompt from a file (below -p, above stdin)
+      --stdin-first         Put piped stdin before the -p text instead of after
+      --allow-binary        Send piped stdin even if it looks like binary data
+      --file <path>         Add a file to the prompt under a === path === header (repeatable)
+  -P, --provider <name>     Provider: openai | anthropic | gemini | openai-compatible
   -m, --model <name>        Model name (provider-specific defaults)
-  -M, --max-tokens <n>      Maximum output tokens
+      --base-url <url>      API root for openai-compatible (e.g. https://api.groq.com/openai/v1)
+  -M, --max-tokens <n>      Maximum output tokens (anthropic default 4096)
   -T, --temperature <n>     Sampling temperature (0.0 - 2.0)
+      --top-p <n>           Nucleus sampling probability (0.0 - 1.0)
+      --top-k <n>           Sample from the top k tokens (anthropic, gemini)
+      --thinking-budget <n> Thinking tokens for gemini 2.x (0 off, -1 dynamic)
+      --reasoning-effort    Reasoning effort for openai: low | medium | high
+      --max-tool-rounds <n> Rounds of tool calls before stopping (default 1)
+      --no-stream           Request one complete response instead of a stream (no tools)
+      --raw                 Send only the prompt: no system prompt, tools or tool instructions
+      --compare <a,b>       Send the prompt to several providers (provider[:model]) concurrently
+      --continue <id>       Continue from an OpenAI response ID printed by an earlier run
+      --strict-tools        Abort with an error when any tool call fails
+      --stop <seq>          Stop generating at this sequence (repeatable)
+      --header <k: v>       Add a header to provider requests; $VAR expands (repeatable)
   -c, --config <path>       Path to config.json
-  -t, --timeout <duration>  Request timeout (e.g., 30s, 1m)
-  -d, --debug               Enable verbose stderr logging
+      --env-file <path>     Load variables from a .env file (set env vars win)
+  -t, --timeout <duration>  Timeout for the whole run, all tool rounds included (e.g., 30s, 1m)
+      --request-timeout <d> Timeout for each provider request
+      --idle-timeout <dur>  Abort if the stream sends no event for this long (default 60s)
+      --budget-calls <n>    Stop after n API calls in this run
+      --budget-usd <x>      Stop once estimated spend reaches $x in this run
+      --cancel-file <path>  Cancel the request when this file appears or is touched
+      --cache               Replay identical temperature-0 requests from ~/.cache/gogo
+      --no-cache            Disable the response cache (overrides --cache)
+      --cache-ttl <dur>     How long cached responses stay valid (default 24h)
+  -s, --system <text>       System prompt (placed before the tool instructions)
+      --system-file <path>  Read the system prompt from a file (-s takes precedence)
+      --append-system <txt> System text placed after the tool instructions (not with -s)
+      --cache-system        Cache the system prompt on the provider side (anthropic)
+      --doc <path>          Attach a PDF or text document (anthropic, gemini; repeatable)
+      --history <file>      Load prior turns from a JSONL file of {role, content}
+      --history-append      Append this prompt and response to the --history file
+      --load-transcript <p> Continue from a JSON transcript, tool turns included
+      --save-transcript <p> Write history, prompt, responses and tool turns as JSON
+      --tools <a,b>         Only expose the named tools to the model (default: all)
+      --list-tools          Print the tools that would be offered, builtins first, and exit
+      --confirm-shell       Enable the shell tool, asking y/n on the terminal per command
+      --yes                 Answer yes to --confirm-shell and --confirm-destructive
+      --confirm-destructive Ask y/n before fs overwrites, deletes, moves or copies over
+      --show-diff           Print a unified diff to stderr before fs overwrites a file
+      --backup-dir <dir>    Copy files fs overwrites or removes into dir first
+      --strip-ansi          Strip ANSI escape codes from exec tool output
+      --format <fmt>        Output format: text | jsonl (one JSON event per line)
+      --render              Render markdown with ANSI styling when stdout is a terminal
+      --summarize [style]   Summarize the input: bullets (default) | tldr | detailed
+      --redact <regex>      Mask output matching regex with *** (repeatable)
+      --redact-secrets      Mask API keys, tokens, emails, and phone numbers
+  -d, --debug               Enable verbose stderr logging (including tool calls)
+      --log-format <fmt>    Tool log format with -d: text | json
+  -q, --quiet               Print provider and tool errors without their prefix
+      --dump-messages       Print the message array sent to the provider (stderr)
+      --count-tokens        Print an estimated token count for the prompt and exit
   -v, --version             Print version and exit
-  -u, --update              Check for updates via Homebrew
+      --init                Write template config.json and plugins.json
+      --force               With --init, overwrite existing files
+      --set-key <provider>  Store an API key read from stdin in the OS keyring and exit
+  -u, --update              Update to the latest release (Homebrew installs: show upgrade command)
   -h, --help                Show this help message
 
 Examples:
   gogo -P openai -p "Hello"
   gogo -P anthropic < prompt.txt
   cat file.go | gogo -P gemini -p "Review this code"
+  gogo --summarize bullets < article.txt
 
 Environment:
   OPENAI_API_KEY       OpenAI API key
@@ -49,8 +321,17 @@ Environment:
   GEMINI_API_KEY       Google Gemini API key
   GOGO_PROVIDER        Default provider
   GOGO_MODEL           Default model
+  GOGO_BASE_URL        Default --base-url
+  GOGO_API_KEY         openai-compatible API key (see api_key_env)
+  GOGO_CONFIG_DIR      Config directory (default $XDG_CONFIG_HOME/gogo or ~/.config/gogo)
 
-Config: ~/.config/gogo/config.json
+Exit codes:
+  0  Success
+  1  Error
+  3  Provider rejected the API key (HTTP 401/403); retrying will not help
+  4  Provider rate limit, server error (HTTP 429/5xx) or network failure; worth retrying
+
+Config: ~/.config/gogo/config.json (see GOGO_CONFIG_DIR)
 `, version)
 }
 
@@ -66,27 +347,91 @@ func main() {
 	// Short and long flag pairs
 	flag.StringVar(&flags.Prompt, "p", "", "")
 	flag.StringVar(&flags.Prompt, "prompt", "", "")
+	flag.StringVar(&flags.PromptFile, "prompt-file", "", "")
+	flag.BoolVar(&flags.StdinFirst, "stdin-first", false, "")
+	flag.BoolVar(&flags.AllowBinary, "allow-binary", false, "")
 	flag.StringVar(&flags.Provider, "P", "", "")
 	flag.StringVar(&flags.Provider, "provider", "", "")
 	flag.StringVar(&flags.Model, "m", "", "")
 	flag.StringVar(&flags.Model, "model", "", "")
+	flag.StringVar(&flags.BaseURL, "base-url", "", "")
 	flag.IntVar(&flags.MaxTokens, "M", 0, "")
 	flag.IntVar(&flags.MaxTokens, "max-tokens", 0, "")
 	flag.Float64Var(&flags.Temperature, "T", 0, "")
 	flag.Float64Var(&flags.Temperature, "temperature", 0, "")
+	flag.Float64Var(&flags.TopP, "top-p", 0, "")
+	flag.IntVar(&flags.TopK, "top-k", 0, "")
+	flag.IntVar(&flags.MaxToolRounds, "max-tool-rounds", 0, "")
+	flag.BoolVar(&flags.NoStream, "no-stream", false, "")
+	flag.BoolVar(&flags.Raw, "raw", false, "")
+	flag.Var((*commaList)(&flags.Compare), "compare", "")
+	flag.StringVar(&flags.Continue, "continue", "", "")
+	flag.StringVar(&flags.Reasoning, "reasoning-effort", "", "")
+	flag.BoolVar(&flags.ListTools, "list-tools", false, "")
+	flag.StringVar(&flags.EnvFile, "env-file", "", "")
+	flag.Func("thinking-budget", "", func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		flags.ThinkingBudget = &n
+		return nil
+	})
+	flag.BoolVar(&flags.StrictTools, "strict-tools", false, "")
+	flag.Var((*stringList)(&flags.Stop), "stop", "")
+	flag.Var((*stringList)(&flags.Files), "file", "")
+	flag.Var((*stringList)(&flags.Headers), "header", "")
 	flag.StringVar(&flags.ConfigPath, "c", "", "")
 	flag.StringVar(&flags.ConfigPath, "config", "", "")
 	flag.DurationVar(&flags.Timeout, "t", 0, "")
 	flag.DurationVar(&flags.Timeout, "timeout", 0, "")
+	flag.DurationVar(&flags.RequestTimeout, "request-timeout", 0, "")
+	flag.DurationVar(&flags.IdleTimeout, "idle-timeout", 0, "")
+	flag.StringVar(&flags.CancelFile, "cancel-file", "", "")
+	flag.Var((*summarizeFlag)(&flags.Summarize), "summarize", "")
+	flag.StringVar(&flags.System, "s", "", "")
+	flag.StringVar(&flags.System, "system", "", "")
+	flag.StringVar(&flags.SystemFile, "system-file", "", "")
+	flag.StringVar(&flags.AppendSystem, "append-system", "", "")
+	flag.BoolVar(&flags.CacheSystem, "cache-system", false, "")
+	flag.BoolVar(&flags.CountTokens, "count-tokens", false, "")
+	flag.Var((*stringList)(&flags.Redact), "redact", "")
+	flag.Var((*stringList)(&flags.Docs), "doc", "")
+	flag.BoolVar(&flags.StripANSI, "strip-ansi", false, "")
+	flag.BoolVar(&flags.Render, "render", false, "")
+	flag.BoolVar(&flags.ConfirmShell, "confirm-shell", false, "")
+	flag.BoolVar(&flags.ConfirmFS, "confirm-destructive", false, "")
+	flag.BoolVar(&flags.ShowDiff, "show-diff", false, "")
+	flag.StringVar(&flags.BackupDir, "backup-dir", "", "")
+	flag.BoolVar(&flags.Yes, "yes", false, "")
+	flag.StringVar(&flags.Format, "format", "text", "")
+	flag.Var((*commaList)(&flags.Tools), "tools", "")
+	flag.StringVar(&flags.History, "history", "", "")
+	flag.BoolVar(&flags.HistoryAppend, "history-append", false, "")
+	flag.StringVar(&flags.LoadTranscript, "load-transcript", "", "")
+	flag.StringVar(&flags.SaveTranscript, "save-transcript", "", "")
+	flag.BoolVar(&flags.DumpMessages, "dump-messages", false, "")
+	flag.BoolVar(&flags.RedactSecrets, "redact-secrets", false, "")
 	flag.BoolVar(&flags.Debug, "d", false, "")
 	flag.BoolVar(&flags.Debug, "debug", false, "")
+	flag.BoolVar(&flags.Quiet, "q", false, "")
+	flag.BoolVar(&flags.Quiet, "quiet", false, "")
+	flag.StringVar(&flags.LogFormat, "log-format", "", "")
 	flag.BoolVar(&flags.Version, "v", false, "")
 	flag.BoolVar(&flags.Version, "version", false, "")
 	flag.BoolVar(&flags.Update, "u", false, "")
 	flag.BoolVar(&flags.Update, "update", false, "")
+	flag.BoolVar(&flags.Init, "init", false, "")
+	flag.StringVar(&flags.SetKey, "set-key", "", "")
+	flag.BoolVar(&flags.Force, "force", false, "")
+	flag.IntVar(&flags.BudgetCalls, "budget-calls", 0, "")
+	flag.Float64Var(&flags.BudgetUSD, "budget-usd", 0, "")
+	flag.BoolVar(&flags.Cache, "cache", false, "")
+	flag.BoolVar(&flags.NoCache, "no-cache", false, "")
+	flag.DurationVar(&flags.CacheTTL, "cache-ttl", cache.DefaultTTL, "")
 	flag.BoolVar(&showHelp, "h", false, "")
 	flag.BoolVar(&showHelp, "help", false, "")
-	flag.Parse()
+	flag.CommandLine.Parse(joinSummarizeStyle(os.Args[1:]))
 
 	// Show help if requested or no arguments provided
 	if showHelp || (len(os.Args) == 1 && !prompt.HasStdin()) {
@@ -99,11 +444,39 @@ func main() {
 		os.Exit(0)
 	}
 
+	// The update report is the requested output of this action, so it goes
+	// to stdout; failures still go to stderr.
 	if flags.Update {
-		if err := update.Check(stderr, version); err != nil {
-			fmt.Fprintln(stderr, "update check error:", err)
+		if err := update.SelfUpdate(os.Stdout, version); err != nil {
+			fmt.Fprintln(stderr, "update error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if flags.Init {
+		dir, err := config.Dir()
+		if err != nil {
+			fmt.Fprintln(stderr, "init error:", err)
+			os.Exit(1)
+		}
+		paths, err := config.Init(dir, flags.Force)
+		if err != nil {
+			fmt.Fprintln(stderr, "init error:", err)
 			os.Exit(1)
 		}
+		for _, p := range paths {
+			fmt.Fprintln(stderr, "wrote", p)
+		}
+		os.Exit(0)
+	}
+
+	if flags.SetKey != "" {
+		if err := setKey(flags.SetKey); err != nil {
+			fmt.Fprintln(stderr, "keyring error:", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(stderr, "stored %s key in the keyring\n", flags.SetKey)
 		os.Exit(0)
 	}
 
@@ -112,36 +485,305 @@ func main() {
 		fmt.Fprintln(stderr, "config error:", err)
 		os.Exit(1)
 	}
+	for _, w := range cfg.Warnings {
+		fmt.Fprintln(stderr, "config warning:", w)
+	}
 
-	promptText, err := prompt.Read(flags.Prompt)
+	// Skipped tool definitions are printed even without --debug, since
+	// finding out why a tool is missing is the point of the listing.
+	if flags.ListTools {
+		if err := plugin.WriteList(os.Stdout, loadTools(cfg, flags, true)); err != nil {
+			fmt.Fprintln(stderr, "plugin error:", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	if flags.Summarize != "" {
+		system, err := prompt.SummaryPrompt(flags.Summarize)
+		if err != nil {
+			fmt.Fprintln(stderr, "config error:", err)
+			os.Exit(1)
+		}
+		if cfg.System != "" {
+			system += "\n\n" + cfg.System
+		}
+		cfg.System = system
+	}
+
+	if flags.Format != "text" && flags.Format != "jsonl" {
+		fmt.Fprintf(stderr, "config error: invalid format %q: must be text or jsonl\n", flags.Format)
+		os.Exit(1)
+	}
+	jsonl := flags.Format == "jsonl"
+
+	var targets []compare.Target
+	if len(flags.Compare) > 0 {
+		targets, err = compare.ParseTargets(flags.Compare)
+		if err != nil {
+			fmt.Fprintln(stderr, "config error:", err)
+			os.Exit(1)
+		}
+		if jsonl || flags.HistoryAppend || flags.SaveTranscript != "" || flags.Continue != "" {
+			fmt.Fprintln(stderr, "config error: --compare cannot be used with --format jsonl, --history-append, --save-transcript, or --continue")
+			os.Exit(1)
+		}
+		// Concurrent runs would interleave their y/n questions.
+		if (flags.ConfirmShell || flags.ConfirmFS) && !flags.Yes {
+			fmt.Fprintln(stderr, "config error: --compare cannot ask for confirmation; use --yes")
+			os.Exit(1)
+		}
+	}
+
+	prompt.SetAllowBinary(flags.AllowBinary)
+	promptText, err := prompt.ReadWithFile(flags.Prompt, flags.PromptFile, flags.StdinFirst)
+	if errors.Is(err, prompt.ErrNoPrompt) && len(flags.Files) > 0 {
+		err = nil
+	}
+	if errors.Is(err, prompt.ErrNoPrompt) {
+		printUsage()
+		os.Exit(1)
+	}
 	if err != nil {
 		fmt.Fprintln(stderr, "prompt error:", err)
 		os.Exit(1)
 	}
+	if len(flags.Files) > 0 {
+		files, err := prompt.ReadFiles(flags.Files)
+		if err != nil {
+			fmt.Fprintln(stderr, "prompt error:", err)
+			os.Exit(1)
+		}
+		promptText = prompt.Combine(promptText, files, false)
+	}
 	if promptText == "" {
 		fmt.Fprintln(stderr, "prompt error: no prompt provided")
 		os.Exit(1)
 	}
 
-	// Load plugins (tools)
-	tools, err := plugin.LoadWithBuiltins()
-	if err != nil {
-		fmt.Fprintln(stderr, "plugin error:", err)
-		os.Exit(1)
+	// Counts the custom system prompt too, but not tool instructions or
+	// history, so it is a lower bound on the request's input tokens.
+	if flags.CountTokens {
+		n, method := tokenize.Count(cfg.Model, cfg.System+promptText)
+		fmt.Fprintf(stderr, "tokens: ~%d (%s, model %s)\n", n, method, cfg.Model)
+		os.Exit(0)
 	}
 
+	tools := loadTools(cfg, flags, cfg.Debug)
+
 	ctx := context.Background()
 	if cfg.Timeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
 		defer cancel()
 	}
+	if flags.CancelFile != "" {
+		var stop context.CancelFunc
+		ctx, stop = cancel.WatchFile(ctx, flags.CancelFile, cancel.PollInterval)
+		defer stop()
+	}
+
+	var stdout io.Writer = os.Stdout
+	// The spinner shares the terminal with stdout, so it is cleared before
+	// the first byte of output. Notes the providers write to stderr, such
+	// as retries or the round cap, go through providerErr and clear it the
+	// same way. It stays off whenever something else may write to stderr
+	// mid-request: debug logs, diffs or a y/n confirmation.
+	var spin *spinner.Spinner
+	var providerErr io.Writer = stderr
+	if render.IsTerminal(os.Stderr) && !cfg.Quiet && !jsonl && !cfg.Debug && !flags.ShowDiff && !((flags.ConfirmShell || flags.ConfirmFS) && !flags.Yes) {
+		spin = spinner.Start(stderr, spinner.Delay, spinner.Interval)
+		stdout = spin.Writer(stdout)
+		providerErr = spin.Writer(stderr)
+	}
+
+	var out io.Writer = stdout
+	// Rendering needs the whole response, so it sits closest to stdout and
+	// redaction runs on the raw markdown before any escapes are added.
+	var renderer *render.Writer
+	if flags.Render && !jsonl && render.IsTerminal(os.Stdout) {
+		renderer = render.NewWriter(stdout)
+		out = renderer
+	}
+	var redactor *redact.Writer
+	// redacted and redactedJSON apply the same masking to what is saved
+	// outside of stdout.
+	redacted := func(s string) string { return s }
+	redactedJSON := func(b []byte) []byte { return b }
+	if len(flags.Redact) > 0 || flags.RedactSecrets {
+		patterns := flags.Redact
+		if flags.RedactSecrets {
+			patterns = append(append([]string{}, redact.SecretPatterns...), patterns...)
+		}
+		compiled, err := redact.Compile(patterns)
+		if err != nil {
+			fmt.Fprintln(stderr, "config error: invalid redact pattern:", err)
+			os.Exit(1)
+		}
+		redactor = redact.NewWriter(out, compiled)
+		out = redactor
+		redacted = func(s string) string { return redact.String(s, compiled) }
+		redactedJSON = func(b []byte) []byte { return redact.JSON(b, compiled) }
+	}
 
-	client := provider.NewClient(cfg, stderr, tools)
-	if err := client.Stream(ctx, promptText, os.Stdout); err != nil {
-		fmt.Fprintln(stderr, "provider error:", err)
+	var hist []history.Message
+	if flags.History != "" {
+		hist, err = history.Load(flags.History)
+		if err != nil {
+			fmt.Fprintln(stderr, "history error:", err)
+			os.Exit(1)
+		}
+	}
+	if flags.LoadTranscript != "" {
+		turns, err := history.LoadTranscript(flags.LoadTranscript)
+		if err != nil {
+			fmt.Fprintln(stderr, "history error:", err)
+			os.Exit(1)
+		}
+		hist = append(hist, turns...)
+	}
+
+	// Each response is buffered and written as a labeled section once all
+	// of them are done; the per-provider summary goes to stderr.
+	if targets != nil {
+		results := compare.Run(ctx, cfg, targets, hist, promptText, providerErr, tools)
+		werr := compare.Write(out, results)
+		if redactor != nil {
+			_ = redactor.Flush()
+		}
+		if renderer != nil {
+			_ = renderer.Flush()
+		}
+		if spin != nil {
+			spin.Stop()
+		}
+		compare.Summary(stderr, results)
+		if werr != nil {
+			fmt.Fprintln(stderr, "output error:", werr)
+			os.Exit(1)
+		}
+		if err := compare.Err(results); err != nil {
+			os.Exit(providerExitCode(err))
+		}
+		_ = os.Stdout.Sync()
+		return
+	}
+
+	client := provider.NewClient(cfg, providerErr, tools)
+	client.SetHistory(hist)
+	if flags.SaveTranscript != "" {
+		client.RecordTranscript()
+	}
+
+	// In jsonl mode stdout carries events, and out only feeds the history
+	// capture below.
+	if jsonl {
+		client.SetEvents(out)
+		out = io.Discard
+	}
+
+	// response captures the raw text; it is redacted before it is saved.
+	var response bytes.Buffer
+	if flags.HistoryAppend {
+		if flags.History == "" {
+			fmt.Fprintln(stderr, "history error: --history-append requires --history")
+			os.Exit(1)
+		}
+		out = io.MultiWriter(out, &response)
+	}
+
+	// Only deterministic, self-contained requests are cached: the key does
+	// not cover history, attached documents, or a --continue conversation.
+	// A cache hit replays plain text, so jsonl output, transcripts and the
+	// response id --continue chains on always make the request.
+	useCache := flags.Cache && !flags.NoCache && cfg.Temperature <= 0 &&
+		len(hist) == 0 && len(cfg.Docs) == 0 && !jsonl && flags.SaveTranscript == "" &&
+		cfg.ContinueID == ""
+	if useCache {
+		dir, derr := cache.Dir()
+		if derr != nil {
+			fmt.Fprintln(stderr, "cache error:", derr)
+			os.Exit(1)
+		}
+		store := &cache.Store{Dir: dir, TTL: flags.CacheTTL}
+		key := cache.Key{
+			Provider:    cfg.Provider,
+			Model:       cfg.Model,
+			System:      cfg.System,
+			Prompt:      promptText,
+			Temperature: cfg.Temperature,
+			Tools:       tools.GenerateInstruction(),
+
+			AppendSystem: cfg.AppendSystem,
+			Params:       cacheParams(cfg, tools),
+		}
+		var hit bool
+		hit, err = store.Stream(ctx, key, client, out)
+		if hit && cfg.Debug {
+			fmt.Fprintln(stderr, "cache hit:", key.Hash())
+		}
+	} else {
+		err = client.Stream(ctx, promptText, out)
+	}
+	if spin != nil {
+		spin.Stop()
+	}
+	if redactor != nil {
+		_ = redactor.Flush()
+	}
+	if renderer != nil {
+		_ = renderer.Flush()
+	}
+	// Budget and tool failures keep their detail under --quiet; only the
+	// "tool error:" and "provider error:" prefixes are dropped.
+	if errors.Is(err, budget.ErrExceeded) {
+		calls, usd := cfg.Budget.Spent()
+		fmt.Fprintf(stderr, "%v (calls=%d, estimated spend=$%.4f)\n", err, calls, usd)
 		os.Exit(1)
 	}
+	var toolErr *provider.ToolError
+	if errors.As(err, &toolErr) {
+		if cfg.Quiet {
+			fmt.Fprintln(stderr, toolErr.Tool+":", toolErr.Message)
+		} else {
+			fmt.Fprintln(stderr, "tool error:", toolErr.Tool+":", toolErr.Message)
+		}
+		os.Exit(1)
+	}
+	if err != nil {
+		if cfg.Quiet {
+			fmt.Fprintln(stderr, err)
+		} else {
+			fmt.Fprintln(stderr, "provider error:", err)
+		}
+		os.Exit(providerExitCode(err))
+	}
+
+	if flags.HistoryAppend {
+		err := history.Append(flags.History,
+			history.Message{Role: "user", Content: promptText},
+			history.Message{Role: "assistant", Content: redacted(response.String())},
+		)
+		if err != nil {
+			fmt.Fprintln(stderr, "history error:", err)
+			os.Exit(1)
+		}
+	}
+	if flags.SaveTranscript != "" {
+		msgs := client.Transcript()
+		for i := range msgs {
+			msgs[i].Content = redacted(msgs[i].Content)
+			calls := append([]history.ToolCall(nil), msgs[i].ToolCalls...)
+			for j := range calls {
+				calls[j].Input = redactedJSON(calls[j].Input)
+			}
+			msgs[i].ToolCalls = calls
+		}
+		if err := history.SaveTranscript(flags.SaveTranscript, msgs); err != nil {
+			fmt.Fprintln(stderr, "history error:", err)
+			os.Exit(1)
+		}
+	}
 
 	_ = os.Stdout.Sync()
 }